@@ -46,6 +46,8 @@ func InitHandleRouter(cfg *config.Config, r *touka.Engine, version string) {
 		apiRouter.GET("/oci_proxy/status", func(c *touka.Context) {
 			ociProxyStatusHandler(cfg, c)
 		})
+		initCacheRouter(cfg, apiRouter)
+		initBreakerRouter(cfg, apiRouter)
 	}
 }
 
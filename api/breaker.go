@@ -0,0 +1,53 @@
+package api
+
+import (
+	"ghproxy/config"
+	"ghproxy/proxy"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// initBreakerRouter 注册上游熔断器的状态与重置接口, 需要管理令牌
+func initBreakerRouter(cfg *config.Config, apiRouter touka.IRouter) {
+	breakerRouter := apiRouter.Group("/breaker", adminAuthMiddleware(cfg))
+	{
+		breakerRouter.GET("/status", func(c *touka.Context) {
+			breakerStatusHandler(c)
+		})
+		breakerRouter.POST("/reset", func(c *touka.Context) {
+			breakerResetHandler(c)
+		})
+	}
+}
+
+// breakerEnabled 判断熔断是否启用, 未启用时直接响应错误并返回 false
+func breakerEnabled(c *touka.Context) bool {
+	if proxy.HostBreakers() == nil {
+		c.JSON(404, (map[string]interface{}{
+			"error": "Breaker is not enabled",
+		}))
+		return false
+	}
+	return true
+}
+
+func breakerStatusHandler(c *touka.Context) {
+	if !breakerEnabled(c) {
+		return
+	}
+	c.JSON(200, (map[string]interface{}{
+		"hosts": proxy.HostBreakers().Snapshot(),
+	}))
+}
+
+// breakerResetHandler 将 host 的熔断器恢复为 closed, 未指定 host 时重置全部
+func breakerResetHandler(c *touka.Context) {
+	if !breakerEnabled(c) {
+		return
+	}
+	reset := proxy.HostBreakers().Reset(c.Query("host"))
+	c.Infof("%s reset %d breakers (%s)", c.ClientIP(), reset, c.Request.URL.RawQuery)
+	c.JSON(200, (map[string]interface{}{
+		"reset": reset,
+	}))
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"ghproxy/auth"
+	"ghproxy/config"
+	"ghproxy/proxy"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// initCacheRouter 注册缓存管理接口, 需要管理令牌
+func initCacheRouter(cfg *config.Config, apiRouter touka.IRouter) {
+	cacheRouter := apiRouter.Group("/cache", adminAuthMiddleware(cfg))
+	{
+		cacheRouter.GET("/list", func(c *touka.Context) {
+			cacheListHandler(c)
+		})
+		cacheRouter.GET("/stats", func(c *touka.Context) {
+			cacheStatsHandler(c)
+		})
+		cacheRouter.POST("/purge", func(c *touka.Context) {
+			cachePurgeHandler(c)
+		})
+	}
+}
+
+func adminAuthMiddleware(cfg *config.Config) touka.HandlerFunc {
+	return func(c *touka.Context) {
+		isValid, err := auth.AdminAuthHandler(c, cfg)
+		if !isValid {
+			c.Warnf("%s %s %s admin auth failed: %v", c.ClientIP(), c.Request.Method, c.Request.URL.Path, err)
+			c.JSON(401, (map[string]interface{}{
+				"error": err.Error(),
+			}))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// cacheEnabled 判断持久化缓存是否启用, 未启用时直接响应错误并返回 false
+func cacheEnabled(c *touka.Context) bool {
+	if proxy.ArtifactCache() == nil {
+		c.JSON(404, (map[string]interface{}{
+			"error": "Cache is not enabled",
+		}))
+		return false
+	}
+	return true
+}
+
+// cacheListHandler 列出缓存条目, 支持 prefix 与 limit 参数
+func cacheListHandler(c *touka.Context) {
+	if !cacheEnabled(c) {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil {
+		c.JSON(400, (map[string]interface{}{
+			"error": "Invalid limit",
+		}))
+		return
+	}
+	entries := proxy.ArtifactCache().Entries(c.Query("prefix"), limit)
+	items := make([]map[string]interface{}, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, map[string]interface{}{
+			"key":        e.Key,
+			"digest":     e.Digest,
+			"size":       e.Size,
+			"storedAt":   e.StoredAt,
+			"lastAccess": e.LastAccess,
+		})
+	}
+	c.JSON(200, (map[string]interface{}{
+		"entries": items,
+	}))
+}
+
+func cacheStatsHandler(c *touka.Context) {
+	if !cacheEnabled(c) {
+		return
+	}
+	c.JSON(200, proxy.ArtifactCache().Stats())
+}
+
+// cachePurgeHandler 按 url (精确匹配), repo (user/repo) 或 prefix 删除缓存条目
+func cachePurgeHandler(c *touka.Context) {
+	if !cacheEnabled(c) {
+		return
+	}
+	var match func(key string) bool
+	switch {
+	case c.Query("url") != "":
+		target := normalizeCacheURL(c.Query("url"))
+		match = func(key string) bool { return key == target }
+	case c.Query("repo") != "":
+		user, repo, ok := strings.Cut(c.Query("repo"), "/")
+		if !ok || user == "" || repo == "" || strings.Contains(repo, "/") {
+			c.JSON(400, (map[string]interface{}{
+				"error": "repo must be in the form user/repo",
+			}))
+			return
+		}
+		match = func(key string) bool { return cacheKeyMatchesRepo(key, user, repo) }
+	case c.Query("prefix") != "":
+		prefix := c.Query("prefix")
+		match = func(key string) bool { return strings.HasPrefix(key, prefix) }
+	default:
+		c.JSON(400, (map[string]interface{}{
+			"error": "One of url, repo or prefix is required",
+		}))
+		return
+	}
+	purged := proxy.ArtifactCache().DeleteFunc(match)
+	c.Infof("%s purged %d cache entries (%s)", c.ClientIP(), purged, c.Request.URL.RawQuery)
+	c.JSON(200, (map[string]interface{}{
+		"purged": purged,
+	}))
+}
+
+// normalizeCacheURL 将用户提供的 URL 转换为缓存键的形式: 补全协议并去除查询参数
+func normalizeCacheURL(u string) string {
+	if !strings.Contains(u, "://") && !strings.HasPrefix(u, "oci/") {
+		u = "https://" + u
+	}
+	if i := strings.IndexByte(u, '?'); i >= 0 {
+		u = u[:i]
+	}
+	return u
+}
+
+// cacheKeyMatchesRepo 判断缓存键是否属于 user/repo, 不区分大小写
+//
+//	https://github.com/:user/:repo/...
+//	https://raw.githubusercontent.com/:user/:repo/...
+//	https://api.github.com/repos/:user/:repo/...
+//	oci/manifests/:target/:user/:repo/:ref[#accept]
+func cacheKeyMatchesRepo(key, user, repo string) bool {
+	var parts []string
+	if rest, ok := strings.CutPrefix(key, "oci/manifests/"); ok {
+		parts = strings.Split(rest, "/")
+		if len(parts) < 3 {
+			return false
+		}
+		parts = parts[1:]
+	} else {
+		parsed, err := url.Parse(key)
+		if err != nil {
+			return false
+		}
+		parts = strings.Split(strings.TrimPrefix(parsed.Path, "/"), "/")
+		if parsed.Host == "api.github.com" && len(parts) > 0 && parts[0] == "repos" {
+			parts = parts[1:]
+		}
+	}
+	if len(parts) < 2 {
+		return false
+	}
+	return strings.EqualFold(parts[0], user) && strings.EqualFold(strings.TrimSuffix(parts[1], ".git"), repo)
+}
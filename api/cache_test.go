@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"ghproxy/config"
+	"ghproxy/proxy"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/fenthope/reco"
+	"github.com/infinite-iroha/touka"
+)
+
+func TestCacheRouter(t *testing.T) {
+	cfg := &config.Config{
+		Admin: config.AdminConfig{Enabled: true, Token: "secret"},
+		Cache: config.CacheConfig{Enabled: true, Dir: t.TempDir(), MaxSize: 16, ManifestTTL: "10m"},
+	}
+	logger, err := reco.New(reco.Config{Output: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	r := touka.New()
+	r.SetLogger(logger)
+	initCacheRouter(cfg, r.Group("/api"))
+
+	do := func(method, target, token string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s %s: invalid JSON %q: %v", method, target, rec.Body.String(), err)
+		}
+		return rec.Code, body
+	}
+	keys := func() []string {
+		t.Helper()
+		code, body := do(http.MethodGet, "/api/cache/list", "secret")
+		if code != http.StatusOK {
+			t.Fatalf("list = %d %v", code, body)
+		}
+		var keys []string
+		for _, e := range body["entries"].([]interface{}) {
+			keys = append(keys, e.(map[string]interface{})["key"].(string))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	// 未提供令牌或令牌错误时拒绝, 不会到达处理函数
+	for _, token := range []string{"", "wrong"} {
+		if code, body := do(http.MethodGet, "/api/cache/stats", token); code != http.StatusUnauthorized || body["error"] == nil {
+			t.Errorf("token %q: stats = %d %v, want 401", token, code, body)
+		}
+		if code, _ := do(http.MethodPost, "/api/cache/purge?prefix=https://", token); code != http.StatusUnauthorized {
+			t.Errorf("token %q: purge = %d, want 401", token, code)
+		}
+	}
+
+	// 缓存未启用
+	if proxy.ArtifactCache() == nil {
+		if code, _ := do(http.MethodGet, "/api/cache/stats", "secret"); code != http.StatusNotFound {
+			t.Errorf("stats without cache = %d, want 404", code)
+		}
+	}
+
+	if err := proxy.InitCache(cfg); err != nil {
+		t.Fatal(err)
+	}
+	store := proxy.ArtifactCache()
+	all := []string{
+		"https://github.com/u/a/releases/download/v1/a.zip",
+		"https://github.com/u/a/releases/download/v1/b.zip",
+		"https://raw.githubusercontent.com/u/b/main/README.md",
+		"oci/manifests/docker.io/u/a/latest",
+	}
+	for _, key := range all {
+		w, err := store.Create(key, http.Header{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(key))
+		if _, err := w.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := keys(); len(got) != len(all) {
+		t.Fatalf("list = %v, want %d entries", got, len(all))
+	}
+	code, body := do(http.MethodGet, "/api/cache/list?prefix=https://raw.githubusercontent.com/", "secret")
+	if entries := body["entries"].([]interface{}); code != http.StatusOK || len(entries) != 1 {
+		t.Errorf("list by prefix = %d %v", code, body)
+	}
+	if code, _ := do(http.MethodGet, "/api/cache/list?limit=x", "secret"); code != http.StatusBadRequest {
+		t.Errorf("list with invalid limit = %d, want 400", code)
+	}
+
+	code, body = do(http.MethodGet, "/api/cache/stats", "secret")
+	if code != http.StatusOK || body["entries"] != float64(len(all)) || body["bytes"].(float64) <= 0 {
+		t.Errorf("stats = %d %v", code, body)
+	}
+
+	for _, target := range []string{"/api/cache/purge", "/api/cache/purge?repo=u"} {
+		if code, _ := do(http.MethodPost, target, "secret"); code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", target, code)
+		}
+	}
+
+	// 按 URL 精确删除, URL 可省略协议与查询参数
+	code, body = do(http.MethodPost, "/api/cache/purge?url=github.com/u/a/releases/download/v1/a.zip%3Ftoken%3Dx", "secret")
+	if code != http.StatusOK || body["purged"] != float64(1) {
+		t.Errorf("purge by url = %d %v", code, body)
+	}
+	// 按前缀删除
+	code, body = do(http.MethodPost, "/api/cache/purge?prefix=https://raw.githubusercontent.com/", "secret")
+	if code != http.StatusOK || body["purged"] != float64(1) {
+		t.Errorf("purge by prefix = %d %v", code, body)
+	}
+	// 按仓库删除, 包括 OCI manifest
+	code, body = do(http.MethodPost, "/api/cache/purge?repo=U/A", "secret")
+	if code != http.StatusOK || body["purged"] != float64(2) {
+		t.Errorf("purge by repo = %d %v", code, body)
+	}
+	if got := keys(); len(got) != 0 {
+		t.Errorf("entries left after purge: %v", got)
+	}
+}
+
+func TestCacheKeyMatchesRepo(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"https://github.com/u/r/archive/refs/tags/v1.tar.gz", true},
+		{"https://github.com/u/r.git/info/refs", true},
+		{"https://raw.githubusercontent.com/U/R/main/a", true},
+		{"https://api.github.com/repos/u/r/releases/latest", true},
+		{"oci/manifests/ghcr.io/u/r/latest#0123456789abcdef", true},
+		{"https://github.com/u/rr/archive/main.zip", false},
+		{"https://github.com/other/r/archive/main.zip", false},
+		{"https://api.github.com/users/u/r", false},
+		{"oci/manifests/ghcr.io/u", false},
+	}
+	for _, tt := range tests {
+		if got := cacheKeyMatchesRepo(tt.key, "u", "r"); got != tt.want {
+			t.Errorf("cacheKeyMatchesRepo(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
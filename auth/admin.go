@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"ghproxy/config"
+	"strings"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// AdminAuthHandler 校验管理接口的令牌, 与公共的 AuthConfig 相互独立
+// 未启用或未设置令牌时一律拒绝
+func AdminAuthHandler(c *touka.Context, cfg *config.Config) (isValid bool, err error) {
+	if !cfg.Admin.Enabled || cfg.Admin.Token == "" {
+		return false, fmt.Errorf("Admin API is disabled")
+	}
+	authToken, ok := strings.CutPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+	if !ok || authToken == "" {
+		return false, fmt.Errorf("Admin token not found")
+	}
+	if subtle.ConstantTimeCompare([]byte(authToken), []byte(cfg.Admin.Token)) != 1 {
+		return false, fmt.Errorf("Admin token incorrect")
+	}
+	return true, nil
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"ghproxy/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestAdminAuthHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		token   string
+		header  string
+		want    bool
+	}{
+		{"Disabled", false, "secret", "Bearer secret", false},
+		{"EmptyToken", true, "", "Bearer ", false},
+		{"NoHeader", true, "secret", "", false},
+		{"NotBearer", true, "secret", "Basic secret", false},
+		{"WrongToken", true, "secret", "Bearer wrong", false},
+		{"Prefix", true, "secret", "Bearer secre", false},
+		{"Valid", true, "secret", "Bearer secret", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Admin: config.AdminConfig{Enabled: tt.enabled, Token: tt.token}}
+			req := httptest.NewRequest(http.MethodGet, "/api/cache/stats", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			c, _ := touka.CreateTestContextWithRequest(httptest.NewRecorder(), req)
+			got, err := AdminAuthHandler(c, cfg)
+			if got != tt.want {
+				t.Errorf("AdminAuthHandler = %v (%v), want %v", got, err, tt.want)
+			}
+			if !got && err == nil {
+				t.Error("rejected without an error")
+			}
+		})
+	}
+}
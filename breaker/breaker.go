@@ -0,0 +1,277 @@
+// Package breaker 为每个上游主机提供独立的熔断器
+//
+// 熔断器有三种状态:
+//   - closed: 正常放行请求, 在统计窗口内错误率超过阈值后进入 open
+//   - open: 直接拒绝请求, 经过 openTimeout 后进入 half-open
+//   - half-open: 只放行少量探测请求, 全部成功后回到 closed, 任一失败则重新进入 open
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrOpen 表示熔断器处于打开状态, 请求被直接拒绝
+var ErrOpen = errors.New("breaker: circuit open")
+
+// OpenError 描述被熔断器拒绝的请求
+type OpenError struct {
+	Host       string
+	RetryAfter time.Duration // 距离下一次探测的时间
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("breaker: circuit open for %s, retry after %s", e.Host, e.RetryAfter.Round(time.Second))
+}
+
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// State 为熔断器的状态
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Outcome 为一次请求的结果
+type Outcome int
+
+const (
+	Success Outcome = iota
+	Failure
+	// Ignored 表示结果不计入统计, 例如客户端主动取消的请求
+	Ignored
+)
+
+// Settings 定义熔断器的参数
+type Settings struct {
+	Window         time.Duration // closed 状态下的统计窗口, 窗口结束后计数清零
+	MinRequests    int           // 窗口内请求数达到该值后才判断错误率
+	ErrorRate      float64       // 触发熔断的错误率 (0-1)
+	OpenTimeout    time.Duration // open 状态持续的时间
+	HalfOpenProbes int           // half-open 状态下允许的探测请求数
+}
+
+// Group 按主机管理熔断器
+type Group struct {
+	settings Settings
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewGroup 创建熔断器组
+func NewGroup(settings Settings) *Group {
+	if settings.HalfOpenProbes <= 0 {
+		settings.HalfOpenProbes = 1
+	}
+	if settings.MinRequests <= 0 {
+		settings.MinRequests = 1
+	}
+	return &Group{
+		settings: settings,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Get 返回 host 对应的熔断器, 不存在时创建
+func (g *Group) Get(host string) *Breaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.breakers[host]
+	if !ok {
+		b = &Breaker{
+			host:        host,
+			settings:    &g.settings,
+			windowStart: time.Now(),
+		}
+		g.breakers[host] = b
+	}
+	return b
+}
+
+// Status 为熔断器状态的快照
+type Status struct {
+	Host     string    `json:"host"`
+	State    string    `json:"state"`
+	Requests int       `json:"requests"` // 当前窗口内的请求数
+	Failures int       `json:"failures"` // 当前窗口内的失败数
+	OpenedAt time.Time `json:"openedAt,omitzero"`
+	Trips    int64     `json:"trips"` // 累计进入 open 状态的次数
+}
+
+// Snapshot 返回所有熔断器的状态, 按主机排序
+func (g *Group) Snapshot() []Status {
+	g.mu.Lock()
+	breakers := make([]*Breaker, 0, len(g.breakers))
+	for _, b := range g.breakers {
+		breakers = append(breakers, b)
+	}
+	g.mu.Unlock()
+
+	out := make([]Status, 0, len(breakers))
+	for _, b := range breakers {
+		out = append(out, b.Status())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// Reset 将 host 的熔断器恢复为 closed, host 为空时重置全部, 返回重置的数量
+func (g *Group) Reset(host string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := 0
+	for h, b := range g.breakers {
+		if host != "" && h != host {
+			continue
+		}
+		b.mu.Lock()
+		b.setStateLocked(StateClosed, time.Now())
+		b.mu.Unlock()
+		n++
+	}
+	return n
+}
+
+// Breaker 为单个主机的熔断器
+type Breaker struct {
+	host     string
+	settings *Settings
+
+	mu          sync.Mutex
+	state       State
+	generation  uint64 // 每次状态变化时递增, 用于丢弃旧状态下发出的请求的结果
+	windowStart time.Time
+	requests    int
+	failures    int
+	probes      int // half-open 状态下进行中的探测请求数
+	successes   int // half-open 状态下成功的探测请求数
+	openedAt    time.Time
+	trips       int64
+}
+
+// Allow 判断是否放行请求, 放行时返回的 done 须在请求结束后以结果调用一次
+// 拒绝时返回 *OpenError
+func (b *Breaker) Allow() (func(Outcome), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case StateClosed:
+		if now.Sub(b.windowStart) >= b.settings.Window {
+			b.windowStart = now
+			b.requests, b.failures = 0, 0
+		}
+	case StateOpen:
+		retryAt := b.openedAt.Add(b.settings.OpenTimeout)
+		if now.Before(retryAt) {
+			return nil, &OpenError{Host: b.host, RetryAfter: retryAt.Sub(now)}
+		}
+		b.setStateLocked(StateHalfOpen, now)
+		fallthrough
+	case StateHalfOpen:
+		if b.probes >= b.settings.HalfOpenProbes {
+			return nil, &OpenError{Host: b.host, RetryAfter: b.settings.OpenTimeout}
+		}
+		b.probes++
+	}
+
+	generation := b.generation
+	var once sync.Once
+	return func(outcome Outcome) {
+		once.Do(func() { b.done(generation, outcome) })
+	}, nil
+}
+
+// done 记录一次请求的结果
+func (b *Breaker) done(generation uint64, outcome Outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	now := time.Now()
+	switch b.state {
+	case StateClosed:
+		if outcome == Ignored {
+			return
+		}
+		b.requests++
+		if outcome == Failure {
+			b.failures++
+			if b.requests >= b.settings.MinRequests && float64(b.failures) >= b.settings.ErrorRate*float64(b.requests) {
+				b.setStateLocked(StateOpen, now)
+			}
+		}
+	case StateHalfOpen:
+		b.probes--
+		switch outcome {
+		case Failure:
+			b.setStateLocked(StateOpen, now)
+		case Success:
+			b.successes++
+			if b.successes >= b.settings.HalfOpenProbes {
+				b.setStateLocked(StateClosed, now)
+			}
+		}
+	}
+}
+
+func (b *Breaker) setStateLocked(state State, now time.Time) {
+	if state == StateOpen && b.state != StateOpen {
+		b.trips++
+	}
+	b.state = state
+	b.generation++
+	b.windowStart = now
+	b.requests, b.failures = 0, 0
+	b.probes, b.successes = 0, 0
+	if state == StateOpen {
+		b.openedAt = now
+	} else if state == StateClosed {
+		b.openedAt = time.Time{}
+	}
+}
+
+// State 返回熔断器当前的状态
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Status 返回熔断器状态的快照
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Status{
+		Host:     b.host,
+		State:    b.state.String(),
+		Requests: b.requests,
+		Failures: b.failures,
+		OpenedAt: b.openedAt,
+		Trips:    b.trips,
+	}
+}
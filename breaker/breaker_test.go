@@ -0,0 +1,79 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker_Transitions(t *testing.T) {
+	g := NewGroup(Settings{
+		Window:         time.Minute,
+		MinRequests:    4,
+		ErrorRate:      0.5,
+		OpenTimeout:    20 * time.Millisecond,
+		HalfOpenProbes: 1,
+	})
+	b := g.Get("example.com")
+
+	record := func(outcome Outcome) {
+		t.Helper()
+		done, err := b.Allow()
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		done(outcome)
+	}
+
+	record(Success)
+	record(Failure)
+	record(Failure)
+	if b.State() != StateClosed {
+		t.Fatalf("state = %s before minRequests, want closed", b.State())
+	}
+	record(Failure)
+	if b.State() != StateOpen {
+		t.Fatalf("state = %s, want open", b.State())
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Allow while open = %v, want ErrOpen", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("second request during probe = %v, want ErrOpen", err)
+	}
+	probe(Failure)
+	if b.State() != StateOpen {
+		t.Fatalf("state = %s after failed probe, want open", b.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	record(Success)
+	if b.State() != StateClosed {
+		t.Fatalf("state = %s after successful probe, want closed", b.State())
+	}
+	if st := b.Status(); st.Trips != 2 {
+		t.Errorf("trips = %d, want 2", st.Trips)
+	}
+}
+
+func TestBreaker_IgnoresStaleOutcome(t *testing.T) {
+	g := NewGroup(Settings{Window: time.Minute, MinRequests: 1, ErrorRate: 1, OpenTimeout: time.Minute})
+	b := g.Get("example.com")
+	slow, _ := b.Allow()
+	done, _ := b.Allow()
+	done(Failure)
+	if g.Reset("example.com") != 1 {
+		t.Fatal("Reset should reset one breaker")
+	}
+	// 重置前发出的请求的结果不再计入
+	slow(Failure)
+	if b.State() != StateClosed {
+		t.Errorf("state = %s, want closed", b.State())
+	}
+}
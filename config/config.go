@@ -13,19 +13,26 @@ import (
 
 // Config 结构体定义了整个应用程序的配置
 type Config struct {
-	Server    ServerConfig    `toml:"server" wanf:"server"`
-	Httpc     HttpcConfig     `toml:"httpc" wanf:"httpc"`
-	GitClone  GitCloneConfig  `toml:"gitclone" wanf:"gitclone"`
-	Shell     ShellConfig     `toml:"shell" wanf:"shell"`
-	Pages     PagesConfig     `toml:"pages" wanf:"pages"`
-	Log       LogConfig       `toml:"log" wanf:"log"`
-	Auth      AuthConfig      `toml:"auth" wanf:"auth"`
-	Blacklist BlacklistConfig `toml:"blacklist" wanf:"blacklist"`
-	Whitelist WhitelistConfig `toml:"whitelist" wanf:"whitelist"`
-	IPFilter  IPFilterConfig  `toml:"ipFilter" wanf:"ipFilter"`
-	RateLimit RateLimitConfig `toml:"rateLimit" wanf:"rateLimit"`
-	Outbound  OutboundConfig  `toml:"outbound" wanf:"outbound"`
-	Docker    DockerConfig    `toml:"docker" wanf:"docker"`
+	Server       ServerConfig       `toml:"server" wanf:"server"`
+	Httpc        HttpcConfig        `toml:"httpc" wanf:"httpc"`
+	GitClone     GitCloneConfig     `toml:"gitclone" wanf:"gitclone"`
+	Shell        ShellConfig        `toml:"shell" wanf:"shell"`
+	Pages        PagesConfig        `toml:"pages" wanf:"pages"`
+	Log          LogConfig          `toml:"log" wanf:"log"`
+	Auth         AuthConfig         `toml:"auth" wanf:"auth"`
+	Blacklist    BlacklistConfig    `toml:"blacklist" wanf:"blacklist"`
+	Whitelist    WhitelistConfig    `toml:"whitelist" wanf:"whitelist"`
+	IPFilter     IPFilterConfig     `toml:"ipFilter" wanf:"ipFilter"`
+	RateLimit    RateLimitConfig    `toml:"rateLimit" wanf:"rateLimit"`
+	Outbound     OutboundConfig     `toml:"outbound" wanf:"outbound"`
+	DNS          DNSConfig          `toml:"dns" wanf:"dns"`
+	Mirrors      MirrorsConfig      `toml:"mirrors" wanf:"mirrors"`
+	GithubTokens GithubTokensConfig `toml:"githubTokens" wanf:"githubTokens"`
+	Instances    []InstanceConfig   `toml:"instances" wanf:"instances"`
+	Docker       DockerConfig       `toml:"docker" wanf:"docker"`
+	Cache        CacheConfig        `toml:"cache" wanf:"cache"`
+	Admin        AdminConfig        `toml:"admin" wanf:"admin"`
+	CacheControl CacheControlConfig `toml:"cacheControl" wanf:"cacheControl"`
 }
 
 /*
@@ -55,29 +62,157 @@ maxIdleConns = 100 # only for advanced mode
 maxIdleConnsPerHost = 60 # only for advanced mode
 maxConnsPerHost = 0 # only for advanced mode
 useCustomRawHeaders = false
+[httpc.retry]
+enabled = false # 对 GET/HEAD 请求在连接失败或上游返回可重试的状态码时自动重试, 未启用时使用 httpc 的默认重试
+maxRetries = 2
+baseDelay = "200ms" # 指数退避的初始延迟, 实际延迟在 0 与退避值之间随机取值
+maxDelay = "5s"
+budget = "15s" # 单个请求用于重试的总时间上限 (含 Retry-After 等待), 超出后返回最后一次的结果
+statuses = [429, 502, 503, 504]
+[httpc.breaker]
+enabled = false # 按上游主机熔断, 错误率过高时直接返回 503, 不再等待上游超时
+window = "60s" # 错误率统计窗口
+minRequests = 20 # 窗口内请求数达到该值后才判断错误率
+errorRate = 0.5 # 连接失败与 5xx 响应占比达到该值时熔断
+openTimeout = "30s" # 熔断持续时间, 之后放行探测请求
+halfOpenProbes = 1 # 探测请求数, 全部成功后恢复
+[httpc.timeouts] # 主客户端的上游超时, dial 与 tlsHandshake 为 "0" 时使用默认值, 其余为 "0" 时不限制
+dial = "10s" # 建立 TCP 连接 (含连接代理)
+tlsHandshake = "10s"
+responseHeader = "30s" # 发出请求后等待响应头
+bodyStall = "60s" # 读取响应体时上游在该时间内没有发送任何数据则中止传输
+[httpc.gitTimeouts] # git 客户端 (gitclone mode = "cache") 的上游超时, 上游生成 pack 可能较慢
+dial = "10s"
+tlsHandshake = "10s"
+responseHeader = "120s"
+bodyStall = "120s"
+[httpc.resume]
+enabled = false # 上游连接在传输中断开时, 对带强 ETag 与 Content-Length 的响应以 Range 续传, 客户端不会察觉
+maxAttempts = 3 # 单个响应最多续传次数
+[httpc.segmented]
+enabled = false # releases 资源超过阈值时以多个 Range 请求并行下载, 按顺序拼接后发送给客户端 (须带有强 ETag)
+threshold = 64 # MB, 应小于 server.sizeLimit
+connections = 4 # 单个下载的并发请求数, 内存占用上限为 connections * chunkSize
+chunkSize = 4 # MB
+maxRetries = 3 # 单个分段失败后的重试次数
+[httpc.source]
+enabled = false # 将上游连接绑定到本机的源地址, 经出站代理时绑定的是与代理的连接
+addrs = [] # 本机地址或网卡名, 如 "203.0.113.10", "2001:db8::10", "eth1" (使用网卡上除回环与链路本地地址外的全部地址)
+strategy = "round-robin" # "round-robin" 或 "client-hash" (按客户端 IP 哈希, 同一客户端固定使用同一源地址)
+ipFamily = "" # "" 不限制, "prefer-ipv4"/"prefer-ipv6" 优先使用, "ipv4"/"ipv6" 只使用该协议族
+[[httpc.tls]] # 按上游主机指定 TLS 设置, 按顺序匹配, 第一条匹配的规则生效; 主机为 HTTPS 出站代理时作用于与代理的连接
+hosts = ["ghe.corp.example.com", "*.corp.example.com"] # 上游主机, 支持通配符
+caFiles = ["/data/ghproxy/config/corp-ca.pem"] # 附加信任的 CA 证书 (PEM), 系统根证书仍然有效
+certFile = "" # 客户端证书 (PEM), 须与 keyFile 同时设置
+keyFile = ""
+minVersion = "1.2" # "1.0" / "1.1" / "1.2" / "1.3", 为空时使用 Go 的默认值
+spkiPins = [] # 证书链中须包含公钥与之匹配的证书, 为 SubjectPublicKeyInfo 的 SHA-256 (base64), 如 "sha256/AAAA..."
 */
 // HttpcConfig 定义 HTTP 客户端相关的配置
 type HttpcConfig struct {
-	Mode                string `toml:"mode" wanf:"mode"`
-	MaxIdleConns        int    `toml:"maxIdleConns" wanf:"maxIdleConns"`
-	MaxIdleConnsPerHost int    `toml:"maxIdleConnsPerHost" wanf:"maxIdleConnsPerHost"`
-	MaxConnsPerHost     int    `toml:"maxConnsPerHost" wanf:"maxConnsPerHost"`
-	UseCustomRawHeaders bool   `toml:"useCustomRawHeaders" wanf:"useCustomRawHeaders"`
+	Mode                string              `toml:"mode" wanf:"mode"`
+	MaxIdleConns        int                 `toml:"maxIdleConns" wanf:"maxIdleConns"`
+	MaxIdleConnsPerHost int                 `toml:"maxIdleConnsPerHost" wanf:"maxIdleConnsPerHost"`
+	MaxConnsPerHost     int                 `toml:"maxConnsPerHost" wanf:"maxConnsPerHost"`
+	UseCustomRawHeaders bool                `toml:"useCustomRawHeaders" wanf:"useCustomRawHeaders"`
+	Retry               RetryConfig         `toml:"retry" wanf:"retry"`
+	Breaker             BreakerConfig       `toml:"breaker" wanf:"breaker"`
+	Timeouts            TimeoutsConfig      `toml:"timeouts" wanf:"timeouts"`
+	GitTimeouts         TimeoutsConfig      `toml:"gitTimeouts" wanf:"gitTimeouts"`
+	Resume              ResumeConfig        `toml:"resume" wanf:"resume"`
+	Segmented           SegmentedConfig     `toml:"segmented" wanf:"segmented"`
+	Source              SourceConfig        `toml:"source" wanf:"source"`
+	TLS                 []UpstreamTLSConfig `toml:"tls" wanf:"tls"`
+}
+
+// UpstreamTLSConfig 定义与一组上游主机建立 TLS 连接时使用的设置
+type UpstreamTLSConfig struct {
+	Hosts      []string `toml:"hosts" wanf:"hosts"`
+	CAFiles    []string `toml:"caFiles" wanf:"caFiles"`
+	CertFile   string   `toml:"certFile" wanf:"certFile"`
+	KeyFile    string   `toml:"keyFile" wanf:"keyFile"`
+	MinVersion string   `toml:"minVersion" wanf:"minVersion"`
+	SPKIPins   []string `toml:"spkiPins" wanf:"spkiPins"`
+}
+
+// SourceConfig 定义上游连接源地址与协议族的配置
+type SourceConfig struct {
+	Enabled  bool     `toml:"enabled" wanf:"enabled"`
+	Addrs    []string `toml:"addrs" wanf:"addrs"`
+	Strategy string   `toml:"strategy" wanf:"strategy"`
+	IPFamily string   `toml:"ipFamily" wanf:"ipFamily"`
+}
+
+// SegmentedConfig 定义大文件分段并行下载的配置
+type SegmentedConfig struct {
+	Enabled     bool `toml:"enabled" wanf:"enabled"`
+	Threshold   int  `toml:"threshold" wanf:"threshold"`
+	Connections int  `toml:"connections" wanf:"connections"`
+	ChunkSize   int  `toml:"chunkSize" wanf:"chunkSize"`
+	MaxRetries  int  `toml:"maxRetries" wanf:"maxRetries"`
+}
+
+// ResumeConfig 定义上游下载中断后续传的配置
+type ResumeConfig struct {
+	Enabled     bool `toml:"enabled" wanf:"enabled"`
+	MaxAttempts int  `toml:"maxAttempts" wanf:"maxAttempts"`
+}
+
+// TimeoutsConfig 定义上游请求各阶段的超时
+type TimeoutsConfig struct {
+	Dial           string `toml:"dial" wanf:"dial"`
+	TLSHandshake   string `toml:"tlsHandshake" wanf:"tlsHandshake"`
+	ResponseHeader string `toml:"responseHeader" wanf:"responseHeader"`
+	BodyStall      string `toml:"bodyStall" wanf:"bodyStall"`
+}
+
+// BreakerConfig 定义按上游主机熔断的配置
+type BreakerConfig struct {
+	Enabled        bool    `toml:"enabled" wanf:"enabled"`
+	Window         string  `toml:"window" wanf:"window"`
+	MinRequests    int     `toml:"minRequests" wanf:"minRequests"`
+	ErrorRate      float64 `toml:"errorRate" wanf:"errorRate"`
+	OpenTimeout    string  `toml:"openTimeout" wanf:"openTimeout"`
+	HalfOpenProbes int     `toml:"halfOpenProbes" wanf:"halfOpenProbes"`
+}
+
+// RetryConfig 定义上游请求的重试策略
+type RetryConfig struct {
+	Enabled    bool   `toml:"enabled" wanf:"enabled"`
+	MaxRetries int    `toml:"maxRetries" wanf:"maxRetries"`
+	BaseDelay  string `toml:"baseDelay" wanf:"baseDelay"`
+	MaxDelay   string `toml:"maxDelay" wanf:"maxDelay"`
+	Budget     string `toml:"budget" wanf:"budget"`
+	Statuses   []int  `toml:"statuses" wanf:"statuses"`
 }
 
 /*
 [gitclone]
-mode = "bypass" # bypass / cache
+mode = "bypass" # bypass / cache / mirror
 smartGitAddr = "http://127.0.0.1:8080"
 //cacheTimeout = 10
 ForceH2C = true
+# mirror 模式下由 git 子进程克隆 github.com, 使用出站代理与匹配的 [[httpc.tls]] 规则 (客户端证书, 最低版本, 公钥固定)
+# 不支持 [dns], [httpc.source], socks5 代理链与规则中的 caFiles, 启用时拒绝启动
+mirrorDir = "/data/ghproxy/git" # mirror 模式下本地裸仓库的存放目录
+mirrorRefresh = "5m" # mirror 模式下向上游刷新的最小间隔
+mirrorRepos = [] # 使用本地镜像的仓库, 如 "user/repo", "user/*", 为空时不使用本地镜像
+mirrorMaxClones = 2 # 同时进行的首次克隆数上限, 超出时请求直接发往上游
+mirrorMaxSize = 10240 # MB, 镜像总大小上限, 超出时删除最久未使用的镜像, 0 表示不限制
+mirrorIdle = "168h" # 超过该时间未被克隆的镜像将被删除, "0" 表示不删除
 */
 // GitCloneConfig 定义 Git 克隆相关的配置
 type GitCloneConfig struct {
 	Mode         string `toml:"mode" wanf:"mode"`
 	SmartGitAddr string `toml:"smartGitAddr" wanf:"smartGitAddr"`
 	//CacheTimeout int    `toml:"cacheTimeout"`
-	ForceH2C bool `toml:"ForceH2C" wanf:"ForceH2C"`
+	ForceH2C        bool     `toml:"ForceH2C" wanf:"ForceH2C"`
+	MirrorDir       string   `toml:"mirrorDir" wanf:"mirrorDir"`
+	MirrorRefresh   string   `toml:"mirrorRefresh" wanf:"mirrorRefresh"`
+	MirrorRepos     []string `toml:"mirrorRepos" wanf:"mirrorRepos"`
+	MirrorMaxClones int      `toml:"mirrorMaxClones" wanf:"mirrorMaxClones"`
+	MirrorMaxSize   int      `toml:"mirrorMaxSize" wanf:"mirrorMaxSize"`
+	MirrorIdle      string   `toml:"mirrorIdle" wanf:"mirrorIdle"`
 }
 
 /*
@@ -186,12 +321,127 @@ type BandwidthLimitConfig struct {
 /*
 [outbound]
 enabled = false
-url = "socks5://127.0.0.1:1080" # "http://127.0.0.1:7890"
+url = "socks5://127.0.0.1:1080" # "http://127.0.0.1:7890", 多个 socks5 地址以逗号分隔时构成代理链
+pool = [] # 可互相替代的出站代理列表, 如 ["socks5://10.0.0.1:1080", "http://10.0.0.2:3128"], 非空时代替 url
+strategy = "round-robin" # round-robin / least-conn
+healthCheckInterval = "30s"
+healthCheckTimeout = "5s"
+healthCheckUrl = "" # 为空时只检查能否连接到代理, 否则经代理请求该地址, 响应状态码小于 500 视为健康
+failThreshold = 2 # 连续失败 (健康检查或连接代理失败) 达到该次数后暂停使用, 健康检查恢复后重新启用
+ejectCooldown = "30s" # healthCheckInterval 为 "0" (不进行健康检查) 时, 暂停的代理在该时间后重新启用, 再次失败时立即暂停
+[outbound.proxies] # 供 routes 引用的命名代理, 多个 socks5 地址以逗号分隔时构成代理链
+hub = "socks5://127.0.0.1:1080"
+[[outbound.routes]] # 按顺序匹配, 第一条匹配的规则生效, 均不匹配时使用 url/pool; 不受 enabled 影响
+hosts = ["registry-1.docker.io", "*.docker.io"] # 上游主机, 支持通配符, 为空时匹配所有主机
+matchers = ["docker"] # releases/raw/gist/api/clone/docker, 为空时匹配所有请求
+via = "hub" # direct 或 proxies 中的名称
 */
 // OutboundConfig 定义出站代理相关的配置
 type OutboundConfig struct {
-	Enabled bool   `toml:"enabled" wanf:"enabled"`
-	Url     string `toml:"url" wanf:"url"`
+	Enabled             bool                  `toml:"enabled" wanf:"enabled"`
+	Url                 string                `toml:"url" wanf:"url"`
+	Pool                []string              `toml:"pool" wanf:"pool"`
+	Strategy            string                `toml:"strategy" wanf:"strategy"`
+	HealthCheckInterval string                `toml:"healthCheckInterval" wanf:"healthCheckInterval"`
+	HealthCheckTimeout  string                `toml:"healthCheckTimeout" wanf:"healthCheckTimeout"`
+	HealthCheckUrl      string                `toml:"healthCheckUrl" wanf:"healthCheckUrl"`
+	FailThreshold       int                   `toml:"failThreshold" wanf:"failThreshold"`
+	EjectCooldown       string                `toml:"ejectCooldown" wanf:"ejectCooldown"`
+	Proxies             map[string]string     `toml:"proxies" wanf:"proxies"`
+	Routes              []OutboundRouteConfig `toml:"routes" wanf:"routes"`
+}
+
+// OutboundRouteConfig 定义一条出站路由规则, hosts 与 matchers 同时匹配时经 via 访问上游
+type OutboundRouteConfig struct {
+	Hosts    []string `toml:"hosts" wanf:"hosts"`
+	Matchers []string `toml:"matchers" wanf:"matchers"`
+	Via      string   `toml:"via" wanf:"via"`
+}
+
+/*
+[dns]
+enabled = false
+servers = [] # 如 ["udp://1.1.1.1:53", "tcp://8.8.8.8:53", "https://1.1.1.1/dns-query"], 依次尝试, 为空时使用系统 DNS
+timeout = "5s" # 单次查询的超时时间
+cacheTTL = "5m" # 查询结果的缓存时间, 查询失败时继续使用过期的结果
+dialTimeout = "10s" # 连接单个地址的超时时间, 超时后尝试下一个地址
+failCooldown = "1m" # 连接失败的地址在该时间内排在最后
+[dns.hosts] # 静态记录, 优先于 DNS 查询, 支持 "*.example.com"
+"github.com" = ["140.82.112.3", "140.82.113.3"]
+*/
+// DNSConfig 定义上游域名解析相关的配置
+type DNSConfig struct {
+	Enabled      bool                `toml:"enabled" wanf:"enabled"`
+	Servers      []string            `toml:"servers" wanf:"servers"`
+	Timeout      string              `toml:"timeout" wanf:"timeout"`
+	CacheTTL     string              `toml:"cacheTTL" wanf:"cacheTTL"`
+	DialTimeout  string              `toml:"dialTimeout" wanf:"dialTimeout"`
+	FailCooldown string              `toml:"failCooldown" wanf:"failCooldown"`
+	Hosts        map[string][]string `toml:"hosts" wanf:"hosts"`
+}
+
+/*
+[mirrors]
+enabled = false
+fallbackStatuses = [404, 500, 502, 503, 504] # 返回这些状态码或请求失败时尝试下一个镜像
+[[mirrors.releases]] # 按顺序尝试, 另有 raw 与 gist
+name = "gitea"
+url = "https://gitea.example.com" # 在其后拼接 GitHub 路径, 也可使用 {user} {repo} {rest} {path} 占位符
+[[mirrors.releases]]
+name = "github" # url 为空表示 GitHub 本身, 链中没有此项时不请求 GitHub
+*/
+// MirrorsConfig 定义上游镜像回退链相关的配置
+type MirrorsConfig struct {
+	Enabled          bool           `toml:"enabled" wanf:"enabled"`
+	FallbackStatuses []int          `toml:"fallbackStatuses" wanf:"fallbackStatuses"`
+	Releases         []MirrorConfig `toml:"releases" wanf:"releases"`
+	Raw              []MirrorConfig `toml:"raw" wanf:"raw"`
+	Gist             []MirrorConfig `toml:"gist" wanf:"gist"`
+}
+
+// MirrorConfig 定义回退链中的一个镜像
+type MirrorConfig struct {
+	Name string `toml:"name" wanf:"name"`
+	Url  string `toml:"url" wanf:"url"`
+}
+
+/*
+[githubTokens]
+enabled = false
+tokens = [] # GitHub 个人访问令牌, 按剩余配额轮换使用; 令牌供所有匿名用户使用, 须只能访问公开仓库 (classic 令牌不勾选 repo, fine-grained 令牌选择 Public repositories), 带有 repo 权限的 classic 令牌会被停用
+matchers = ["api"] # 附加令牌的请求类型 (api/raw/blob/gist/releases/clone), 为空时只用于 api
+cache = false # 使用令牌获取的响应也写入缓存并参与合并下载, 默认不缓存且不向客户端应用 cacheControl 策略
+*/
+// GithubTokensConfig 定义服务端 GitHub 令牌池相关的配置
+// 令牌只附加于发往 GitHub 且未携带客户端凭据的请求
+type GithubTokensConfig struct {
+	Enabled  bool     `toml:"enabled" wanf:"enabled"`
+	Tokens   []string `toml:"tokens" wanf:"tokens"`
+	Matchers []string `toml:"matchers" wanf:"matchers"`
+	Cache    bool     `toml:"cache" wanf:"cache"`
+}
+
+/*
+[[instances]] # GitHub Enterprise Server 等其他 GitHub 实例, 通过 /<web 的主机与路径>/... 访问, 与 github.com 的用法相同
+name = "corp"
+web = "https://ghe.example.com" # 必填, 仅支持 https
+raw = "" # 默认为 {web}/raw, 启用子域隔离时为 "https://raw.ghe.example.com"
+api = "" # 默认为 {web}/api/v3
+gist = "" # 默认为 {web}/gist
+caFile = "" # 上游 CA 证书文件 (PEM), 代替系统根证书验证该实例, 为空时使用系统根证书; 与匹配的 [[httpc.tls]] 规则合并, 规则的 caFiles 附加于其上
+token = "" # 附加于发往该实例且未携带客户端凭据的请求, 其响应不写入缓存; 未启用 [auth] 时须设置 public = true
+public = false # 允许匿名用户使用 token, 仅在令牌只能访问公开内容时开启
+*/
+// InstanceConfig 定义一个 GitHub 实例 (如 GitHub Enterprise Server) 的配置
+type InstanceConfig struct {
+	Name   string `toml:"name" wanf:"name"`
+	Web    string `toml:"web" wanf:"web"`
+	Raw    string `toml:"raw" wanf:"raw"`
+	Api    string `toml:"api" wanf:"api"`
+	Gist   string `toml:"gist" wanf:"gist"`
+	CAFile string `toml:"caFile" wanf:"caFile"`
+	Token  string `toml:"token" wanf:"token"`
+	Public bool   `toml:"public" wanf:"public"`
 }
 
 /*
@@ -212,6 +462,116 @@ type DockerConfig struct {
 	AuthPassThrough bool              `toml:"authPassThrough" wanf:"authPassThrough"`
 }
 
+/*
+[cache]
+# 缓存 releases 资源与源码归档, 分支归档与 releases/latest 下载向上游验证后使用
+# 标签下的资源与归档视为不变, 不再验证; 上游重新上传后需经管理接口清除
+enabled = false
+storage = "fs" # fs / s3, 使用 s3 时 dir 仅用于存放临时文件
+dir = "/data/ghproxy/cache"
+maxSize = 10240 # MB
+raw = false # 缓存 raw 文件, 使用 ETag/Last-Modified 向上游验证
+ociBlobs = true # 缓存 Docker/OCI 镜像层, 按 sha256 摘要校验
+ociManifests = false # 缓存 Docker/OCI manifest, 按摘要查询的永久有效
+manifestTTL = "10m" # 按标签查询的 manifest 的缓存有效期, 上游不可用时仍使用过期条目
+coalesce = false # 合并相同上游 URL 的并发下载, 不依赖 enabled
+staleIfError = false # 上游返回 5xx 或超时时使用已缓存的旧内容响应, 并附带 Warning 头
+maxStale = "24h" # 使用旧内容时允许的最长过期时间 (自上次从上游获取或验证起计算), "0" 表示不限制
+[cache.s3]
+endpoint = "https://s3.amazonaws.com" # MinIO 等兼容服务填写其地址, 如 "http://minio:9000"
+region = "us-east-1"
+bucket = ""
+prefix = "ghproxy/" # 每个实例须使用独立的 bucket 或 prefix, 内容的引用计数只保存在各自进程中
+accessKey = ""
+secretKey = ""
+pathStyle = true # 使用 <endpoint>/<bucket>/<key> 形式的地址, MinIO 需要开启
+partSize = 16 # MB, 超过此大小的对象使用分片上传
+timeout = "60s" # 单个请求的超时时间, 上传时每个请求最多包含一个分片; 读取缓存内容时只限制等待响应头的时间
+*/
+// CacheConfig 定义持久化缓存相关的配置
+type CacheConfig struct {
+	Enabled      bool     `toml:"enabled" wanf:"enabled"`
+	Storage      string   `toml:"storage" wanf:"storage"`
+	Dir          string   `toml:"dir" wanf:"dir"`
+	MaxSize      int64    `toml:"maxSize" wanf:"maxSize"`
+	Raw          bool     `toml:"raw" wanf:"raw"`
+	OciBlobs     bool     `toml:"ociBlobs" wanf:"ociBlobs"`
+	OciManifests bool     `toml:"ociManifests" wanf:"ociManifests"`
+	ManifestTTL  string   `toml:"manifestTTL" wanf:"manifestTTL"`
+	Coalesce     bool     `toml:"coalesce" wanf:"coalesce"`
+	StaleIfError bool     `toml:"staleIfError" wanf:"staleIfError"`
+	MaxStale     string   `toml:"maxStale" wanf:"maxStale"`
+	S3           S3Config `toml:"s3" wanf:"s3"`
+}
+
+// S3Config 定义 S3 兼容对象存储的配置
+type S3Config struct {
+	Endpoint  string `toml:"endpoint" wanf:"endpoint"`
+	Region    string `toml:"region" wanf:"region"`
+	Bucket    string `toml:"bucket" wanf:"bucket"`
+	Prefix    string `toml:"prefix" wanf:"prefix"`
+	AccessKey string `toml:"accessKey" wanf:"accessKey"`
+	SecretKey string `toml:"secretKey" wanf:"secretKey"`
+	PathStyle bool   `toml:"pathStyle" wanf:"pathStyle"`
+	PartSize  int64  `toml:"partSize" wanf:"partSize"`
+	Timeout   string `toml:"timeout" wanf:"timeout"`
+}
+
+/*
+[admin]
+enabled = false
+token = "" # 管理接口 (如 /api/cache) 的令牌, 通过 Authorization: Bearer <token> 传递, 与 [auth] 相互独立
+*/
+// AdminConfig 定义管理接口相关的配置
+type AdminConfig struct {
+	Enabled bool   `toml:"enabled" wanf:"enabled"`
+	Token   string `toml:"token" wanf:"token"`
+}
+
+/*
+[cacheControl]
+enabled = false # 按类型改写返回给客户端 (及前置 CDN) 的缓存头, 未启用时透传上游的缓存头
+# 每个类型可设置 cacheControl / immutable / vary / surrogateControl, 留空表示保留上游的响应头
+# immutable 用于内容不会变化的响应 (固定到提交 SHA 的 raw 文件, 按摘要引用的 manifest 与 blob), 留空时使用 cacheControl
+# 只作用于 200 / 206 / 304 响应, 错误响应始终透传
+[cacheControl.releases]
+cacheControl = "public, max-age=86400"
+[cacheControl.raw]
+cacheControl = "public, max-age=300"
+immutable = "public, max-age=31536000, immutable"
+[cacheControl.gist]
+cacheControl = "public, max-age=300"
+[cacheControl.api]
+cacheControl = "private, no-cache"
+[cacheControl.clone] # 不作用于本地镜像 (gitclone mode = "mirror") 生成的响应, 其始终不缓存
+cacheControl = "no-store, no-cache, must-revalidate"
+[cacheControl.manifests]
+cacheControl = "public, max-age=600"
+immutable = "public, max-age=31536000, immutable"
+vary = "Accept"
+[cacheControl.blobs]
+immutable = "public, max-age=31536000, immutable"
+*/
+// CacheControlConfig 定义返回给客户端的缓存头策略
+type CacheControlConfig struct {
+	Enabled   bool              `toml:"enabled" wanf:"enabled"`
+	Releases  CachePolicyConfig `toml:"releases" wanf:"releases"`
+	Raw       CachePolicyConfig `toml:"raw" wanf:"raw"`
+	Gist      CachePolicyConfig `toml:"gist" wanf:"gist"`
+	Api       CachePolicyConfig `toml:"api" wanf:"api"`
+	Clone     CachePolicyConfig `toml:"clone" wanf:"clone"`
+	Manifests CachePolicyConfig `toml:"manifests" wanf:"manifests"`
+	Blobs     CachePolicyConfig `toml:"blobs" wanf:"blobs"`
+}
+
+// CachePolicyConfig 定义单个类型的缓存头, 留空的字段保留上游的响应头
+type CachePolicyConfig struct {
+	CacheControl     string `toml:"cacheControl" wanf:"cacheControl"`
+	Immutable        string `toml:"immutable" wanf:"immutable"`
+	Vary             string `toml:"vary" wanf:"vary"`
+	SurrogateControl string `toml:"surrogateControl" wanf:"surrogateControl"`
+}
+
 // LoadConfig 从配置文件加载配置
 func LoadConfig(filePath string) (*Config, error) {
 	exist, filePath2read := FileExists(filePath)
@@ -309,11 +669,63 @@ func DefaultConfig() *Config {
 			MaxIdleConns:        100,
 			MaxIdleConnsPerHost: 60,
 			MaxConnsPerHost:     0,
+			Retry: RetryConfig{
+				Enabled:    false,
+				MaxRetries: 2,
+				BaseDelay:  "200ms",
+				MaxDelay:   "5s",
+				Budget:     "15s",
+				Statuses:   []int{429, 502, 503, 504},
+			},
+			Breaker: BreakerConfig{
+				Enabled:        false,
+				Window:         "60s",
+				MinRequests:    20,
+				ErrorRate:      0.5,
+				OpenTimeout:    "30s",
+				HalfOpenProbes: 1,
+			},
+			Timeouts: TimeoutsConfig{
+				Dial:           "10s",
+				TLSHandshake:   "10s",
+				ResponseHeader: "30s",
+				BodyStall:      "60s",
+			},
+			GitTimeouts: TimeoutsConfig{
+				Dial:           "10s",
+				TLSHandshake:   "10s",
+				ResponseHeader: "120s",
+				BodyStall:      "120s",
+			},
+			Resume: ResumeConfig{
+				Enabled:     false,
+				MaxAttempts: 3,
+			},
+			Segmented: SegmentedConfig{
+				Enabled:     false,
+				Threshold:   64,
+				Connections: 4,
+				ChunkSize:   4,
+				MaxRetries:  3,
+			},
+			Source: SourceConfig{
+				Enabled:  false,
+				Addrs:    []string{},
+				Strategy: "round-robin",
+				IPFamily: "",
+			},
+			TLS: []UpstreamTLSConfig{},
 		},
 		GitClone: GitCloneConfig{
-			Mode:         "bypass",
-			SmartGitAddr: "http://127.0.0.1:8080",
-			ForceH2C:     false,
+			Mode:            "bypass",
+			SmartGitAddr:    "http://127.0.0.1:8080",
+			ForceH2C:        false,
+			MirrorDir:       "/data/ghproxy/git",
+			MirrorRefresh:   "5m",
+			MirrorRepos:     []string{},
+			MirrorMaxClones: 2,
+			MirrorMaxSize:   10240,
+			MirrorIdle:      "168h",
 		},
 		Shell: ShellConfig{
 			Editor:     false,
@@ -365,9 +777,41 @@ func DefaultConfig() *Config {
 			},
 		},
 		Outbound: OutboundConfig{
-			Enabled: false,
-			Url:     "socks5://127.0.0.1:1080",
+			Enabled:             false,
+			Url:                 "socks5://127.0.0.1:1080",
+			Pool:                []string{},
+			Strategy:            "round-robin",
+			HealthCheckInterval: "30s",
+			HealthCheckTimeout:  "5s",
+			HealthCheckUrl:      "",
+			FailThreshold:       2,
+			EjectCooldown:       "30s",
+			Proxies:             map[string]string{},
+			Routes:              []OutboundRouteConfig{},
+		},
+		DNS: DNSConfig{
+			Enabled:      false,
+			Servers:      []string{},
+			Timeout:      "5s",
+			CacheTTL:     "5m",
+			DialTimeout:  "10s",
+			FailCooldown: "1m",
+			Hosts:        map[string][]string{},
 		},
+		Mirrors: MirrorsConfig{
+			Enabled:          false,
+			FallbackStatuses: []int{404, 500, 502, 503, 504},
+			Releases:         []MirrorConfig{},
+			Raw:              []MirrorConfig{},
+			Gist:             []MirrorConfig{},
+		},
+		GithubTokens: GithubTokensConfig{
+			Enabled:  false,
+			Tokens:   []string{},
+			Matchers: []string{"api"},
+			Cache:    false,
+		},
+		Instances: []InstanceConfig{},
 		Docker: DockerConfig{
 			Enabled: false,
 			Target:  "dockerhub",
@@ -376,5 +820,57 @@ func DefaultConfig() *Config {
 				"testpass": "test123",
 			},
 		},
+		Cache: CacheConfig{
+			Enabled:      false,
+			Storage:      "fs",
+			Dir:          "/data/ghproxy/cache",
+			MaxSize:      10240,
+			Raw:          false,
+			OciBlobs:     true,
+			OciManifests: false,
+			ManifestTTL:  "10m",
+			Coalesce:     false,
+			StaleIfError: false,
+			MaxStale:     "24h",
+			S3: S3Config{
+				Endpoint:  "https://s3.amazonaws.com",
+				Region:    "us-east-1",
+				Prefix:    "ghproxy/",
+				PathStyle: true,
+				PartSize:  16,
+				Timeout:   "60s",
+			},
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Token:   "",
+		},
+		CacheControl: CacheControlConfig{
+			Enabled: false,
+			Releases: CachePolicyConfig{
+				CacheControl: "public, max-age=86400",
+			},
+			Raw: CachePolicyConfig{
+				CacheControl: "public, max-age=300",
+				Immutable:    "public, max-age=31536000, immutable",
+			},
+			Gist: CachePolicyConfig{
+				CacheControl: "public, max-age=300",
+			},
+			Api: CachePolicyConfig{
+				CacheControl: "private, no-cache",
+			},
+			Clone: CachePolicyConfig{
+				CacheControl: "no-store, no-cache, must-revalidate",
+			},
+			Manifests: CachePolicyConfig{
+				CacheControl: "public, max-age=600",
+				Immutable:    "public, max-age=31536000, immutable",
+				Vary:         "Accept",
+			},
+			Blobs: CachePolicyConfig{
+				Immutable: "public, max-age=31536000, immutable",
+			},
+		},
 	}
 }
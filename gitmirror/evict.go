@@ -0,0 +1,157 @@
+package gitmirror
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// evictGrace 刚被使用的镜像在该时间内不会被逐出, 覆盖 Prepare 返回到 upload-pack 启动之间的间隔
+const evictGrace = time.Minute
+
+// dirSize 返回目录中所有文件的总字节数, 目录不存在时返回 0
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// updateSize 重新统计镜像占用的空间
+func (m *Manager) updateSize(st *repoState) {
+	size := dirSize(st.path)
+	st.mu.Lock()
+	st.size = size
+	st.mu.Unlock()
+}
+
+// acquire 标记镜像正在被 upload-pack 使用, 返回的函数用于解除标记
+func (m *Manager) acquire(path string) func() {
+	m.mu.Lock()
+	m.active[path]++
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		if m.active[path]--; m.active[path] <= 0 {
+			delete(m.active, path)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// evictable 判断镜像当前是否可以删除, 须持有 m.mu
+func (m *Manager) evictable(st *repoState, now time.Time) (time.Time, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.size == 0 || st.cloning || st.fetching != nil || m.active[st.path] > 0 || now.Sub(st.lastUsed) < evictGrace {
+		return time.Time{}, false
+	}
+	return st.lastUsed, true
+}
+
+// evictLocked 将镜像移入临时目录, 返回待删除的路径, 须持有 m.mu
+// 移动是原子的, 之后的 Prepare 会将其视为不存在并重新克隆
+func (m *Manager) evictLocked(st *repoState) string {
+	tmp, err := os.MkdirTemp(m.tmpDir(), "evict-*")
+	if err != nil {
+		return ""
+	}
+	trash := filepath.Join(tmp, "repo.git")
+	if err := os.Rename(st.path, trash); err != nil {
+		os.Remove(tmp)
+		return ""
+	}
+	st.mu.Lock()
+	st.size = 0
+	st.lastFetch = time.Time{}
+	st.mu.Unlock()
+	return tmp
+}
+
+// enforceSize 在总大小超过上限时逐出最久未使用的镜像 (不包括 keep), 返回逐出后的总大小
+func (m *Manager) enforceSize(keep string) int64 {
+	if m.opts.MaxSize <= 0 {
+		return 0
+	}
+	var trash []string
+	defer func() {
+		for _, dir := range trash {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for {
+		var (
+			total  int64
+			victim *repoState
+			oldest time.Time
+		)
+		for _, st := range m.repos {
+			st.mu.Lock()
+			total += st.size
+			st.mu.Unlock()
+			if st.path == keep {
+				continue
+			}
+			if lastUsed, ok := m.evictable(st, now); ok && (victim == nil || lastUsed.Before(oldest)) {
+				victim, oldest = st, lastUsed
+			}
+		}
+		if total <= m.opts.MaxSize || victim == nil {
+			return total
+		}
+		dir := m.evictLocked(victim)
+		if dir == "" {
+			return total
+		}
+		log.Printf("gitmirror: evicted %s to stay within the size limit", victim.path)
+		trash = append(trash, dir)
+	}
+}
+
+// evictIdleLoop 定期删除超过 IdleTimeout 未使用的镜像
+func (m *Manager) evictIdleLoop() {
+	interval := min(max(m.opts.IdleTimeout/10, time.Minute), time.Hour)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.evictIdle(time.Now())
+	}
+}
+
+// evictIdle 删除超过 IdleTimeout 未使用的镜像, 并清理没有镜像的仓库状态
+func (m *Manager) evictIdle(now time.Time) {
+	var trash []string
+	m.mu.Lock()
+	for key, st := range m.repos {
+		if lastUsed, ok := m.evictable(st, now); ok && now.Sub(lastUsed) >= m.opts.IdleTimeout {
+			if dir := m.evictLocked(st); dir != "" {
+				log.Printf("gitmirror: evicted idle mirror %s", st.path)
+				trash = append(trash, dir)
+			}
+			continue
+		}
+		st.mu.Lock()
+		stale := st.size == 0 && !st.cloning && st.fetching == nil && now.Sub(st.lastFetch) >= m.opts.Refresh
+		st.mu.Unlock()
+		if stale {
+			delete(m.repos, key)
+		}
+	}
+	m.mu.Unlock()
+	for _, dir := range trash {
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,392 @@
+package gitmirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotReady 表示仓库镜像尚未完成首次克隆
+var ErrNotReady = errors.New("gitmirror: mirror not ready")
+
+// Options 为镜像管理器的设置
+type Options struct {
+	Refresh     time.Duration // 向上游刷新的最小间隔
+	MaxClones   int           // 同时进行的首次克隆数上限, 不大于 0 时为 1
+	MaxSize     int64         // 全部镜像的总大小上限 (字节), 超出时逐出最久未使用的镜像, 0 表示不限制
+	IdleTimeout time.Duration // 超过该时间未使用的镜像被删除, 0 表示不删除
+	GitProxy    func() string // 返回传递给 git 的 http.proxy, 为 nil 或返回空时不使用代理
+	GitConfig   []string      // 以 -c 传递给 git 的配置项, 如 "http.sslCert=/path/to/cert.pem"
+}
+
+// Manager 管理本地的 git 裸仓库镜像
+//
+// 目录结构:
+//
+//	<dir>/repos/<user>/<repo>.git  已就绪的裸仓库
+//	<dir>/tmp/                     克隆中的临时目录与待删除的镜像
+type Manager struct {
+	dir    string
+	opts   Options
+	clones chan struct{} // 首次克隆的并发槽位
+
+	mu     sync.Mutex
+	repos  map[string]*repoState
+	active map[string]int // 正在执行 upload-pack 的镜像路径, 不会被逐出
+}
+
+// repoState 记录单个仓库镜像的克隆与刷新状态
+type repoState struct {
+	path string
+
+	mu        sync.Mutex
+	cloning   bool
+	fetching  chan struct{} // 刷新进行中时非 nil, 刷新完成后关闭
+	lastFetch time.Time
+	lastUsed  time.Time
+	size      int64 // 镜像占用的字节数, 未就绪时为 0
+	fetchErr  error
+}
+
+// New 创建位于 dir 的镜像管理器, 已有的镜像计入总大小
+func New(dir string, opts Options) (*Manager, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("gitmirror: dir is empty")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("gitmirror: git binary not found: %w", err)
+	}
+	if opts.MaxClones <= 0 {
+		opts.MaxClones = 1
+	}
+	m := &Manager{
+		dir:    dir,
+		opts:   opts,
+		clones: make(chan struct{}, opts.MaxClones),
+		repos:  make(map[string]*repoState),
+		active: make(map[string]int),
+	}
+	// 上次运行时未完成的克隆与未删除完的镜像一律丢弃
+	if err := os.RemoveAll(m.tmpDir()); err != nil {
+		return nil, fmt.Errorf("gitmirror: failed to clean tmp dir: %w", err)
+	}
+	for _, d := range []string{m.tmpDir(), filepath.Join(dir, "repos")} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, fmt.Errorf("gitmirror: failed to create dir %s: %w", d, err)
+		}
+	}
+	m.scan()
+	m.enforceSize("")
+	if opts.IdleTimeout > 0 {
+		go m.evictIdleLoop()
+	}
+	return m, nil
+}
+
+// scan 登记已有的镜像, 未使用时间从启动时开始计算
+func (m *Manager) scan() {
+	repos, _ := filepath.Glob(filepath.Join(m.dir, "repos", "*", "*.git"))
+	now := time.Now()
+	for _, path := range repos {
+		user := filepath.Base(filepath.Dir(path))
+		repo := strings.TrimSuffix(filepath.Base(path), ".git")
+		st := m.state(user, repo)
+		st.lastUsed = now
+		st.size = dirSize(path)
+	}
+}
+
+func (m *Manager) tmpDir() string {
+	return filepath.Join(m.dir, "tmp")
+}
+
+// repoPath 返回仓库镜像的路径, GitHub 的用户名与仓库名不区分大小写
+func (m *Manager) repoPath(user, repo string) string {
+	return filepath.Join(m.dir, "repos", strings.ToLower(user), strings.ToLower(repo)+".git")
+}
+
+func (m *Manager) state(user, repo string) *repoState {
+	key := strings.ToLower(user + "/" + repo)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.repos[key]
+	if !ok {
+		st = &repoState{path: m.repoPath(user, repo)}
+		m.repos[key] = st
+	}
+	return st
+}
+
+// ValidName 判断用户名或仓库名是否可以安全地用作路径
+func ValidName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-', ch == '_', ch == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Prepare 返回 user/repo 可用的本地镜像路径
+//
+// 镜像不存在时在后台开始首次克隆并返回 ErrNotReady, 调用方此时应直接请求上游;
+// 克隆槽位已满或总大小达到上限时不开始克隆, 同样返回 ErrNotReady;
+// 镜像距上次刷新超过刷新间隔时先向 remote 刷新, 刷新失败时仍返回现有镜像的路径和错误
+func (m *Manager) Prepare(ctx context.Context, remote, user, repo string) (string, error) {
+	if !ValidName(user) || !ValidName(repo) {
+		return "", fmt.Errorf("gitmirror: invalid repository name %s/%s", user, repo)
+	}
+	path := m.repoPath(user, repo)
+	st := m.state(user, repo)
+
+	if _, err := os.Stat(path); err != nil {
+		st.mu.Lock()
+		// 克隆失败后同样等待一个刷新间隔再重试, 避免不存在或私有的仓库反复触发克隆
+		start := !st.cloning && time.Since(st.lastFetch) >= m.opts.Refresh
+		st.mu.Unlock()
+		if start {
+			m.startClone(remote, st)
+		}
+		return "", ErrNotReady
+	}
+
+	st.mu.Lock()
+	st.lastUsed = time.Now()
+	if time.Since(st.lastFetch) < m.opts.Refresh {
+		st.mu.Unlock()
+		return path, nil
+	}
+	ch := st.fetching
+	if ch == nil {
+		ch = make(chan struct{})
+		st.fetching = ch
+		go m.fetch(path, st, ch)
+	}
+	st.mu.Unlock()
+
+	select {
+	case <-ch:
+	case <-ctx.Done():
+		return path, ctx.Err()
+	}
+	st.mu.Lock()
+	err := st.fetchErr
+	st.mu.Unlock()
+	return path, err
+}
+
+// startClone 在有空闲的克隆槽位且总大小未达到上限时于后台开始首次克隆
+func (m *Manager) startClone(remote string, st *repoState) {
+	select {
+	case m.clones <- struct{}{}:
+	default:
+		return
+	}
+	if m.opts.MaxSize > 0 && m.enforceSize("") >= m.opts.MaxSize {
+		<-m.clones
+		st.setFetchErr(fmt.Errorf("gitmirror: mirror size limit reached"))
+		return
+	}
+	st.mu.Lock()
+	if st.cloning {
+		st.mu.Unlock()
+		<-m.clones
+		return
+	}
+	st.cloning = true
+	st.mu.Unlock()
+	go func() {
+		defer func() { <-m.clones }()
+		m.clone(remote, st.path, st)
+		m.updateSize(st)
+		m.enforceSize(st.path)
+	}()
+}
+
+// clone 执行首次克隆, 完成后原子地移动到最终位置
+func (m *Manager) clone(remote, path string, st *repoState) {
+	defer func() {
+		st.mu.Lock()
+		st.cloning = false
+		st.lastFetch = time.Now()
+		st.lastUsed = st.lastFetch
+		st.mu.Unlock()
+	}()
+
+	tmp, err := os.MkdirTemp(m.tmpDir(), "clone-*")
+	if err != nil {
+		st.setFetchErr(fmt.Errorf("gitmirror: failed to create tmp dir: %w", err))
+		return
+	}
+	defer os.RemoveAll(tmp)
+
+	// 克隆不依赖任何客户端请求, 不受单个请求取消的影响
+	ctx := context.Background()
+	if err := m.run(ctx, "", "clone", "--bare", "--quiet", remote, tmp); err != nil {
+		st.setFetchErr(err)
+		return
+	}
+	// 只镜像分支与标签, 不包含 refs/pull/* 等上游内部引用
+	if err := m.run(ctx, tmp, "config", "--replace-all", "remote.origin.fetch", "+refs/heads/*:refs/heads/*"); err != nil {
+		st.setFetchErr(err)
+		return
+	}
+	if err := m.run(ctx, tmp, "config", "--add", "remote.origin.fetch", "+refs/tags/*:refs/tags/*"); err != nil {
+		st.setFetchErr(err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		st.setFetchErr(fmt.Errorf("gitmirror: failed to create dir: %w", err))
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		st.setFetchErr(fmt.Errorf("gitmirror: failed to move mirror into place: %w", err))
+		return
+	}
+	st.setFetchErr(nil)
+}
+
+// fetch 从上游刷新镜像, 完成后关闭 done
+func (m *Manager) fetch(path string, st *repoState, done chan struct{}) {
+	err := m.run(context.Background(), path, "fetch", "--prune", "--quiet", "origin")
+	m.updateSize(st)
+
+	st.mu.Lock()
+	// 刷新失败时同样等待一个刷新间隔后再重试, 避免上游故障时每个请求都阻塞在刷新上
+	st.lastFetch = time.Now()
+	st.fetchErr = err
+	st.fetching = nil
+	st.mu.Unlock()
+	close(done)
+	m.enforceSize(path)
+}
+
+func (st *repoState) setFetchErr(err error) {
+	st.mu.Lock()
+	st.fetchErr = err
+	st.mu.Unlock()
+}
+
+// LastError 返回 user/repo 最近一次克隆或刷新的错误
+func (m *Manager) LastError(user, repo string) error {
+	st := m.state(user, repo)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.fetchErr
+}
+
+// run 执行 git 命令, dir 非空时在该仓库中执行
+func (m *Manager) run(ctx context.Context, dir string, args ...string) error {
+	cmd := m.command(ctx, dir, "", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("gitmirror: git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (m *Manager) command(ctx context.Context, dir, protocol string, args ...string) *exec.Cmd {
+	var pre []string
+	for _, kv := range m.opts.GitConfig {
+		pre = append(pre, "-c", kv)
+	}
+	if m.opts.GitProxy != nil {
+		if p := m.opts.GitProxy(); p != "" {
+			pre = append(pre, "-c", "http.proxy="+p)
+		}
+	}
+	if dir != "" {
+		pre = append(pre, "-C", dir)
+	}
+	cmd := exec.CommandContext(ctx, "git", append(pre, args...)...)
+	// 上游需要凭据时直接失败, 不等待交互输入
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if protocol != "" {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+protocol)
+	}
+	return cmd
+}
+
+// AdvertiseRefs 返回镜像的引用通告, 用于响应 info/refs?service=git-upload-pack
+// protocol 为客户端 Git-Protocol 请求头的值
+func (m *Manager) AdvertiseRefs(ctx context.Context, path, protocol string) (io.ReadCloser, error) {
+	return m.uploadPack(ctx, path, protocol, nil, "--advertise-refs")
+}
+
+// UploadPack 以 stateless-rpc 方式执行 git-upload-pack, 响应客户端的 want/have 协商
+func (m *Manager) UploadPack(ctx context.Context, path, protocol string, stdin io.Reader) (io.ReadCloser, error) {
+	return m.uploadPack(ctx, path, protocol, stdin)
+}
+
+func (m *Manager) uploadPack(ctx context.Context, path, protocol string, stdin io.Reader, extra ...string) (io.ReadCloser, error) {
+	args := []string{"-c", "uploadpack.allowFilter=true", "upload-pack", "--stateless-rpc"}
+	args = append(args, extra...)
+	args = append(args, path)
+	cmd := m.command(ctx, "", protocol, args...)
+	cmd.Stdin = stdin
+	out := &cmdReader{cmd: cmd}
+	cmd.Stderr = &out.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("gitmirror: failed to create stdout pipe: %w", err)
+	}
+	out.stdout = stdout
+	out.release = m.acquire(path)
+	if err := cmd.Start(); err != nil {
+		out.release()
+		return nil, fmt.Errorf("gitmirror: failed to start upload-pack: %w", err)
+	}
+	return out, nil
+}
+
+// cmdReader 读取子进程的标准输出, 读取完毕或关闭时回收子进程
+type cmdReader struct {
+	cmd     *exec.Cmd
+	stdout  io.ReadCloser
+	stderr  bytes.Buffer
+	release func() // 解除镜像的使用标记
+	once    sync.Once
+	err     error
+}
+
+func (r *cmdReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *cmdReader) wait() error {
+	r.once.Do(func() {
+		if err := r.cmd.Wait(); err != nil {
+			r.err = fmt.Errorf("gitmirror: upload-pack: %w: %s", err, strings.TrimSpace(r.stderr.String()))
+		}
+		r.release()
+	})
+	return r.err
+}
+
+func (r *cmdReader) Close() error {
+	r.stdout.Close()
+	r.wait()
+	return nil
+}
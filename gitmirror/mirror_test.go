@@ -0,0 +1,107 @@
+package gitmirror
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMirrorLimits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	// 作为上游的本地仓库
+	remote := filepath.Join(t.TempDir(), "src")
+	for _, args := range [][]string{
+		{"init", "-q", remote},
+		{"-C", remote, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	m, err := New(t.TempDir(), Options{Refresh: time.Hour, MaxClones: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ready := func(repo string) string {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			path, err := m.Prepare(ctx, remote, "u", repo)
+			if err == nil {
+				// 占用并释放克隆槽位, 等待克隆的后台任务 (统计大小与逐出) 结束
+				m.clones <- struct{}{}
+				<-m.clones
+				return path
+			}
+			if err != nil && !errors.Is(err, ErrNotReady) || time.Now().After(deadline) {
+				t.Fatalf("mirror %s not ready: %v (last error: %v)", repo, err, m.LastError("u", repo))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	age := func(repo string, d time.Duration) {
+		st := m.state("u", repo)
+		st.mu.Lock()
+		st.lastUsed = time.Now().Add(-d)
+		st.mu.Unlock()
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	// 克隆槽位已满时不开始克隆
+	m.clones <- struct{}{}
+	if _, err := m.Prepare(ctx, remote, "u", "a"); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("Prepare = %v", err)
+	}
+	if st := m.state("u", "a"); st.cloning {
+		t.Error("clone started without a free slot")
+	}
+	<-m.clones
+
+	a := ready("a")
+	st := m.state("u", "a")
+	st.mu.Lock()
+	size := st.size
+	st.mu.Unlock()
+	if size == 0 {
+		t.Fatal("mirror size not recorded")
+	}
+
+	// 超出总大小时逐出最久未使用的镜像, 正在使用的镜像不会被逐出
+	m.opts.MaxSize = size + size/2
+	age("a", time.Hour)
+	release := m.acquire(a)
+	b := ready("b")
+	if !exists(a) {
+		t.Fatal("mirror in use was evicted")
+	}
+	release()
+	m.enforceSize(b)
+	if exists(a) || !exists(b) {
+		t.Errorf("least recently used mirror not evicted: a=%t b=%t", exists(a), exists(b))
+	}
+
+	// 超过 IdleTimeout 未使用的镜像被删除, 之后重新克隆
+	m.opts.IdleTimeout = time.Hour
+	age("b", 2*time.Hour)
+	m.evictIdle(time.Now())
+	if exists(b) {
+		t.Error("idle mirror not evicted")
+	}
+	if got := ready("b"); got != b {
+		t.Errorf("re-cloned mirror at %s, want %s", got, b)
+	}
+	if entries, _ := os.ReadDir(m.tmpDir()); len(entries) != 0 {
+		t.Errorf("evicted mirrors left in tmp: %d", len(entries))
+	}
+}
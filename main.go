@@ -14,6 +14,7 @@ import (
 	"ghproxy/api"
 	"ghproxy/auth"
 	"ghproxy/config"
+	"ghproxy/prefetch"
 	"ghproxy/proxy"
 
 	"github.com/WJQSERVER-STUDIO/httpc"
@@ -292,9 +293,96 @@ func setInternalRoute(cfg *config.Config, r *touka.Engine) error {
 	return nil
 }
 
+// registerGithubRoutes 为一个 GitHub 实例的 web, raw, API 与 gist 端点注册路由
+func registerGithubRoutes(cfg *config.Config, r *touka.Engine, routes proxy.InstanceRoutes) {
+	r.GET("/"+routes.Web+"/:user/:repo/releases/*filepath", func(c *touka.Context) {
+		// 规范化路径: 移除前导斜杠, 简化后续处理
+		filepath := c.Param("filepath")
+		if len(filepath) > 0 && filepath[0] == '/' {
+			filepath = filepath[1:]
+		}
+
+		isValidDownload := false
+
+		// 检查两种合法的下载链接格式
+		// 情况 A: "download/..."
+		if strings.HasPrefix(filepath, "download/") {
+			isValidDownload = true
+		} else {
+			// 情况 B: ":tag/download/..."
+			slashIndex := strings.IndexByte(filepath, '/')
+			// 确保 tag 部分存在 (slashIndex > 0)
+			if slashIndex > 0 {
+				pathAfterTag := filepath[slashIndex+1:]
+				if strings.HasPrefix(pathAfterTag, "download/") {
+					isValidDownload = true
+				}
+			}
+		}
+
+		// 根据匹配结果执行最终操作
+		if isValidDownload {
+			c.Set("matcher", "releases")
+			proxy.RoutingHandler(cfg)(c)
+		} else {
+			// 任何不符合下载链接格式的 'releases' 路径都被视为浏览页面并拒绝
+			proxy.ErrorPage(c, proxy.NewErrorWithStatusLookup(400, "unsupported releases page, only download links are allowed"))
+			return
+		}
+	})
+
+	r.GET("/"+routes.Web+"/:user/:repo/archive/*filepath", func(c *touka.Context) {
+		c.Set("matcher", "releases")
+		proxy.RoutingHandler(cfg)(c)
+	})
+
+	r.GET("/"+routes.Web+"/:user/:repo/blob/*filepath", func(c *touka.Context) {
+		c.Set("matcher", "blob")
+		proxy.RoutingHandler(cfg)(c)
+	})
+
+	r.GET("/"+routes.Web+"/:user/:repo/raw/*filepath", func(c *touka.Context) {
+		c.Set("matcher", "raw")
+		proxy.RoutingHandler(cfg)(c)
+	})
+
+	r.GET("/"+routes.Web+"/:user/:repo/info/*filepath", func(c *touka.Context) {
+		c.Set("matcher", "clone")
+		proxy.RoutingHandler(cfg)(c)
+	})
+	r.GET("/"+routes.Web+"/:user/:repo/git-upload-pack", func(c *touka.Context) {
+		c.Set("matcher", "clone")
+		proxy.RoutingHandler(cfg)(c)
+	})
+	r.POST("/"+routes.Web+"/:user/:repo/git-upload-pack", func(c *touka.Context) {
+		c.Set("matcher", "clone")
+		proxy.RoutingHandler(cfg)(c)
+	})
+
+	r.GET("/"+routes.Raw+"/:user/:repo/*filepath", func(c *touka.Context) {
+		c.Set("matcher", "raw")
+		proxy.RoutingHandler(cfg)(c)
+	})
+
+	r.GET("/"+routes.Gist+"/:user/*filepath", func(c *touka.Context) {
+		c.Set("matcher", "gist")
+		proxy.NoRouteHandler(cfg)(c)
+	})
+
+	r.ANY("/"+routes.Api+"/repos/:user/:repo/*filepath", func(c *touka.Context) {
+		c.Set("matcher", "api")
+		proxy.RoutingHandler(cfg)(c)
+	})
+}
+
 func init() {
-	readFlag()
-	flag.Parse()
+	// prefetch 子命令使用独立的参数
+	if len(os.Args) > 1 && os.Args[1] == "prefetch" {
+		readPrefetchFlag(os.Args[2:])
+	} else {
+		readFlag()
+		flag.Parse()
+	}
 
 	// 如果设置了 -h, 则显示帮助信息并退出
 	if showHelp {
@@ -405,84 +493,16 @@ func main() {
 	setupPages(cfg, r)
 	r.SetRedirectTrailingSlash(false)
 
-	r.GET("/github.com/:user/:repo/releases/*filepath", func(c *touka.Context) {
-		// 规范化路径: 移除前导斜杠, 简化后续处理
-		filepath := c.Param("filepath")
-		if len(filepath) > 0 && filepath[0] == '/' {
-			filepath = filepath[1:]
-		}
-
-		isValidDownload := false
-
-		// 检查两种合法的下载链接格式
-		// 情况 A: "download/..."
-		if strings.HasPrefix(filepath, "download/") {
-			isValidDownload = true
-		} else {
-			// 情况 B: ":tag/download/..."
-			slashIndex := strings.IndexByte(filepath, '/')
-			// 确保 tag 部分存在 (slashIndex > 0)
-			if slashIndex > 0 {
-				pathAfterTag := filepath[slashIndex+1:]
-				if strings.HasPrefix(pathAfterTag, "download/") {
-					isValidDownload = true
-				}
-			}
-		}
-
-		// 根据匹配结果执行最终操作
-		if isValidDownload {
-			c.Set("matcher", "releases")
-			proxy.RoutingHandler(cfg)(c)
-		} else {
-			// 任何不符合下载链接格式的 'releases' 路径都被视为浏览页面并拒绝
-			proxy.ErrorPage(c, proxy.NewErrorWithStatusLookup(400, "unsupported releases page, only download links are allowed"))
-			return
-		}
-	})
-
-	r.GET("/github.com/:user/:repo/archive/*filepath", func(c *touka.Context) {
-		c.Set("matcher", "releases")
-		proxy.RoutingHandler(cfg)(c)
-	})
-
-	r.GET("/github.com/:user/:repo/blob/*filepath", func(c *touka.Context) {
-		c.Set("matcher", "blob")
-		proxy.RoutingHandler(cfg)(c)
-	})
-
-	r.GET("/github.com/:user/:repo/raw/*filepath", func(c *touka.Context) {
-		c.Set("matcher", "raw")
-		proxy.RoutingHandler(cfg)(c)
-	})
-
-	r.GET("/github.com/:user/:repo/info/*filepath", func(c *touka.Context) {
-		c.Set("matcher", "clone")
-		proxy.RoutingHandler(cfg)(c)
-	})
-	r.GET("/github.com/:user/:repo/git-upload-pack", func(c *touka.Context) {
-		c.Set("matcher", "clone")
-		proxy.RoutingHandler(cfg)(c)
-	})
-	r.POST("/github.com/:user/:repo/git-upload-pack", func(c *touka.Context) {
-		c.Set("matcher", "clone")
-		proxy.RoutingHandler(cfg)(c)
-	})
-
-	r.GET("/raw.githubusercontent.com/:user/:repo/*filepath", func(c *touka.Context) {
-		c.Set("matcher", "raw")
-		proxy.RoutingHandler(cfg)(c)
-	})
-
-	r.GET("/gist.githubusercontent.com/:user/*filepath", func(c *touka.Context) {
-		c.Set("matcher", "gist")
-		proxy.NoRouteHandler(cfg)(c)
-	})
-
-	r.ANY("/api.github.com/repos/:user/:repo/*filepath", func(c *touka.Context) {
-		c.Set("matcher", "api")
-		proxy.RoutingHandler(cfg)(c)
+	registerGithubRoutes(cfg, r, proxy.InstanceRoutes{
+		Web:  "github.com",
+		Raw:  "raw.githubusercontent.com",
+		Api:  "api.github.com",
+		Gist: "gist.githubusercontent.com",
 	})
+	// 其他 GitHub 实例使用相同的路由, 端点互相嵌套的实例由 NoRoute 处理
+	for _, routes := range proxy.RoutableInstances() {
+		registerGithubRoutes(cfg, r, routes)
+	}
 
 	r.ANY("/v2/*path",
 		r.UseIf(cfg.Docker.Auth, func() touka.HandlerFunc {
@@ -500,6 +520,12 @@ func main() {
 		proxy.NoRouteHandler(cfg)(c)
 	})
 
+	if prefetchOptions != nil {
+		code := prefetch.Run(r, cfg, prefetchOptions)
+		logger.Close()
+		os.Exit(code)
+	}
+
 	fmt.Printf("GHProxy Version: %s\n", version)
 	fmt.Printf("A Go Based High-Performance Github Proxy \n")
 	fmt.Printf("Made by WJQSERVER-STUDIO\n")
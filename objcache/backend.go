@@ -0,0 +1,161 @@
+package objcache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Backend 为缓存对象内容与元数据的底层存储
+//
+// 内容按 sha256 摘要 (hex) 寻址, 元数据按 key 的哈希命名; 索引与容量控制由 Store 负责,
+// Backend 只需实现简单的读写删除与遍历
+type Backend interface {
+	// PutBlob 将本地文件 path 的内容以 digest 存储, 调用方随后会删除 path (若仍存在)
+	PutBlob(digest string, path string, size int64) error
+	// OpenBlob 打开 digest 对应的内容, 不存在时返回 ErrNotFound
+	OpenBlob(digest string) (io.ReadCloser, error)
+	DeleteBlob(digest string) error
+	// WalkBlobs 遍历所有已存储的内容
+	WalkBlobs(fn func(digest string, size int64)) error
+
+	PutMeta(name string, data []byte) error
+	DeleteMeta(name string) error
+	// WalkMeta 遍历所有元数据, modTime 为最近一次写入 (或访问) 的时间
+	WalkMeta(fn func(name string, data []byte, modTime time.Time)) error
+}
+
+// toucher 由支持记录访问时间的 Backend 实现
+type toucher interface {
+	TouchMeta(name string, t time.Time)
+}
+
+// rangeOpener 由支持直接读取部分内容的 Backend 实现
+type rangeOpener interface {
+	OpenBlobRange(digest string, off, length int64) (io.ReadCloser, error)
+}
+
+// FSBackend 将内容与元数据保存在本地目录中
+//
+// 目录结构:
+//
+//	<dir>/blobs/<digest[:2]>/<digest>  对象内容
+//	<dir>/meta/<name[:2]>/<name>       对象元数据 (json)
+type FSBackend struct {
+	dir    string
+	tmpDir string
+}
+
+// NewFSBackend 创建位于 dir 的本地存储, tmpDir 用于原子写入元数据, 需与 dir 位于同一文件系统
+func NewFSBackend(dir, tmpDir string) (*FSBackend, error) {
+	for _, d := range []string{filepath.Join(dir, "blobs"), filepath.Join(dir, "meta"), tmpDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, fmt.Errorf("objcache: failed to create dir %s: %w", d, err)
+		}
+	}
+	return &FSBackend{dir: dir, tmpDir: tmpDir}, nil
+}
+
+func (b *FSBackend) blobPath(digest string) string {
+	return filepath.Join(b.dir, "blobs", digest[:2], digest)
+}
+
+func (b *FSBackend) metaPath(name string) string {
+	return filepath.Join(b.dir, "meta", name[:2], name)
+}
+
+func (b *FSBackend) PutBlob(digest string, path string, size int64) error {
+	blob := b.blobPath(digest)
+	if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+		return fmt.Errorf("objcache: failed to create blob dir: %w", err)
+	}
+	if err := os.Rename(path, blob); err != nil {
+		return fmt.Errorf("objcache: failed to place blob: %w", err)
+	}
+	return nil
+}
+
+func (b *FSBackend) OpenBlob(digest string) (io.ReadCloser, error) {
+	f, err := os.Open(b.blobPath(digest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (b *FSBackend) DeleteBlob(digest string) error {
+	return os.Remove(b.blobPath(digest))
+}
+
+func (b *FSBackend) WalkBlobs(fn func(digest string, size int64)) error {
+	return filepath.WalkDir(filepath.Join(b.dir, "blobs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if info, err := d.Info(); err == nil {
+			fn(d.Name(), info.Size())
+		}
+		return nil
+	})
+}
+
+func (b *FSBackend) PutMeta(name string, data []byte) error {
+	return writeFileAtomic(b.tmpDir, b.metaPath(name), data)
+}
+
+func (b *FSBackend) DeleteMeta(name string) error {
+	return os.Remove(b.metaPath(name))
+}
+
+func (b *FSBackend) WalkMeta(fn func(name string, data []byte, modTime time.Time)) error {
+	return filepath.WalkDir(filepath.Join(b.dir, "meta"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		var modTime time.Time
+		if info, err := d.Info(); err == nil {
+			modTime = info.ModTime()
+		}
+		fn(d.Name(), data, modTime)
+		return nil
+	})
+}
+
+// TouchMeta 以元数据文件的修改时间持久化访问时间, 失败不影响读取
+func (b *FSBackend) TouchMeta(name string, t time.Time) {
+	os.Chtimes(b.metaPath(name), t, t)
+}
+
+// writeFileAtomic 先写入临时文件再重命名, 保证读取方不会看到写了一半的内容
+func writeFileAtomic(tmpDir, path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("objcache: failed to create dir: %w", err)
+	}
+	f, err := os.CreateTemp(tmpDir, "meta-*")
+	if err != nil {
+		return fmt.Errorf("objcache: failed to create temp file: %w", err)
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("objcache: failed to write temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("objcache: failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("objcache: failed to rename temp file: %w", err)
+	}
+	return nil
+}
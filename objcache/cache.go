@@ -0,0 +1,520 @@
+package objcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-json-experiment/json"
+)
+
+var (
+	// ErrNotFound 表示缓存中不存在请求的对象
+	ErrNotFound = errors.New("objcache: object not found")
+	// ErrDigestMismatch 表示写入的内容与期望的摘要不一致
+	ErrDigestMismatch = errors.New("objcache: digest mismatch")
+)
+
+// Entry 描述一个缓存对象的元数据
+// 对象内容按其 sha256 摘要存放 (内容寻址), 多个 key 指向相同内容时只占用一份空间
+type Entry struct {
+	Key        string      `json:"key"`
+	Digest     string      `json:"digest"` // 内容的 sha256 摘要 (hex)
+	Size       int64       `json:"size"`
+	Header     http.Header `json:"header"`
+	StoredAt   time.Time   `json:"storedAt"`
+	LastAccess time.Time   `json:"-"` // 由元数据文件的修改时间记录, 不写入文件内容
+}
+
+// Store 是一个按总字节数限制容量的 LRU 内容寻址缓存
+// 对象内容与元数据保存在 Backend 中, 索引保存在内存中并在打开时从 Backend 重建;
+// 写入中的对象先落在本地临时目录, 完整写入并校验后才放入 Backend
+//
+// 内容的引用计数只保存在本进程的内存中, 同一个 Backend (对于 S3 即同一 bucket 与 prefix)
+// 只能由一个 Store 使用, 否则可能删除其他进程仍在引用的内容
+type Store struct {
+	backend  Backend
+	tmpDir   string
+	maxBytes int64
+
+	mu       sync.Mutex
+	lru      *list.List               // 元素为 *Entry, 头部为最近访问
+	items    map[string]*list.Element // key -> lru 元素
+	blobs    map[string]int           // digest -> 引用计数
+	deleting map[string]chan struct{} // 正在从 Backend 删除的 blob, 删除完成后关闭
+	curBytes int64                    // 当前占用的字节数 (按 blob 计算, 相同内容只计一次)
+
+	hits     atomic.Int64
+	misses   atomic.Int64
+	hitBytes atomic.Int64
+	stale    atomic.Int64
+}
+
+// Stats 描述缓存的占用与命中情况
+type Stats struct {
+	Entries  int   `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"maxBytes"`
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	HitBytes int64 `json:"hitBytes"` // 由缓存响应的字节数
+	Stale    int64 `json:"stale"`    // 上游出错时使用过期条目响应的次数
+}
+
+// New 打开 (或创建) 位于本地目录 dir 的缓存, maxBytes 为容量上限, <=0 表示不限制
+//
+// 目录结构:
+//
+//	<dir>/blobs/  对象内容
+//	<dir>/meta/   对象元数据
+//	<dir>/tmp/    写入中的临时文件
+func New(dir string, maxBytes int64) (*Store, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("objcache: dir is empty")
+	}
+	tmpDir := filepath.Join(dir, "tmp")
+	backend, err := NewFSBackend(dir, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(backend, tmpDir, maxBytes)
+}
+
+// NewStore 基于 backend 打开缓存, tmpDir 为写入中对象的本地临时目录
+// 已存在的缓存内容会被重新加载, 元数据损坏或内容缺失的条目会被删除, 未完成的临时文件会被清理
+func NewStore(backend Backend, tmpDir string, maxBytes int64) (*Store, error) {
+	// 上次运行时未完成的写入一律丢弃
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, fmt.Errorf("objcache: failed to clean tmp dir: %w", err)
+	}
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return nil, fmt.Errorf("objcache: failed to create tmp dir: %w", err)
+	}
+	s := &Store{
+		backend:  backend,
+		tmpDir:   tmpDir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+		blobs:    make(map[string]int),
+		deleting: make(map[string]chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// metaName 返回 key 对应的元数据名称
+func metaName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// load 从 Backend 重建内存索引, 损坏或缺失内容的元数据会被删除
+func (s *Store) load() error {
+	sizes := make(map[string]int64)
+	if err := s.backend.WalkBlobs(func(digest string, size int64) {
+		sizes[digest] = size
+	}); err != nil {
+		return fmt.Errorf("objcache: failed to list blobs: %w", err)
+	}
+
+	var (
+		entries []*Entry
+		broken  []string
+		used    = make(map[string]bool)
+	)
+	err := s.backend.WalkMeta(func(name string, data []byte, modTime time.Time) {
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil || len(e.Digest) < 2 {
+			broken = append(broken, name)
+			return
+		}
+		if size, ok := sizes[e.Digest]; !ok || size != e.Size {
+			broken = append(broken, name)
+			return
+		}
+		e.LastAccess = modTime
+		used[e.Digest] = true
+		entries = append(entries, &e)
+	})
+	if err != nil {
+		return fmt.Errorf("objcache: failed to load index: %w", err)
+	}
+	for _, name := range broken {
+		s.backend.DeleteMeta(name)
+	}
+	// 没有元数据引用的内容 (例如写入元数据前进程退出) 不会再被访问
+	for digest := range sizes {
+		if !used[digest] {
+			s.backend.DeleteBlob(digest)
+		}
+	}
+
+	// 按最近访问时间由旧到新插入, 保证 lru 头部为最近访问
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastAccess.Before(entries[j].LastAccess)
+	})
+
+	var d deletions
+	s.mu.Lock()
+	for _, e := range entries {
+		s.insertLocked(e, &d)
+	}
+	s.evictLocked(&d)
+	s.mu.Unlock()
+	s.flush(&d)
+	return nil
+}
+
+// deletions 记录持有锁期间需要从 Backend 删除的对象, 由 flush 在释放锁后执行
+// Backend 可能是远程存储, 删除不在锁内进行, 避免阻塞其他请求
+type deletions struct {
+	metas []string
+	blobs []string
+}
+
+// flush 执行 d 中记录的删除
+func (s *Store) flush(d *deletions) {
+	for _, name := range d.metas {
+		s.backend.DeleteMeta(name)
+	}
+	for _, digest := range d.blobs {
+		s.backend.DeleteBlob(digest)
+		s.mu.Lock()
+		close(s.deleting[digest])
+		delete(s.deleting, digest)
+		s.mu.Unlock()
+	}
+}
+
+// insertLocked 将条目加入索引, 若 key 已存在则替换, 调用方需持有锁
+func (s *Store) insertLocked(e *Entry, d *deletions) {
+	// 先增加新内容的引用, 防止新旧内容相同时 blob 被提前删除
+	if s.blobs[e.Digest] == 0 {
+		s.curBytes += e.Size
+	}
+	s.blobs[e.Digest]++
+	if elem, ok := s.items[e.Key]; ok {
+		old := elem.Value.(*Entry)
+		s.lru.Remove(elem)
+		s.unrefLocked(old.Digest, old.Size, d)
+	}
+	s.items[e.Key] = s.lru.PushFront(e)
+}
+
+// unrefLocked 减少 blob 的引用计数, 引用归零时将内容记入 d 等待删除, 调用方需持有锁
+func (s *Store) unrefLocked(digest string, size int64, d *deletions) {
+	n := s.blobs[digest] - 1
+	if n > 0 {
+		s.blobs[digest] = n
+		return
+	}
+	delete(s.blobs, digest)
+	s.curBytes -= size
+	s.deleting[digest] = make(chan struct{})
+	d.blobs = append(d.blobs, digest)
+}
+
+// removeLocked 从索引中移除条目, 并将其元数据与内容记入 d 等待删除, 调用方需持有锁
+func (s *Store) removeLocked(elem *list.Element, d *deletions) {
+	e := elem.Value.(*Entry)
+	s.lru.Remove(elem)
+	delete(s.items, e.Key)
+	d.metas = append(d.metas, metaName(e.Key))
+	s.unrefLocked(e.Digest, e.Size, d)
+}
+
+// evictLocked 逐出最久未访问的条目, 直到占用量不超过上限, 调用方需持有锁
+func (s *Store) evictLocked(d *deletions) {
+	if s.maxBytes <= 0 {
+		return
+	}
+	for s.curBytes > s.maxBytes {
+		oldest := s.lru.Back()
+		if oldest == nil {
+			return
+		}
+		s.removeLocked(oldest, d)
+	}
+}
+
+// Get 返回 key 对应条目的元数据副本, 并将其标记为最近访问
+func (s *Store) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	elem, ok := s.items[key]
+	if !ok {
+		s.mu.Unlock()
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+	e := elem.Value.(*Entry)
+	e.LastAccess = time.Now()
+	cp := *e
+	s.mu.Unlock()
+
+	if t, ok := s.backend.(toucher); ok {
+		t.TouchMeta(metaName(key), cp.LastAccess)
+	}
+	return &cp, true
+}
+
+// Open 返回 key 对应条目的元数据与内容, 调用方负责关闭内容读取器
+func (s *Store) Open(key string) (*Entry, io.ReadCloser, error) {
+	e, ok := s.Get(key)
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	rc, err := s.backend.OpenBlob(e.Digest)
+	if errors.Is(err, ErrNotFound) {
+		// 内容已丢失, 删除失效的索引
+		s.Delete(key)
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("objcache: failed to open blob: %w", err)
+	}
+	return e, rc, nil
+}
+
+// OpenRange 返回条目 e 的内容中从 off 开始的 length 字节, 调用方负责关闭读取器
+// Backend 支持时直接读取部分内容, 否则打开完整内容后跳过前面的字节
+func (s *Store) OpenRange(e *Entry, off, length int64) (io.ReadCloser, error) {
+	if off < 0 || length < 0 || off+length > e.Size {
+		return nil, fmt.Errorf("objcache: invalid range %d+%d of %d bytes", off, length, e.Size)
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if ro, ok := s.backend.(rangeOpener); ok {
+		rc, err := ro.OpenBlobRange(e.Digest, off, length)
+		if err != nil {
+			return nil, fmt.Errorf("objcache: failed to open blob range: %w", err)
+		}
+		return rc, nil
+	}
+
+	rc, err := s.backend.OpenBlob(e.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("objcache: failed to open blob: %w", err)
+	}
+	if seeker, ok := rc.(io.Seeker); ok {
+		_, err = seeker.Seek(off, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, rc, off)
+	}
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("objcache: failed to skip to offset %d: %w", off, err)
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(rc, length), Closer: rc}, nil
+}
+
+// limitedReadCloser 只读取底层内容的一部分, 关闭时关闭底层读取器
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Delete 删除 key 对应的条目, 返回条目是否存在
+func (s *Store) Delete(key string) bool {
+	var d deletions
+	s.mu.Lock()
+	elem, ok := s.items[key]
+	if ok {
+		s.removeLocked(elem, &d)
+	}
+	s.mu.Unlock()
+	s.flush(&d)
+	return ok
+}
+
+// Refresh 将 key 对应条目的存储时间更新为当前时间, 用于上游确认内容未变化后
+func (s *Store) Refresh(key string) error {
+	s.mu.Lock()
+	elem, ok := s.items[key]
+	if !ok {
+		s.mu.Unlock()
+		return ErrNotFound
+	}
+	e := elem.Value.(*Entry)
+	cp := *e
+	s.mu.Unlock()
+
+	cp.StoredAt = time.Now()
+	meta, err := json.Marshal(&cp)
+	if err != nil {
+		return fmt.Errorf("objcache: failed to encode meta: %w", err)
+	}
+	if err := s.backend.PutMeta(metaName(key), meta); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	e.StoredAt = cp.StoredAt
+	s.mu.Unlock()
+	return nil
+}
+
+// Entries 返回 key 以 prefix 开头的条目的元数据副本, 按最近访问排序, limit <= 0 表示不限制数量
+func (s *Store) Entries(prefix string, limit int) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Entry
+	for elem := s.lru.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*Entry)
+		if !strings.HasPrefix(e.Key, prefix) {
+			continue
+		}
+		out = append(out, *e)
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out
+}
+
+// DeleteFunc 删除所有 match 返回 true 的条目, 返回删除的数量
+func (s *Store) DeleteFunc(match func(key string) bool) int {
+	var d deletions
+	s.mu.Lock()
+	n := 0
+	for elem := s.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if match(elem.Value.(*Entry).Key) {
+			s.removeLocked(elem, &d)
+			n++
+		}
+		elem = next
+	}
+	s.mu.Unlock()
+	s.flush(&d)
+	return n
+}
+
+// RecordHit 记录一次由缓存响应的请求及其字节数
+func (s *Store) RecordHit(bytes int64) {
+	s.hits.Add(1)
+	s.hitBytes.Add(bytes)
+}
+
+// RecordMiss 记录一次需要请求上游的可缓存请求
+func (s *Store) RecordMiss() {
+	s.misses.Add(1)
+}
+
+// RecordStale 记录一次上游出错时由过期条目响应的请求
+func (s *Store) RecordStale() {
+	s.stale.Add(1)
+}
+
+// Stats 返回缓存的占用与命中统计
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	st := Stats{
+		Entries:  len(s.items),
+		Bytes:    s.curBytes,
+		MaxBytes: s.maxBytes,
+	}
+	s.mu.Unlock()
+	st.Hits = s.hits.Load()
+	st.Misses = s.misses.Load()
+	st.HitBytes = s.hitBytes.Load()
+	st.Stale = s.stale.Load()
+	return st
+}
+
+// Len 返回缓存中的条目数量
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// Size 返回缓存当前占用的字节数
+func (s *Store) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.curBytes
+}
+
+// commit 将写入完成的临时文件与元数据放入缓存
+// 内容与元数据的上传不持有锁, 期间以一个临时引用防止相同内容被逐出
+func (s *Store) commit(tmpPath string, e *Entry) error {
+	if s.maxBytes > 0 && e.Size > s.maxBytes {
+		return fmt.Errorf("objcache: object size %d exceeds cache capacity %d", e.Size, s.maxBytes)
+	}
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("objcache: failed to encode meta: %w", err)
+	}
+
+	s.mu.Lock()
+	// 相同内容正在被删除时等待删除完成, 避免新上传的内容随后被删除
+	for {
+		ch, ok := s.deleting[e.Digest]
+		if !ok {
+			break
+		}
+		s.mu.Unlock()
+		<-ch
+		s.mu.Lock()
+	}
+	exists := s.blobs[e.Digest] > 0
+	if !exists {
+		s.curBytes += e.Size
+	}
+	s.blobs[e.Digest]++
+	s.mu.Unlock()
+
+	var d deletions
+	defer s.flush(&d)
+	release := func() {
+		s.mu.Lock()
+		s.unrefLocked(e.Digest, e.Size, &d)
+		s.mu.Unlock()
+	}
+	if exists {
+		// 相同内容已存在, 丢弃本次写入的副本
+		os.Remove(tmpPath)
+	} else if err := s.backend.PutBlob(e.Digest, tmpPath, e.Size); err != nil {
+		release()
+		return err
+	}
+	if err := s.backend.PutMeta(metaName(e.Key), meta); err != nil {
+		release()
+		return err
+	}
+
+	s.mu.Lock()
+	e.LastAccess = time.Now()
+	s.insertLocked(e, &d)
+	s.unrefLocked(e.Digest, e.Size, &d) // 释放临时引用
+	s.evictLocked(&d)
+	s.mu.Unlock()
+	return nil
+}
+
+// cloneHeader 复制需要随对象保存的响应头, 并去除逐跳头部
+func cloneHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, vv := range h {
+		switch strings.ToLower(k) {
+		case "connection", "keep-alive", "transfer-encoding", "content-length", "date", "set-cookie":
+			continue
+		}
+		out[k] = append([]string(nil), vv...)
+	}
+	return out
+}
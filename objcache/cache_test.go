@@ -0,0 +1,217 @@
+package objcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func put(t *testing.T, s *Store, key, body string) {
+	t.Helper()
+	w, err := s.Create(key, http.Header{"Content-Type": {"text/plain"}})
+	if err != nil {
+		t.Fatalf("Create(%q): %v", key, err)
+	}
+	if _, err := io.Copy(w, strings.NewReader(body)); err != nil {
+		t.Fatalf("Write(%q): %v", key, err)
+	}
+	if _, err := w.Commit(); err != nil {
+		t.Fatalf("Commit(%q): %v", key, err)
+	}
+}
+
+func TestStore_LRUEviction(t *testing.T) {
+	s, err := New(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "a", "aaaa")
+	put(t, s, "b", "bbbb")
+	s.Get("a") // a 成为最近访问
+	put(t, s, "c", "cccc")
+
+	if _, ok := s.Get("b"); ok {
+		t.Errorf("b should have been evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := s.Get(k); !ok {
+			t.Errorf("%s should still be cached", k)
+		}
+	}
+	if got := s.Size(); got != 8 {
+		t.Errorf("Size() = %d, want 8", got)
+	}
+}
+
+func TestStore_SharedContent(t *testing.T) {
+	s, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "a", "same")
+	put(t, s, "b", "same")
+	if got := s.Size(); got != 4 {
+		t.Errorf("Size() = %d, want 4 for deduplicated content", got)
+	}
+	s.Delete("a")
+	_, f, err := s.Open("b")
+	if err != nil {
+		t.Fatalf("Open(b) after deleting a: %v", err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	if string(data) != "same" {
+		t.Errorf("content = %q, want %q", data, "same")
+	}
+}
+
+func TestStore_AbortAndReload(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "kept", "hello")
+
+	w, err := s.Create("aborted", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("partial"))
+	w.Abort()
+	if _, ok := s.Get("aborted"); ok {
+		t.Errorf("aborted object must not be visible")
+	}
+
+	s2, err := New(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := s2.Get("kept")
+	if !ok {
+		t.Fatalf("kept should survive reload")
+	}
+	if e.Size != 5 || e.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("reloaded entry = %+v", e)
+	}
+	if s2.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", s2.Len())
+	}
+}
+
+func TestWriter_ExpectDigest(t *testing.T) {
+	s, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const digest = "0000000000000000000000000000000000000000000000000000000000000000"
+	w, err := s.Create("bad", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.ExpectDigest(digest)
+	io.WriteString(w, "not the blob")
+	if _, err := w.Commit(); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("Commit() error = %v, want ErrDigestMismatch", err)
+	}
+	if _, ok := s.Get("bad"); ok {
+		t.Errorf("mismatched content should not be cached")
+	}
+}
+
+func TestStore_OpenRange(t *testing.T) {
+	s, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "a", "0123456789")
+	e, ok := s.Get("a")
+	if !ok {
+		t.Fatal("a should be cached")
+	}
+	rc, err := s.OpenRange(e, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "3456" {
+		t.Errorf("OpenRange(3, 4) = %q, want %q", data, "3456")
+	}
+	if _, err := s.OpenRange(e, 8, 4); err == nil {
+		t.Errorf("OpenRange beyond size should fail")
+	}
+}
+
+// gatedBackend 的 DeleteBlob 在 gate 关闭前阻塞, 用于模拟较慢的远程存储
+type gatedBackend struct {
+	Backend
+	gate    chan struct{}
+	started chan string
+}
+
+func (b *gatedBackend) DeleteBlob(digest string) error {
+	b.started <- digest
+	<-b.gate
+	return b.Backend.DeleteBlob(digest)
+}
+
+func TestStore_BackendIOUnlocked(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFSBackend(dir, filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &gatedBackend{Backend: fs, gate: make(chan struct{}), started: make(chan string, 1)}
+	s, err := NewStore(b, filepath.Join(dir, "tmp"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "a", "aaaa")
+	put(t, s, "b", "bbbb")
+
+	deleted := make(chan struct{})
+	go func() {
+		s.Delete("a")
+		close(deleted)
+	}()
+	<-b.started
+
+	// 删除进行中时其他操作不被阻塞
+	if _, ok := s.Get("b"); !ok {
+		t.Fatal("b should be cached")
+	}
+	if n := len(s.Entries("", 0)); n != 1 {
+		t.Errorf("Entries = %d, want 1", n)
+	}
+
+	// 写入相同的内容时等待删除完成, 新写入的内容不会被删除
+	committed := make(chan error, 1)
+	go func() {
+		w, err := s.Create("a2", nil)
+		if err == nil {
+			w.Write([]byte("aaaa"))
+			_, err = w.Commit()
+		}
+		committed <- err
+	}()
+	select {
+	case <-committed:
+		t.Fatal("commit of content being deleted did not wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(b.gate)
+	<-deleted
+	if err := <-committed; err != nil {
+		t.Fatal(err)
+	}
+	_, rc, err := s.Open("a2")
+	if err != nil {
+		t.Fatalf("Open(a2): %v", err)
+	}
+	rc.Close()
+}
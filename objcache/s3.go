@@ -0,0 +1,513 @@
+package objcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Options 为 S3 兼容存储的连接参数
+type S3Options struct {
+	Endpoint  string // 如 https://s3.amazonaws.com 或 http://minio:9000
+	Region    string
+	Bucket    string
+	Prefix    string // 对象名前缀, 多个实例共用同一个 bucket 时用于区分
+	AccessKey string
+	SecretKey string
+	PathStyle bool          // 使用 <endpoint>/<bucket>/<key> 形式的地址, MinIO 等通常需要开启
+	PartSize  int64         // 超过此大小的对象使用分片上传, 同时作为分片大小
+	Timeout   time.Duration // 单个请求的超时时间 (上传的请求最多包含一个分片), 读取内容时只限制等待响应头的时间; 不大于 0 时为 60 秒
+}
+
+// minPartSize 为 S3 允许的最小分片大小 (最后一个分片除外)
+const minPartSize = 5 * 1024 * 1024
+
+// defaultS3Timeout 为未设置 Timeout 时单个请求的超时时间
+const defaultS3Timeout = 60 * time.Second
+
+// S3Backend 将内容与元数据保存在 S3 兼容的对象存储中
+//
+// 对象结构:
+//
+//	<prefix>blobs/<digest[:2]>/<digest>  对象内容
+//	<prefix>meta/<name[:2]>/<name>       对象元数据 (json)
+type S3Backend struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Backend 创建 S3 兼容存储的 Backend
+func NewS3Backend(opts S3Options) (*S3Backend, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("objcache: s3 bucket is empty")
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("objcache: invalid s3 endpoint %q", opts.Endpoint)
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.PartSize < minPartSize {
+		opts.PartSize = minPartSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultS3Timeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = opts.Timeout
+	return &S3Backend{
+		opts:     opts,
+		endpoint: endpoint,
+		client:   &http.Client{Transport: transport},
+	}, nil
+}
+
+func (b *S3Backend) blobKey(digest string) string {
+	return b.opts.Prefix + "blobs/" + digest[:2] + "/" + digest
+}
+
+func (b *S3Backend) metaKey(name string) string {
+	return b.opts.Prefix + "meta/" + name[:2] + "/" + name
+}
+
+// PutBlob 上传本地文件, 大于 PartSize 的文件使用分片上传
+func (b *S3Backend) PutBlob(digest string, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("objcache: failed to open temp file: %w", err)
+	}
+	defer f.Close()
+
+	key := b.blobKey(digest)
+	if size <= b.opts.PartSize {
+		return b.putObject(key, io.NewSectionReader(f, 0, size), size)
+	}
+	return b.putMultipart(key, f, size)
+}
+
+func (b *S3Backend) OpenBlob(digest string) (io.ReadCloser, error) {
+	// 内容以流的方式交给客户端, 读取时间取决于客户端, 只限制等待响应头的时间
+	req, err := b.newRequest(context.Background(), http.MethodGet, b.blobKey(digest), nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.send(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+// OpenBlobRange 以 Range 请求读取内容中从 off 开始的 length 字节
+func (b *S3Backend) OpenBlobRange(digest string, off, length int64) (io.ReadCloser, error) {
+	req, err := b.newRequest(context.Background(), http.MethodGet, b.blobKey(digest), nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Range 不参与签名
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+length-1))
+	resp, err := b.send(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	return nil, s3Error(resp)
+}
+
+func (b *S3Backend) DeleteBlob(digest string) error {
+	return b.deleteObject(b.blobKey(digest))
+}
+
+func (b *S3Backend) WalkBlobs(fn func(digest string, size int64)) error {
+	return b.list(b.opts.Prefix+"blobs/", func(key string, size int64, _ time.Time) {
+		fn(path.Base(key), size)
+	})
+}
+
+func (b *S3Backend) PutMeta(name string, data []byte) error {
+	return b.putObject(b.metaKey(name), bytes.NewReader(data), int64(len(data)))
+}
+
+func (b *S3Backend) DeleteMeta(name string) error {
+	return b.deleteObject(b.metaKey(name))
+}
+
+// WalkMeta 列出并读取所有元数据, 以对象的最后修改时间作为访问时间
+func (b *S3Backend) WalkMeta(fn func(name string, data []byte, modTime time.Time)) error {
+	type item struct {
+		key     string
+		modTime time.Time
+	}
+	var items []item
+	if err := b.list(b.opts.Prefix+"meta/", func(key string, _ int64, modTime time.Time) {
+		items = append(items, item{key, modTime})
+	}); err != nil {
+		return err
+	}
+
+	// 元数据很小但数量可能较多, 并发读取以缩短启动时间
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, 16)
+	)
+	for _, it := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			data, err := b.getObject(it.key)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			fn(path.Base(it.key), data, it.modTime)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (b *S3Backend) getObject(key string) ([]byte, error) {
+	resp, err := b.do(http.MethodGet, key, nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (b *S3Backend) putObject(key string, body io.Reader, size int64) error {
+	resp, err := b.do(http.MethodPut, key, nil, body, size, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (b *S3Backend) deleteObject(key string) error {
+	resp, err := b.do(http.MethodDelete, key, nil, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart 以分片上传的方式上传文件, 失败时中止上传以释放已上传的分片
+func (b *S3Backend) putMultipart(key string, f *os.File, size int64) error {
+	resp, err := b.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("objcache: failed to initiate multipart upload: %v", err)
+	}
+	uploadID := initiated.UploadID
+
+	abort := func() {
+		if resp, err := b.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, 0, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	var parts []completedPart
+	for off, n := int64(0), 1; off < size; off, n = off+b.opts.PartSize, n+1 {
+		partSize := min(b.opts.PartSize, size-off)
+		query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}
+		resp, err := b.do(http.MethodPut, key, query, io.NewSectionReader(f, off, partSize), partSize, nil)
+		if err != nil {
+			abort()
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			abort()
+			return s3Error(resp)
+		}
+		parts = append(parts, completedPart{PartNumber: n, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		abort()
+		return fmt.Errorf("objcache: failed to encode multipart completion: %w", err)
+	}
+	resp, err = b.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(body), int64(len(body)), body)
+	if err != nil {
+		abort()
+		return err
+	}
+	defer resp.Body.Close()
+	// CompleteMultipartUpload 即使返回 200, 响应体中仍可能包含错误
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || bytes.Contains(data, []byte("<Error>")) {
+		abort()
+		return fmt.Errorf("objcache: s3 complete multipart upload failed: %s %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// list 使用 ListObjectsV2 遍历 prefix 下的所有对象
+func (b *S3Backend) list(prefix string, fn func(key string, size int64, modTime time.Time)) error {
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := b.do(http.MethodGet, "", query, nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return s3Error(resp)
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("objcache: failed to decode s3 list response: %w", err)
+		}
+		for _, obj := range result.Contents {
+			fn(obj.Key, obj.Size, obj.LastModified)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do 发送签名后的请求, key 为空时请求 bucket 本身, 包括读取响应体在内不超过 Timeout
+// signBody 为需要计算摘要的小请求体, 大对象的内容不参与签名 (UNSIGNED-PAYLOAD)
+func (b *S3Backend) do(method, key string, query url.Values, body io.Reader, size int64, signBody []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.opts.Timeout)
+	req, err := b.newRequest(ctx, method, key, query, body, size, signBody)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := b.send(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody 在关闭响应体时释放请求的超时上下文
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelBody) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// newRequest 构造并签名请求, 参数含义同 do
+func (b *S3Backend) newRequest(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, signBody []byte) (*http.Request, error) {
+	u := *b.endpoint
+	objectPath := "/" + key
+	if b.opts.PathStyle {
+		objectPath = "/" + b.opts.Bucket + objectPath
+	} else {
+		u.Host = b.opts.Bucket + "." + u.Host
+	}
+	u.Path = path.Join(strings.TrimSuffix(b.endpoint.Path, "/"), objectPath)
+	if key == "" && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("objcache: failed to create s3 request: %w", err)
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	payloadHash := "UNSIGNED-PAYLOAD"
+	if signBody != nil || body == nil {
+		sum := sha256.Sum256(signBody)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	b.sign(req, payloadHash, time.Now().UTC())
+	return req, nil
+}
+
+func (b *S3Backend) send(req *http.Request) (*http.Response, error) {
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("objcache: s3 %s %s: %w", req.Method, req.URL.Path, err)
+	}
+	return resp, nil
+}
+
+// sign 按 AWS Signature Version 4 为请求签名
+func (b *S3Backend) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	headerValues := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	var canonicalHeaders strings.Builder
+	for _, h := range signedHeaders {
+		canonicalHeaders.WriteString(h + ":" + headerValues[h] + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.opts.Region + "/s3/aws4_request"
+	crSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crSum[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.opts.SecretKey), date)
+	key = hmacSHA256(key, b.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.opts.AccessKey+"/"+scope+
+		", SignedHeaders="+strings.Join(signedHeaders, ";")+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery 按 SigV4 要求对查询参数排序并编码
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode 按 RFC 3986 编码, 仅保留非保留字符, encodeSlash 为 false 时保留 '/'
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			sb.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			sb.WriteByte(ch)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", ch)
+		}
+	}
+	return sb.String()
+}
+
+// s3Error 读取错误响应并关闭响应体
+func s3Error(resp *http.Response) error {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("objcache: s3 %s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, e.Code, e.Message)
+	}
+	return fmt.Errorf("objcache: s3 %s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
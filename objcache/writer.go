@@ -0,0 +1,104 @@
+package objcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Writer 将对象内容写入临时文件, 在 Commit 时才对读取方可见
+type Writer struct {
+	store  *Store
+	key    string
+	header http.Header
+	f      *os.File
+	h      hash.Hash
+	n      int64
+	expect string // 期望的内容摘要 (hex), 为空时不校验
+	closed bool
+}
+
+// Create 为 key 创建一个新的写入器, header 为需要随对象保存的响应头
+func (s *Store) Create(key string, header http.Header) (*Writer, error) {
+	f, err := os.CreateTemp(s.tmpDir, "blob-*")
+	if err != nil {
+		return nil, fmt.Errorf("objcache: failed to create temp file: %w", err)
+	}
+	return &Writer{
+		store:  s,
+		key:    key,
+		header: cloneHeader(header),
+		f:      f,
+		h:      sha256.New(),
+	}, nil
+}
+
+// Write 写入对象内容, 同时计算内容摘要
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.h.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+// ExpectDigest 设置期望的内容 sha256 摘要 (hex), Commit 时内容不匹配将被拒绝
+func (w *Writer) ExpectDigest(digest string) {
+	w.expect = digest
+}
+
+// Written 返回已写入的字节数
+func (w *Writer) Written() int64 {
+	return w.n
+}
+
+// Commit 完成写入并将对象放入缓存
+func (w *Writer) Commit() (*Entry, error) {
+	if w.closed {
+		return nil, fmt.Errorf("objcache: writer already closed")
+	}
+	w.closed = true
+	tmp := w.f.Name()
+	if err := w.f.Sync(); err != nil {
+		w.f.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("objcache: failed to sync temp file: %w", err)
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("objcache: failed to close temp file: %w", err)
+	}
+
+	digest := hex.EncodeToString(w.h.Sum(nil))
+	if w.expect != "" && digest != w.expect {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("%w: got %s, want %s", ErrDigestMismatch, digest, w.expect)
+	}
+
+	e := &Entry{
+		Key:      w.key,
+		Digest:   digest,
+		Size:     w.n,
+		Header:   w.header,
+		StoredAt: time.Now(),
+	}
+	if err := w.store.commit(tmp, e); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	cp := *e
+	return &cp, nil
+}
+
+// Abort 放弃写入并删除临时文件, 可重复调用
+func (w *Writer) Abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"ghproxy/prefetch"
+	"os"
+	"runtime"
+)
+
+// prefetchOptions 为 prefetch 子命令的参数, 非 prefetch 模式时为 nil
+var prefetchOptions *prefetch.Options
+
+// readPrefetchFlag 解析 prefetch 子命令的参数
+//
+//	ghproxy prefetch [-c config] [-j 4] [-retries 2] [-platform linux/amd64] <file>
+func readPrefetchFlag(args []string) {
+	opts := &prefetch.Options{}
+	fs := flag.NewFlagSet("prefetch", flag.ExitOnError)
+	fs.StringVar(&cfgfile, "c", configfile, "config file path")
+	fs.IntVar(&opts.Concurrency, "j", 4, "number of items fetched concurrently")
+	fs.IntVar(&opts.Retries, "retries", 2, "retries for each failed item")
+	fs.StringVar(&opts.Platform, "platform", "linux/"+runtime.GOARCH, "platform to fetch from multi-platform images")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s prefetch:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s prefetch [flags] <file>\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "The file lists one item per line, empty lines and lines starting with # are ignored:")
+		fmt.Fprintln(os.Stderr, "  https://github.com/user/repo/releases/download/v1.0/asset.tar.gz")
+		fmt.Fprintln(os.Stderr, "  https://github.com/user/repo.git   (repository, warms the git mirror; skipped outside mirror mode)")
+		fmt.Fprintln(os.Stderr, "  ghcr.io/user/image:tag             (OCI image)")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || opts.Concurrency < 1 || opts.Retries < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts.File = fs.Arg(0)
+	opts.Version = version
+	prefetchOptions = opts
+}
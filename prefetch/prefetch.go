@@ -0,0 +1,394 @@
+// Package prefetch 经由代理的完整处理流程预先拉取资源, 用于预热缓存与本地镜像
+package prefetch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"ghproxy/config"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-json-experiment/json"
+	"github.com/infinite-iroha/touka"
+)
+
+// Options 为 prefetch 子命令的参数
+type Options struct {
+	File        string
+	Concurrency int
+	Retries     int
+	Platform    string // 多平台镜像中需要预取的平台, 如 linux/amd64
+	Version     string // 用于请求的 User-Agent
+}
+
+// prefetchItem 为 prefetch 文件中的一项
+type prefetchItem struct {
+	raw  string
+	kind string // "url", "repo" 或 "image"
+}
+
+// prefetchResult 为单项的预取结果
+type prefetchResult struct {
+	item     prefetchItem
+	bytes    int64
+	attempts int
+	elapsed  time.Duration
+	skipped  string // 非空时为跳过该项的原因
+	err      error
+}
+
+// Run 经由 r 的完整处理流程预取文件中的各项, 返回进程退出码
+func Run(r *touka.Engine, cfg *config.Config, opts *Options) int {
+	items, err := readPrefetchFile(opts.File)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read prefetch file: %v\n", err)
+		return 1
+	}
+	if len(items) == 0 {
+		fmt.Println("Nothing to prefetch")
+		return 0
+	}
+
+	p := &prefetcher{r: r, cfg: cfg, opts: opts}
+	results := make([]prefetchResult, len(items))
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = p.fetchWithRetry(item)
+			printPrefetchResult(results[i])
+		}()
+	}
+	wg.Wait()
+
+	var failed, skipped int
+	var total int64
+	for _, res := range results {
+		total += res.bytes
+		switch {
+		case res.err != nil:
+			failed++
+		case res.skipped != "":
+			skipped++
+		}
+	}
+	fmt.Printf("Prefetched %d/%d items, %d skipped, %d bytes\n", len(items)-failed-skipped, len(items), skipped, total)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+var printMu sync.Mutex
+
+func printPrefetchResult(res prefetchResult) {
+	printMu.Lock()
+	defer printMu.Unlock()
+	if res.skipped != "" {
+		fmt.Printf("SKIP %s [%s]: %s\n", res.item.raw, res.item.kind, res.skipped)
+		return
+	}
+	if res.err != nil {
+		fmt.Printf("FAIL %s [%s] after %d attempt(s): %v\n", res.item.raw, res.item.kind, res.attempts, res.err)
+		return
+	}
+	fmt.Printf("OK   %s [%s] %d bytes in %s\n", res.item.raw, res.item.kind, res.bytes, res.elapsed.Round(time.Millisecond))
+}
+
+// readPrefetchFile 读取预取文件, 每行一项
+func readPrefetchFile(path string) ([]prefetchItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var items []prefetchItem
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		items = append(items, prefetchItem{raw: line, kind: classifyPrefetchItem(line)})
+	}
+	return items, scanner.Err()
+}
+
+// githubHosts 为按 URL 处理的主机, 其他不带协议的项视为镜像引用
+var githubHosts = map[string]struct{}{
+	"github.com":                 {},
+	"raw.githubusercontent.com":  {},
+	"gist.githubusercontent.com": {},
+	"api.github.com":             {},
+}
+
+func classifyPrefetchItem(line string) string {
+	u := strings.TrimPrefix(strings.TrimPrefix(line, "https://"), "http://")
+	host, rest, _ := strings.Cut(u, "/")
+	if _, ok := githubHosts[host]; !ok && u == line {
+		return "image"
+	}
+	// github.com/:user/:repo 或 github.com/:user/:repo.git 为仓库
+	if host == "github.com" {
+		rest = strings.TrimSuffix(rest, "/")
+		if parts := strings.Split(rest, "/"); len(parts) == 2 {
+			return "repo"
+		}
+	}
+	return "url"
+}
+
+// prefetcher 将预取请求直接交给路由处理, 与普通客户端请求经过相同的中间件与代理流程
+type prefetcher struct {
+	r    *touka.Engine
+	cfg  *config.Config
+	opts *Options
+}
+
+func (p *prefetcher) fetchWithRetry(item prefetchItem) prefetchResult {
+	res := prefetchResult{item: item}
+	// 仓库只能预热本地镜像, 其他模式下请求引用通告不会缓存任何内容
+	if item.kind == "repo" && p.cfg.GitClone.Mode != "mirror" {
+		res.skipped = "repositories are only prefetched in gitclone mirror mode"
+		return res
+	}
+	start := time.Now()
+	for attempt := 0; attempt <= p.opts.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		res.attempts++
+		res.bytes, res.err = p.fetch(item)
+		if res.err == nil {
+			break
+		}
+	}
+	res.elapsed = time.Since(start)
+	return res
+}
+
+func (p *prefetcher) fetch(item prefetchItem) (int64, error) {
+	switch item.kind {
+	case "repo":
+		return p.fetchRepo(item.raw)
+	case "image":
+		return p.fetchImage(item.raw)
+	default:
+		resp, err := p.do(http.MethodGet, proxyPath(item.raw), nil, false)
+		if err != nil {
+			return 0, err
+		}
+		return resp.n, nil
+	}
+}
+
+// proxyPath 将上游 URL 转换为代理路径, 如 https://github.com/a/b -> /github.com/a/b
+func proxyPath(raw string) string {
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(raw, "https://"), "http://")
+}
+
+// fetchRepo 请求仓库的引用通告并等待本地镜像首次克隆完成, 仅用于 mirror 模式
+func (p *prefetcher) fetchRepo(raw string) (int64, error) {
+	path := strings.TrimSuffix(strings.TrimSuffix(proxyPath(raw), "/"), ".git") + ".git/info/refs?service=git-upload-pack"
+	deadline := time.Now().Add(30 * time.Minute)
+	for {
+		resp, err := p.do(http.MethodGet, path, nil, false)
+		if err != nil {
+			return 0, err
+		}
+		if resp.header.Get("X-GHProxy-Cache") == "MIRROR" {
+			return resp.n, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, errors.New("timed out waiting for git mirror")
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// ociManifest 为 manifest 与 index 中预取需要的字段
+type ociManifest struct {
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		Digest string `json:"digest"`
+	} `json:"layers"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+var manifestAccept = strings.Join([]string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}, ", ")
+
+// fetchImage 依次预取镜像的 manifest, 对应平台的 manifest, config 与所有层
+func (p *prefetcher) fetchImage(ref string) (int64, error) {
+	name, reference := parseImageRef(ref)
+	base := "/v2/" + name
+
+	var total int64
+	fetchManifest := func(reference string) (*ociManifest, error) {
+		resp, err := p.do(http.MethodGet, base+"/manifests/"+reference, http.Header{"Accept": {manifestAccept}}, true)
+		if err != nil {
+			return nil, err
+		}
+		total += resp.n
+		var m ociManifest
+		if err := json.Unmarshal(resp.body.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("failed to decode manifest %s: %w", reference, err)
+		}
+		return &m, nil
+	}
+
+	m, err := fetchManifest(reference)
+	if err != nil {
+		return total, err
+	}
+	if len(m.Manifests) > 0 {
+		digest := ""
+		for _, desc := range m.Manifests {
+			platform := desc.Platform.OS + "/" + desc.Platform.Architecture
+			if platform == p.opts.Platform || platform+"/"+desc.Platform.Variant == p.opts.Platform {
+				digest = desc.Digest
+				break
+			}
+		}
+		if digest == "" {
+			return total, fmt.Errorf("no manifest for platform %s", p.opts.Platform)
+		}
+		if m, err = fetchManifest(digest); err != nil {
+			return total, err
+		}
+	}
+
+	blobs := []string{m.Config.Digest}
+	for _, layer := range m.Layers {
+		blobs = append(blobs, layer.Digest)
+	}
+	for _, digest := range blobs {
+		if digest == "" {
+			continue
+		}
+		resp, err := p.do(http.MethodGet, base+"/blobs/"+digest, nil, false)
+		if err != nil {
+			return total, err
+		}
+		total += resp.n
+	}
+	return total, nil
+}
+
+// parseImageRef 将镜像引用拆分为名称与标签/摘要, 未指定时使用 latest
+func parseImageRef(ref string) (name, reference string) {
+	if i := strings.IndexByte(ref, '@'); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	if i := strings.LastIndexByte(ref, ':'); i > strings.LastIndexByte(ref, '/') {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+// prefetchResponse 记录预取响应, 响应体默认直接丢弃
+type prefetchResponse struct {
+	header http.Header
+	status int
+	n      int64
+	body   *bytes.Buffer // 仅在需要解析响应体时非 nil
+}
+
+func (w *prefetchResponse) Header() http.Header { return w.header }
+
+func (w *prefetchResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *prefetchResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.n += int64(len(p))
+	if w.body != nil {
+		w.body.Write(p)
+	}
+	return len(p), nil
+}
+
+func (w *prefetchResponse) Flush() {}
+
+// do 在进程内执行一次请求, 非 2xx 响应视为失败
+func (p *prefetcher) do(method, path string, header http.Header, keepBody bool) (*prefetchResponse, error) {
+	target, err := url.Parse("http://localhost" + path)
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.Auth.Enabled && p.cfg.Auth.Method == "parameters" {
+		key := p.cfg.Auth.Key
+		if key == "" {
+			key = "auth_token"
+		}
+		q := target.Query()
+		q.Set(key, p.cfg.Auth.Token)
+		target.RawQuery = q.Encode()
+	}
+	req, err := http.NewRequestWithContext(context.Background(), method, target.String(), http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	req.Header.Set("User-Agent", "GHProxy-Prefetch/"+p.opts.Version)
+	for k, vv := range header {
+		req.Header[k] = vv
+	}
+	if p.cfg.Auth.Enabled && p.cfg.Auth.Method == "header" {
+		key := p.cfg.Auth.Key
+		if key == "" {
+			key = "GH-Auth"
+		}
+		req.Header.Set(key, p.cfg.Auth.Token)
+	}
+	if p.cfg.Docker.Auth && strings.HasPrefix(path, "/v2/") && len(p.cfg.Docker.Credentials) > 0 {
+		users := make([]string, 0, len(p.cfg.Docker.Credentials))
+		for user := range p.cfg.Docker.Credentials {
+			users = append(users, user)
+		}
+		sort.Strings(users)
+		req.SetBasicAuth(users[0], p.cfg.Docker.Credentials[users[0]])
+	}
+
+	resp := &prefetchResponse{header: make(http.Header)}
+	if keepBody {
+		resp.body = new(bytes.Buffer)
+	}
+	p.r.ServeHTTP(resp, req)
+	if resp.status == 0 {
+		resp.status = http.StatusOK
+	}
+	if resp.status < 200 || resp.status > 299 {
+		return nil, fmt.Errorf("%s %s: status %d", method, path, resp.status)
+	}
+	return resp, nil
+}
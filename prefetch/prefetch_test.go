@@ -0,0 +1,170 @@
+package prefetch
+
+import (
+	"ghproxy/config"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fenthope/reco"
+	"github.com/infinite-iroha/touka"
+)
+
+func TestClassifyPrefetchItem(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"https://github.com/u/r", "repo"},
+		{"https://github.com/u/r.git", "repo"},
+		{"https://github.com/u/r/", "repo"},
+		{"github.com/u/r", "repo"},
+		{"http://github.com/u/r.git", "repo"},
+		{"https://github.com/u/r/releases/download/v1/a.tar.gz", "url"},
+		{"https://github.com/u", "url"},
+		{"https://raw.githubusercontent.com/u/r/main/a", "url"},
+		{"raw.githubusercontent.com/u/r/main/a", "url"},
+		{"https://example.com/u/r", "url"},
+		{"ghcr.io/u/img:tag", "image"},
+		{"alpine", "image"},
+		{"localhost:5000/img@sha256:abc", "image"},
+	}
+	for _, tt := range tests {
+		if got := classifyPrefetchItem(tt.line); got != tt.want {
+			t.Errorf("classifyPrefetchItem(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		ref, name, reference string
+	}{
+		{"alpine", "alpine", "latest"},
+		{"ghcr.io/u/img:v1", "ghcr.io/u/img", "v1"},
+		{"ghcr.io/u/img@sha256:abc", "ghcr.io/u/img", "sha256:abc"},
+		{"localhost:5000/img", "localhost:5000/img", "latest"},
+		{"localhost:5000/img:v1", "localhost:5000/img", "v1"},
+		{"localhost:5000/img:v1@sha256:abc", "localhost:5000/img:v1", "sha256:abc"},
+	}
+	for _, tt := range tests {
+		name, reference := parseImageRef(tt.ref)
+		if name != tt.name || reference != tt.reference {
+			t.Errorf("parseImageRef(%q) = %q, %q, want %q, %q", tt.ref, name, reference, tt.name, tt.reference)
+		}
+	}
+}
+
+// imageEngine 返回提供一个多平台镜像的注册表路由, 并记录被请求的 blob
+func imageEngine(t *testing.T) (*touka.Engine, func() []string) {
+	t.Helper()
+	index := `{"manifests":[
+		{"digest":"sha256:amd64","platform":{"os":"linux","architecture":"amd64"}},
+		{"digest":"sha256:armv7","platform":{"os":"linux","architecture":"arm","variant":"v7"}},
+		{"digest":"sha256:arm64","platform":{"os":"linux","architecture":"arm64","variant":"v8"}}
+	]}`
+	manifests := map[string]string{"latest": index}
+	for _, p := range []string{"amd64", "armv7", "arm64"} {
+		manifests["sha256:"+p] = `{"config":{"digest":"sha256:config-` + p + `"},"layers":[{"digest":"sha256:layer-` + p + `"}]}`
+	}
+
+	var (
+		mu    sync.Mutex
+		blobs []string
+	)
+	logger, err := reco.New(reco.Config{Output: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logger.Close() })
+	r := touka.New()
+	r.SetLogger(logger)
+	r.GET("/v2/u/img/manifests/:ref", func(c *touka.Context) {
+		body, ok := manifests[c.Param("ref")]
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.SetHeader("Content-Type", "application/json")
+		c.String(http.StatusOK, "%s", body)
+	})
+	r.GET("/v2/u/img/blobs/:digest", func(c *touka.Context) {
+		mu.Lock()
+		blobs = append(blobs, c.Param("digest"))
+		mu.Unlock()
+		c.String(http.StatusOK, "blob")
+	})
+	return r, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := append([]string(nil), blobs...)
+		blobs = nil
+		sort.Strings(got)
+		return got
+	}
+}
+
+func TestPrefetchImagePlatform(t *testing.T) {
+	r, fetched := imageEngine(t)
+
+	tests := []struct {
+		platform string
+		want     string // 选中的平台, 为空表示没有匹配的平台
+	}{
+		{"linux/amd64", "amd64"},
+		{"linux/arm/v7", "armv7"},
+		{"linux/arm64", "arm64"},
+		{"linux/arm64/v8", "arm64"},
+		{"linux/arm", "armv7"},
+		{"linux/arm/v6", ""},
+		{"windows/amd64", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			p := &prefetcher{r: r, cfg: &config.Config{}, opts: &Options{Platform: tt.platform}}
+			n, err := p.fetchImage("u/img")
+			got := fetched()
+			if tt.want == "" {
+				if err == nil || !strings.Contains(err.Error(), "no manifest for platform") {
+					t.Errorf("err = %v, want no manifest for platform", err)
+				}
+				if len(got) != 0 {
+					t.Errorf("fetched blobs %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"sha256:config-" + tt.want, "sha256:layer-" + tt.want}
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("fetched blobs %v, want %v", got, want)
+			}
+			if n <= int64(2*len("blob")) {
+				t.Errorf("fetched %d bytes, want manifests and blobs counted", n)
+			}
+		})
+	}
+
+	// 单平台的 manifest 直接预取其 config 与层
+	p := &prefetcher{r: r, cfg: &config.Config{}, opts: &Options{Platform: "windows/amd64"}}
+	if _, err := p.fetchImage("u/img@sha256:arm64"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fetched(); len(got) != 2 || got[0] != "sha256:config-arm64" {
+		t.Errorf("fetched blobs %v", got)
+	}
+}
+
+func TestPrefetchRepoSkipped(t *testing.T) {
+	r, _ := imageEngine(t)
+	cfg := &config.Config{GitClone: config.GitCloneConfig{Mode: "cache"}}
+	p := &prefetcher{r: r, cfg: cfg, opts: &Options{Retries: 2}}
+	res := p.fetchWithRetry(prefetchItem{raw: "https://github.com/u/r", kind: "repo"})
+	if res.skipped == "" || res.err != nil || res.attempts != 0 {
+		t.Errorf("result = %+v, want skipped without attempts", res)
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"ghproxy/breaker"
+	"ghproxy/config"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WJQSERVER-STUDIO/httpc"
+	"github.com/infinite-iroha/touka"
+)
+
+// hostBreakers 上游主机熔断器, 未启用时为 nil
+var hostBreakers *breaker.Group
+
+// HostBreakers 返回上游主机熔断器, 未启用时返回 nil
+func HostBreakers() *breaker.Group {
+	return hostBreakers
+}
+
+// newBreakerGroup 解析熔断配置
+func newBreakerGroup(cfg config.BreakerConfig) (*breaker.Group, error) {
+	window, err := time.ParseDuration(cfg.Window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid breaker window %q: %w", cfg.Window, err)
+	}
+	openTimeout, err := time.ParseDuration(cfg.OpenTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid breaker openTimeout %q: %w", cfg.OpenTimeout, err)
+	}
+	if cfg.ErrorRate <= 0 || cfg.ErrorRate > 1 {
+		return nil, fmt.Errorf("invalid breaker errorRate %v: must be in (0, 1]", cfg.ErrorRate)
+	}
+	return breaker.NewGroup(breaker.Settings{
+		Window:         window,
+		MinRequests:    cfg.MinRequests,
+		ErrorRate:      cfg.ErrorRate,
+		OpenTimeout:    openTimeout,
+		HalfOpenProbes: cfg.HalfOpenProbes,
+	}), nil
+}
+
+// breakerMiddleware 返回按上游主机熔断的 httpc 中间件
+// 位于重试之外, 熔断时不再重试, 一次请求 (含重试) 只计一次结果
+func breakerMiddleware(g *breaker.Group) httpc.MiddlewareFunc {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return httpc.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			done, err := g.Get(req.URL.Host).Allow()
+			if err != nil {
+				return nil, err
+			}
+			resp, err := next.RoundTrip(req)
+			done(upstreamOutcome(req, resp, err))
+			return resp, err
+		})
+	}
+}
+
+// upstreamOutcome 判断一次上游请求的结果, 连接失败与 5xx 响应计为失败
+func upstreamOutcome(req *http.Request, resp *http.Response, err error) breaker.Outcome {
+	if err != nil {
+		// 客户端主动取消的请求与上游状态无关
+		if req.Context().Err() != nil {
+			return breaker.Ignored
+		}
+		return breaker.Failure
+	}
+	if resp.StatusCode >= 500 {
+		return breaker.Failure
+	}
+	return breaker.Success
+}
+
+// handleRequestError 处理请求上游失败, 上游被熔断时以 503 快速失败并告知客户端重试时间
+func handleRequestError(c *touka.Context, action string, err error) {
+	var openErr *breaker.OpenError
+	if errors.As(err, &openErr) {
+		c.SetHeader("Retry-After", strconv.Itoa(int(math.Ceil(openErr.RetryAfter.Seconds()))))
+		ErrorPage(c, NewErrorWithStatusLookup(http.StatusServiceUnavailable, err.Error()))
+		c.Warnf("%s %s %s %s %s Upstream circuit open: %v", c.ClientIP(), c.Request.Method, c.Request.URL.Path, c.UserAgent(), c.Request.Proto, err)
+		return
+	}
+	HandleError(c, fmt.Sprintf("%s: %v", action, err))
+}
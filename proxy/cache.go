@@ -0,0 +1,316 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"ghproxy/config"
+	"ghproxy/objcache"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WJQSERVER-STUDIO/go-utils/limitreader"
+	"github.com/fenthope/reco"
+	"github.com/infinite-iroha/touka"
+)
+
+// artifactCache 持久化缓存, 未启用时为 nil
+var artifactCache *objcache.Store
+
+// maxStale 上游出错时允许使用的缓存条目的最长过期时间, 0 表示不限制
+var maxStale time.Duration
+
+// ArtifactCache 返回持久化缓存, 未启用时返回 nil
+func ArtifactCache() *objcache.Store {
+	return artifactCache
+}
+
+// InitCache 初始化持久化缓存
+func InitCache(cfg *config.Config) error {
+	if !cfg.Cache.Enabled {
+		return nil
+	}
+	ttl, err := time.ParseDuration(cfg.Cache.ManifestTTL)
+	if err != nil {
+		return fmt.Errorf("invalid cache manifestTTL %q: %w", cfg.Cache.ManifestTTL, err)
+	}
+	manifestTTL = ttl
+	if cfg.Cache.StaleIfError {
+		maxStale, err = time.ParseDuration(cfg.Cache.MaxStale)
+		if err != nil {
+			return fmt.Errorf("invalid cache maxStale %q: %w", cfg.Cache.MaxStale, err)
+		}
+	}
+
+	maxBytes := cfg.Cache.MaxSize * 1024 * 1024
+	var store *objcache.Store
+	switch cfg.Cache.Storage {
+	case "fs", "":
+		store, err = objcache.New(cfg.Cache.Dir, maxBytes)
+	case "s3":
+		var timeout time.Duration
+		if cfg.Cache.S3.Timeout != "" {
+			if timeout, err = time.ParseDuration(cfg.Cache.S3.Timeout); err != nil {
+				return fmt.Errorf("invalid cache s3 timeout %q: %w", cfg.Cache.S3.Timeout, err)
+			}
+		}
+		var backend *objcache.S3Backend
+		backend, err = objcache.NewS3Backend(objcache.S3Options{
+			Endpoint:  cfg.Cache.S3.Endpoint,
+			Region:    cfg.Cache.S3.Region,
+			Bucket:    cfg.Cache.S3.Bucket,
+			Prefix:    cfg.Cache.S3.Prefix,
+			AccessKey: cfg.Cache.S3.AccessKey,
+			SecretKey: cfg.Cache.S3.SecretKey,
+			PathStyle: cfg.Cache.S3.PathStyle,
+			PartSize:  cfg.Cache.S3.PartSize * 1024 * 1024,
+			Timeout:   timeout,
+		})
+		if err == nil {
+			store, err = objcache.NewStore(backend, filepath.Join(cfg.Cache.Dir, "tmp"), maxBytes)
+		}
+	default:
+		err = fmt.Errorf("unsupported storage %q", cfg.Cache.Storage)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to init cache: %w", err)
+	}
+	artifactCache = store
+	return nil
+}
+
+// cacheKeyFor 返回本次请求的缓存键
+// 键取自首次进入代理流程时的上游 URL (去除查询参数), 并保存在上下文中,
+// 使内部跟随的重定向 (例如 releases 跳转到带签名的下载地址) 仍使用同一个键
+func cacheKeyFor(c *touka.Context, u string) string {
+	if key, ok := c.GetString("cacheKey"); ok {
+		return key
+	}
+	key := u
+	if i := strings.IndexByte(key, '?'); i >= 0 {
+		key = key[:i]
+	}
+	c.Set("cacheKey", key)
+	return key
+}
+
+// useArtifactCache 判断本次请求是否使用持久化缓存
+func useArtifactCache(c *touka.Context, cfg *config.Config, matcher string) bool {
+	if artifactCache == nil || c.Request.Method != http.MethodGet {
+		return false
+	}
+	switch matcher {
+	case "releases":
+		return true
+	case "raw":
+		return cfg.Cache.Raw
+	}
+	return false
+}
+
+// needsRevalidation 判断缓存条目在使用前是否需要向上游重新验证
+// 固定到标签的 releases 资源, 固定到标签或提交 SHA 的归档与 raw 文件内容不会变化, 无需验证
+func needsRevalidation(matcher string, key string) bool {
+	switch matcher {
+	case "releases":
+		return !isImmutableReleaseURL(key)
+	case "raw":
+		return !isImmutableRawURL(key)
+	}
+	return true
+}
+
+// prepareCacheRequest 调整需要写入缓存的上游请求
+// 去除客户端的 Accept-Encoding, 由 Transport 透明解压, 保证缓存内容为原始字节;
+// 若存在待验证的缓存条目, 则以其校验值替换客户端自身的条件请求头
+func prepareCacheRequest(req *http.Request, cached *objcache.Entry) {
+	req.Header.Del("Accept-Encoding")
+	if cached == nil {
+		return
+	}
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	if etag := cached.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := cached.Header.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// serveCachedArtifact 尝试直接从缓存响应请求, 命中时返回 true
+// status 为 X-GHProxy-Cache 响应头的值 (HIT / REVALIDATED / STALE)
+func serveCachedArtifact(c *touka.Context, cfg *config.Config, key string, matcher string, status string) bool {
+	entry, ok := artifactCache.Get(key)
+	if !ok {
+		return false
+	}
+
+	if clientNotModified(c.Request, entry.Header) {
+		setCachedHeaders(c, cfg, key, matcher, entry, status, http.StatusNotModified)
+		c.Debugf("Cached %s not modified for client", key)
+		c.DelHeader("Content-Type")
+		c.Status(http.StatusNotModified)
+		artifactCache.RecordHit(0)
+		return true
+	}
+
+	// 经过 Shell Editor 改写的内容与缓存的字节不对应, 不按范围响应
+	if !shellEditorApplies(cfg, key, matcher) {
+		ranges, err := requestedRanges(c.Request, entry)
+		if errors.Is(err, errUnsatisfiableRange) {
+			setCachedHeaders(c, cfg, key, matcher, entry, status, http.StatusRequestedRangeNotSatisfiable)
+			serveUnsatisfiableRange(c, entry.Size)
+			artifactCache.RecordHit(0)
+			return true
+		}
+		if len(ranges) > 0 {
+			return serveCachedRanges(c, cfg, key, matcher, entry, ranges, status)
+		}
+	}
+
+	entry, f, err := artifactCache.Open(key)
+	if err != nil {
+		return false
+	}
+	setCachedHeaders(c, cfg, key, matcher, entry, status, http.StatusOK)
+	c.Debugf("Serving %s from cache (Digest: %s, Size: %d)", key, entry.Digest, entry.Size)
+	artifactCache.RecordHit(entry.Size)
+
+	var bodyReader io.ReadCloser = f
+	if cfg.RateLimit.BandwidthLimit.Enabled {
+		bodyReader = limitreader.NewRateLimitedReader(bodyReader, bandwidthLimit, int(bandwidthBurst), c.Request.Context())
+	}
+	defer bodyReader.Close()
+
+	writeProxyBody(c, cfg, key, matcher, bodyReader, entry.Header.Get("Content-Encoding"), strconv.FormatInt(entry.Size, 10))
+	return true
+}
+
+// setCachedHeaders 设置由缓存条目响应时的响应头, code 为随后写出的状态码
+// 过期条目不应用缓存头策略, 避免前置 CDN 长时间保留过期内容
+func setCachedHeaders(c *touka.Context, cfg *config.Config, key string, matcher string, entry *objcache.Entry, status string, code int) {
+	c.SetHeaders(entry.Header)
+	// 与直接转发的响应一致; 旧版本写入的条目仍可能包含这些头
+	for name := range respHeadersToRemove {
+		c.DelHeader(name)
+	}
+	c.DelHeader(mirrorHeader)
+	setCorsHeader(c, cfg)
+	c.SetHeader("X-GHProxy-Cache", status)
+	if status == "STALE" {
+		setStaleWarning(c)
+	} else {
+		applyCachePolicy(c, cfg, matcher, immutableArtifact(matcher, key), code)
+	}
+	c.SetHeader("Accept-Ranges", "bytes")
+}
+
+// serveStaleArtifact 在上游返回 5xx 或请求失败时, 尝试以过期的缓存条目响应, 成功时返回 true
+// 仅在启用 staleIfError 且条目自上次从上游获取或验证起未超过 maxStale 时使用
+func serveStaleArtifact(c *touka.Context, cfg *config.Config, key string, matcher string, cached *objcache.Entry, reason string) bool {
+	if !cfg.Cache.StaleIfError || cached == nil {
+		return false
+	}
+	age := time.Since(cached.StoredAt)
+	if maxStale > 0 && age > maxStale {
+		c.Debugf("Cached %s too stale to serve on error (Age: %s)", key, age.Round(time.Second))
+		return false
+	}
+	if !serveCachedArtifact(c, cfg, key, matcher, "STALE") {
+		return false
+	}
+	artifactCache.RecordStale()
+	c.Warnf("Upstream failed for %s (%s), served stale cache (Age: %s)", key, reason, age.Round(time.Second))
+	return true
+}
+
+// setStaleWarning 为使用过期缓存的响应添加 Warning 头 (RFC 7234 5.5)
+func setStaleWarning(c *touka.Context) {
+	c.AddHeader("Warning", `110 - "Response is Stale"`)
+	c.AddHeader("Warning", `111 - "Revalidation Failed"`)
+}
+
+// wrapCacheBody 在上游响应可缓存时, 用写入缓存的读取器包装响应体
+// 合并下载时该函数在发起方的独立上下文中执行, 因此不使用 touka.Context
+func wrapCacheBody(logger *reco.Logger, key string, resp *http.Response) {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	w, err := artifactCache.Create(key, cacheableHeader(resp.Header))
+	if err != nil {
+		logger.Warnf("Failed to create cache writer for %s: %v", key, err)
+		return
+	}
+	resp.Body = &cacheTeeReader{
+		rc:     resp.Body,
+		w:      w,
+		key:    key,
+		expect: resp.ContentLength,
+		logger: logger,
+	}
+}
+
+// cacheableHeader 返回写入缓存条目的响应头副本
+// 去除转发时同样会移除的头, 以及只属于本次请求的上游请求 ID 与镜像名称
+func cacheableHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for key := range respHeadersToRemove {
+		h.Del(key)
+	}
+	h.Del(mirrorHeader)
+	return h
+}
+
+// cacheTeeReader 在读取上游响应体的同时将其写入缓存
+// 只有完整读取到 EOF 且长度与 Content-Length 一致时才提交, 否则丢弃
+type cacheTeeReader struct {
+	rc     io.ReadCloser
+	w      *objcache.Writer
+	key    string
+	expect int64
+	logger *reco.Logger
+}
+
+func (t *cacheTeeReader) Read(p []byte) (int, error) {
+	n, err := t.rc.Read(p)
+	if n > 0 && t.w != nil {
+		if _, werr := t.w.Write(p[:n]); werr != nil {
+			t.logger.Warnf("Failed to write cache for %s: %v", t.key, werr)
+			t.w.Abort()
+			t.w = nil
+		}
+	}
+	if err == io.EOF && t.w != nil {
+		t.finish()
+	}
+	return n, err
+}
+
+// finish 在响应体读取完毕后提交或丢弃缓存
+func (t *cacheTeeReader) finish() {
+	w := t.w
+	t.w = nil
+	if t.expect >= 0 && w.Written() != t.expect {
+		t.logger.Warnf("Discarding incomplete cache for %s: got %d bytes, want %d", t.key, w.Written(), t.expect)
+		w.Abort()
+		return
+	}
+	entry, err := w.Commit()
+	if err != nil {
+		t.logger.Warnf("Failed to commit cache for %s: %v", t.key, err)
+		return
+	}
+	t.logger.Debugf("Cached %s (Digest: %s, Size: %d)", t.key, entry.Digest, entry.Size)
+}
+
+func (t *cacheTeeReader) Close() error {
+	if t.w != nil {
+		t.w.Abort()
+		t.w = nil
+	}
+	return t.rc.Close()
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"ghproxy/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCacheTestConfig 返回启用了持久化缓存的配置, 缓存目录位于测试的临时目录
+func newCacheTestConfig(t *testing.T) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Cache.Enabled = true
+	cfg.Cache.Dir = t.TempDir()
+	cfg.Cache.MaxSize = 16
+	cfg.Cache.Raw = true
+	return cfg
+}
+
+// doGet 经 r 发出 GET 请求, header 为附加的请求头
+func doGet(r http.Handler, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCachedResponseHeaders(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'")
+		w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		w.Header().Set("X-Github-Request-Id", "ABCD:1234")
+		w.Header().Set("X-Fastly-Request-Id", "fastly")
+		io.WriteString(w, "asset")
+	}))
+	defer upstream.Close()
+
+	r := newProxyTestEngine(t, newCacheTestConfig(t), upstream.URL, "releases")
+	const target = "/u/r/releases/download/v1/a.zip"
+	stripped := []string{"Content-Security-Policy", "Strict-Transport-Security", "X-Github-Request-Id", "X-Fastly-Request-Id", mirrorHeader}
+
+	for i, want := range []string{"MISS", "HIT"} {
+		rec := doGet(r, target, nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != "asset" || rec.Header().Get("X-GHProxy-Cache") != want {
+			t.Fatalf("request %d = %d %q %q, want %s", i, rec.Code, rec.Body.String(), rec.Header().Get("X-GHProxy-Cache"), want)
+		}
+		for _, h := range stripped {
+			if v := rec.Header().Get(h); v != "" {
+				t.Errorf("request %d: %s = %q, want removed", i, h, v)
+			}
+		}
+		if rec.Header().Get("Content-Type") != "application/octet-stream" {
+			t.Errorf("request %d: Content-Type = %q", i, rec.Header().Get("Content-Type"))
+		}
+	}
+	if hits.Load() != 1 {
+		t.Errorf("upstream hits = %d, want 1", hits.Load())
+	}
+	entry, ok := artifactCache.Get(upstream.URL + target)
+	if !ok {
+		t.Fatal("entry not stored")
+	}
+	for _, h := range stripped {
+		if entry.Header.Get(h) != "" {
+			t.Errorf("stored entry keeps %s", h)
+		}
+	}
+
+	// 旧版本写入的条目包含这些头时, 响应同样将其移除
+	header := http.Header{}
+	header.Set("X-Github-Request-Id", "OLD:1")
+	header.Set(mirrorHeader, "mirror")
+	w, err := artifactCache.Create(upstream.URL+"/u/r/releases/download/v1/b.zip", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("old"))
+	if _, err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	rec := doGet(r, "/u/r/releases/download/v1/b.zip", nil)
+	if rec.Body.String() != "old" || rec.Header().Get("X-Github-Request-Id") != "" || rec.Header().Get(mirrorHeader) != "" {
+		t.Errorf("old entry = %q %v", rec.Body.String(), rec.Header())
+	}
+}
+
+func TestServeStaleArtifact(t *testing.T) {
+	var mode atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch mode.Load() {
+		case "5xx":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case "abort":
+			panic(http.ErrAbortHandler)
+		default:
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, "asset")
+		}
+	}))
+	defer upstream.Close()
+	t.Cleanup(func() { maxStale = 0 })
+
+	const target = "/u/r/main/a.txt"
+	for _, tc := range []struct {
+		name         string
+		staleIfError bool
+		maxStale     string
+		failure      string
+		wantStale    bool
+	}{
+		{"5xx", true, "24h", "5xx", true},
+		{"TransportError", true, "24h", "abort", true},
+		{"TooStale", true, "1ms", "5xx", false},
+		{"TooStaleTransportError", true, "1ms", "abort", false},
+		{"Disabled", false, "24h", "5xx", false},
+		{"DisabledTransportError", false, "24h", "abort", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newCacheTestConfig(t)
+			cfg.Cache.StaleIfError = tc.staleIfError
+			cfg.Cache.MaxStale = tc.maxStale
+			r := newProxyTestEngine(t, cfg, upstream.URL, "raw")
+
+			mode.Store("ok")
+			if rec := doGet(r, target, nil); rec.Header().Get("X-GHProxy-Cache") != "MISS" {
+				t.Fatalf("populate = %d %v", rec.Code, rec.Header())
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			mode.Store(tc.failure)
+			rec := doGet(r, target, nil)
+			stale := rec.Header().Get("X-GHProxy-Cache") == "STALE"
+			if stale != tc.wantStale {
+				t.Fatalf("stale = %t, want %t (%d %q %v)", stale, tc.wantStale, rec.Code, rec.Body.String(), rec.Header())
+			}
+			if !tc.wantStale {
+				if rec.Code < http.StatusInternalServerError || rec.Body.String() == "asset" {
+					t.Errorf("error response = %d %q", rec.Code, rec.Body.String())
+				}
+				return
+			}
+			if rec.Code != http.StatusOK || rec.Body.String() != "asset" {
+				t.Errorf("stale response = %d %q", rec.Code, rec.Body.String())
+			}
+			if len(rec.Header().Values("Warning")) == 0 {
+				t.Error("stale response without Warning")
+			}
+			if rec.Header().Get("ETag") != `"v1"` {
+				t.Errorf("stale response headers = %v", rec.Header())
+			}
+		})
+	}
+}
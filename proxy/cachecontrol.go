@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"ghproxy/config"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// cachePolicyFor 返回 matcher 对应的缓存头策略, 未启用或没有对应类型时返回 nil
+// OCI 请求使用 "manifests" 与 "blobs"
+func cachePolicyFor(cfg *config.Config, matcher string) *config.CachePolicyConfig {
+	if !cfg.CacheControl.Enabled {
+		return nil
+	}
+	switch matcher {
+	case "releases":
+		return &cfg.CacheControl.Releases
+	case "raw":
+		return &cfg.CacheControl.Raw
+	case "gist":
+		return &cfg.CacheControl.Gist
+	case "api":
+		return &cfg.CacheControl.Api
+	case "clone":
+		return &cfg.CacheControl.Clone
+	case "manifests":
+		return &cfg.CacheControl.Manifests
+	case "blobs":
+		return &cfg.CacheControl.Blobs
+	}
+	return nil
+}
+
+// applyCachePolicy 按配置改写返回给客户端的缓存头, 须在写出状态码之前调用
+// immutable 表示响应内容不会变化; 只作用于成功与 304 响应, 返回是否应用了策略
+func applyCachePolicy(c *touka.Context, cfg *config.Config, matcher string, immutable bool, status int) bool {
+	policy := cachePolicyFor(cfg, matcher)
+	if policy == nil {
+		return false
+	}
+	switch status {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified:
+	default:
+		return false
+	}
+
+	cacheControl := policy.CacheControl
+	if immutable && policy.Immutable != "" {
+		cacheControl = policy.Immutable
+	}
+	if cacheControl != "" {
+		c.SetHeader("Cache-Control", cacheControl)
+		// 避免与 HTTP/1.0 的缓存头冲突
+		c.DelHeader("Expires")
+		c.DelHeader("Pragma")
+	}
+	if policy.Vary != "" {
+		c.SetHeader("Vary", policy.Vary)
+	}
+	if policy.SurrogateControl != "" {
+		c.SetHeader("Surrogate-Control", policy.SurrogateControl)
+	}
+	return true
+}
+
+// immutableArtifact 判断 GitHub 资源的内容是否不会变化 (固定到提交 SHA 的 raw 文件)
+func immutableArtifact(matcher string, u string) bool {
+	return matcher == "raw" && isImmutableRawURL(u)
+}
+
+// ociCachePolicy 根据注册表请求的 URL 判断其缓存头类型与内容是否不变
+// blob 与按摘要引用的 manifest 内容不会变化, 其余请求 (如标签列表) 返回空类型
+func ociCachePolicy(u string) (string, bool) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", false
+	}
+	p := parsed.Path
+	if i := strings.LastIndex(p, "/blobs/"); i >= 0 {
+		return "blobs", ociBlobDigest(p[i:]) != ""
+	}
+	if i := strings.LastIndex(p, "/manifests/"); i >= 0 {
+		if ref, ok := ociManifestRef(p[i:]); ok {
+			return "manifests", manifestRefDigest(ref) != ""
+		}
+	}
+	return "", false
+}
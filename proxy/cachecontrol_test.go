@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"ghproxy/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fenthope/reco"
+	"github.com/infinite-iroha/touka"
+)
+
+// newProxyTestEngine 初始化客户端与缓存, 返回将所有请求经 ChunkedProxyRequest 转发到 upstream 的引擎
+func newProxyTestEngine(t *testing.T, cfg *config.Config, upstream string, matcher string) *touka.Engine {
+	t.Helper()
+	if _, err := InitReq(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := InitReq(config.DefaultConfig()); err != nil {
+			t.Error(err)
+		}
+	})
+	logger, err := reco.New(reco.Config{Output: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logger.Close() })
+	r := touka.New()
+	r.SetLogger(logger)
+	r.GET("/*filepath", func(c *touka.Context) {
+		ChunkedProxyRequest(c.Request.Context(), c, upstream+c.Request.URL.Path, cfg, matcher)
+	})
+	return r
+}
+
+func TestApplyCachePolicyCredentials(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		io.WriteString(w, "asset")
+	}))
+	defer upstream.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.CacheControl.Enabled = true
+	cfg.Auth.PassThrough = true
+	r := newProxyTestEngine(t, cfg, upstream.URL, "releases")
+
+	for _, tc := range []struct {
+		name, target, auth, want string
+	}{
+		{"anonymous", "/u/r/releases/download/v1/a", "", "public, max-age=86400"},
+		{"client header", "/u/r/releases/download/v1/a", "token secret", "private, max-age=60"},
+		{"pass-through token", "/u/r/releases/download/v1/a?token=secret", "", "private, max-age=60"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.String() != "asset" {
+			t.Fatalf("%s: %d %q", tc.name, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Cache-Control"); got != tc.want {
+			t.Errorf("%s: Cache-Control = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"ghproxy/config"
+	"ghproxy/objcache"
 	"io"
 	"net/http"
 	"strconv"
@@ -13,6 +14,9 @@ import (
 )
 
 func ChunkedProxyRequest(ctx context.Context, c *touka.Context, u string, cfg *config.Config, matcher string) {
+	ctx = withUpstreamMatcher(ctx, matcher)
+	ctx = withSourceClient(ctx, c.ClientIP())
+	ctx, credentialUsed := withServerCredentialFlag(ctx)
 
 	var (
 		req  *http.Request
@@ -20,6 +24,22 @@ func ChunkedProxyRequest(ctx context.Context, c *touka.Context, u string, cfg *c
 		err  error
 	)
 
+	var (
+		cacheKey string
+		cached   *objcache.Entry // 需要向上游重新验证的缓存条目
+	)
+	// 跟随重定向前的请求附加了服务端凭据时, 重定向后的内容同样可能是私有的
+	useCache := useArtifactCache(c, cfg, matcher) && !credentialUsed.Load()
+	if useCache {
+		cacheKey = cacheKeyFor(c, u)
+		if entry, ok := artifactCache.Get(cacheKey); ok {
+			if !needsRevalidation(matcher, cacheKey) && serveCachedArtifact(c, cfg, cacheKey, matcher, "HIT") {
+				return
+			}
+			cached = entry
+		}
+	}
+
 	go func() {
 		<-ctx.Done()
 		if resp != nil && resp.Body != nil {
@@ -43,10 +63,95 @@ func ChunkedProxyRequest(ctx context.Context, c *touka.Context, u string, cfg *c
 
 	setRequestHeaders(c, req, cfg, matcher)
 	AuthPassThrough(c, cfg, req)
+	// Shell Editor 会改写响应体, 需要从上游获取完整内容, 并以 200 返回给客户端
+	if shellEditorApplies(cfg, u, matcher) {
+		req.Header.Del("Range")
+		req.Header.Del("If-Range")
+	}
+	// 携带客户端凭据的响应可能是私有内容, 不写入缓存, 也不改写其缓存头
+	clientAuth := req.Header.Get("Authorization") != ""
+	if useCache && clientAuth {
+		useCache = false
+		cached = nil
+	}
+	if useCache {
+		prepareCacheRequest(req, cached)
+	}
 
-	resp, err = client.Do(req)
+	// 可缓存的成功响应在请求上游后立即包装为写入缓存的响应体,
+	// 合并下载时只由发起方写入一次
+	logger := c.GetLogger()
+	chain := mirrorChainFor(matcher, u)
+	fetch := func(req *http.Request) (*http.Response, error) {
+		var (
+			resp *http.Response
+			err  error
+		)
+		if chain != nil {
+			var mirror string
+			resp, mirror, err = fetchFromMirrors(req, chain)
+			if err == nil {
+				resp.Header.Set(mirrorHeader, mirror)
+			}
+		} else {
+			resp, err = client.Do(req)
+		}
+		if err == nil {
+			wrapStallBody(logger, clientTimeouts.bodyStall, matcher, resp)
+			refetch := func(req *http.Request) (*http.Response, error) {
+				resp, err := client.Do(req)
+				if err == nil {
+					wrapStallBody(logger, clientTimeouts.bodyStall, matcher, resp)
+				}
+				return resp, err
+			}
+			// 分段下载的各分段自行重试, 不再续传
+			if useSegments(matcher, resp) {
+				wrapSegmentedBody(logger, matcher, resp, refetch)
+			} else {
+				wrapResumeBody(logger, matcher, resp, refetch)
+			}
+		}
+		// 附加了服务端凭据的响应可能是私有内容, 不写入缓存
+		if err == nil && useCache && !credentialUsed.Load() {
+			wrapCacheBody(logger, cacheKey, resp)
+		}
+		return resp, err
+	}
+
+	if useFlights(req, matcher) && !credentialUsed.Load() && !serverCredentialMayApply(req) {
+		resp, err = downloadFlights.Do(ctx, flightKey(u, req), func(fetchCtx context.Context) (*http.Response, error) {
+			return fetch(req.Clone(fetchCtx))
+		})
+	} else {
+		resp, err = fetch(req)
+	}
 	if err != nil {
-		HandleError(c, fmt.Sprintf("Failed to send request: %v", err))
+		if serveStaleArtifact(c, cfg, cacheKey, matcher, cached, err.Error()) {
+			return
+		}
+		handleRequestError(c, "Failed to send request", err)
+		return
+	}
+
+	// 上游确认缓存仍然有效
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		c.Debugf("Revalidated cached %s", cacheKey)
+		if err := artifactCache.Refresh(cacheKey); err != nil {
+			c.Debugf("Failed to refresh cached %s: %v", cacheKey, err)
+		}
+		if serveCachedArtifact(c, cfg, cacheKey, matcher, "REVALIDATED") {
+			return
+		}
+		// 缓存条目在验证期间被逐出, 重新完整请求
+		ChunkedProxyRequest(ctx, c, u, cfg, matcher)
+		return
+	}
+
+	// 上游出错时使用过期缓存
+	if resp.StatusCode >= 500 && serveStaleArtifact(c, cfg, cacheKey, matcher, cached, resp.Status) {
+		resp.Body.Close()
 		return
 	}
 
@@ -60,11 +165,19 @@ func ChunkedProxyRequest(ctx context.Context, c *touka.Context, u string, cfg *c
 	if resp.StatusCode == 302 || resp.StatusCode == 301 {
 		//c.Debugf("resp header %s", resp.Header)
 		finalURL := resp.Header.Get("Location")
+		// 镜像可能返回相对地址
+		if loc, err := resp.Location(); err == nil {
+			finalURL = loc.String()
+		}
 		if finalURL != "" {
 			err = resp.Body.Close()
 			if err != nil {
 				c.Errorf("Failed to close response body: %v", err)
 			}
+			// 跳转后的响应不再经过回退链, 保留提供跳转的镜像名称
+			if mirror := resp.Header.Get(mirrorHeader); mirror != "" {
+				c.SetHeader(mirrorHeader, mirror)
+			}
 			c.Infof("Internal Redirecting to %s", finalURL)
 			ChunkedProxyRequest(ctx, c, finalURL, cfg, matcher)
 			return
@@ -87,6 +200,12 @@ func ChunkedProxyRequest(ctx context.Context, c *touka.Context, u string, cfg *c
 			c.Warnf("%s %s %s %s %s Content-Length header is not a valid integer: %v", c.ClientIP(), c.Request.Method, c.Request.URL.Path, c.UserAgent(), c.Request.Proto, err)
 			bodySize = -1
 		}
+		// 部分内容响应按资源的完整长度判断, 与完整下载保持一致
+		if err == nil && resp.StatusCode == http.StatusPartialContent {
+			if total, ok := contentRangeSize(resp.Header.Get("Content-Range")); ok {
+				bodySize = int(total)
+			}
+		}
 		if err == nil && bodySize > sizelimit {
 			finalURL := resp.Request.URL.String()
 			err = resp.Body.Close()
@@ -99,21 +218,21 @@ func ChunkedProxyRequest(ctx context.Context, c *touka.Context, u string, cfg *c
 		}
 	}
 
+	if useCache && !credentialUsed.Load() && resp.StatusCode == http.StatusOK {
+		c.SetHeader("X-GHProxy-Cache", "MISS")
+		artifactCache.RecordMiss()
+	}
+
 	// 复制响应头，排除需要移除的 header
 	c.SetHeaders(resp.Header)
 	for key := range respHeadersToRemove {
 		c.DelHeader(key)
 	}
 
-	switch cfg.Server.Cors {
-	case "*":
-		c.Header("Access-Control-Allow-Origin", "*")
-	case "":
-		c.Header("Access-Control-Allow-Origin", "*")
-	case "nil":
-		c.Header("Access-Control-Allow-Origin", "")
-	default:
-		c.Header("Access-Control-Allow-Origin", cfg.Server.Cors)
+	setCorsHeader(c, cfg)
+	// 携带凭据 (客户端透传或服务端附加) 的响应保留上游的缓存头 (GitHub 对其返回 private)
+	if !clientAuth && !credentialUsed.Load() {
+		applyCachePolicy(c, cfg, matcher, immutableArtifact(matcher, u), resp.StatusCode)
 	}
 
 	c.Status(resp.StatusCode)
@@ -126,19 +245,29 @@ func ChunkedProxyRequest(ctx context.Context, c *touka.Context, u string, cfg *c
 
 	defer bodyReader.Close()
 
-	if MatcherShell(u) && matchString(matcher) && cfg.Shell.Editor {
+	writeProxyBody(c, cfg, u, matcher, bodyReader, resp.Header.Get("Content-Encoding"), contentLength)
+}
+
+// shellEditorApplies 判断响应体是否需要经过 Shell Editor 改写
+func shellEditorApplies(cfg *config.Config, u string, matcher string) bool {
+	return cfg.Shell.Editor && MatcherShell(u) && matchString(matcher)
+}
+
+// writeProxyBody 将响应体写回客户端, 对需要改写的脚本文件启用 Shell Editor
+func writeProxyBody(c *touka.Context, cfg *config.Config, u string, matcher string, bodyReader io.ReadCloser, contentEncoding string, contentLength string) {
+	if shellEditorApplies(cfg, u, matcher) {
 		// 判断body是不是gzip
 		var compress string
-		if resp.Header.Get("Content-Encoding") == "gzip" {
+		if contentEncoding == "gzip" {
 			compress = "gzip"
 		}
 
 		c.Debugf("Use Shell Editor: %s %s %s %s %s", c.ClientIP(), c.Request.Method, u, c.UserAgent(), c.Request.Proto)
 		c.Header("Content-Length", "")
+		// 改写后的内容无法按范围请求
+		c.DelHeader("Accept-Ranges")
 
-		var reader io.Reader
-
-		reader, _, err = processLinks(bodyReader, compress, c.Request.Host, cfg, c)
+		reader, _, err := processLinks(bodyReader, compress, c.Request.Host, cfg, c)
 		c.WriteStream(reader)
 		if err != nil {
 			c.Errorf("%s %s %s %s %s Failed to copy response body: %v", c.ClientIP(), c.Request.Method, u, c.UserAgent(), c.Request.Proto, err)
@@ -154,5 +283,4 @@ func ChunkedProxyRequest(ctx context.Context, c *touka.Context, u string, cfg *c
 		}
 		c.WriteStream(bodyReader)
 	}
-
 }
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// serverCredentialKey 为请求上下文中记录是否附加了服务端凭据的键
+type serverCredentialKey struct{}
+
+// withServerCredentialFlag 在上下文中放入一个标记, 由附加服务端凭据的中间件设置, 已存在时沿用
+// 标记随请求的副本 (重试, 续传, 内部跟随的重定向) 共享, 用于在请求上游后判断响应是否可以缓存
+func withServerCredentialFlag(ctx context.Context) (context.Context, *atomic.Bool) {
+	if used, ok := ctx.Value(serverCredentialKey{}).(*atomic.Bool); ok {
+		return ctx, used
+	}
+	used := new(atomic.Bool)
+	return context.WithValue(ctx, serverCredentialKey{}, used), used
+}
+
+// markServerCredential 记录请求附加了不可缓存的服务端凭据, 其响应可能包含私有内容
+func markServerCredential(ctx context.Context) {
+	if used, ok := ctx.Value(serverCredentialKey{}).(*atomic.Bool); ok {
+		used.Store(true)
+	}
+}
+
+// serverCredentialMayApply 判断请求是否可能附加不可缓存的服务端凭据
+// 合并下载在请求上游前决定, 只能按可能性排除
+func serverCredentialMayApply(req *http.Request) bool {
+	if githubTokens != nil && !githubTokens.cacheable && githubTokens.applies(req) {
+		return true
+	}
+	if req.Header.Get("Authorization") != "" {
+		return false
+	}
+	inst := instanceHosts[strings.ToLower(req.URL.Hostname())]
+	return inst != nil && inst.token != ""
+}
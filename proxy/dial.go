@@ -6,11 +6,14 @@
 package proxy
 
 import (
+	"context"
 	"ghproxy/config"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"golang.org/x/net/proxy"
 )
@@ -22,6 +25,17 @@ func initTransport(cfg *config.Config, transport *http.Transport) {
 		return
 	}
 
+	// 配置了代理池时由代理池为每个连接选择代理
+	if outboundPool != nil {
+		transport.Proxy = outboundPool.Proxy
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+		}
+		transport.DialContext = outboundPool.wrapDial(dial)
+		return
+	}
+
 	// 如果代理 URL 未设置，使用环境变量中的代理配置
 	if cfg.Outbound.Url == "" {
 		transport.Proxy = http.ProxyFromEnvironment
@@ -45,8 +59,8 @@ func initTransport(cfg *config.Config, transport *http.Transport) {
 		transport.Proxy = http.ProxyURL(proxyInfo) // 设置 HTTP(S) 代理
 		log.Printf("Using HTTP(S) proxy: %s", cfg.Outbound.Url)
 	case "socks5": // 如果是 SOCKS5 代理
-		// 调用 newProxyDial 创建 SOCKS5 代理拨号器
-		proxyDialer := newProxyDial(cfg.Outbound.Url)
+		// 调用 newProxyDial 创建 SOCKS5 代理拨号器, 与代理的连接沿用 Transport 原有的拨号方式
+		proxyDialer := newProxyDial(cfg.Outbound.Url, forwardDialer(transport))
 		transport.Proxy = nil // 禁用 HTTP Proxy 设置，因为 SOCKS5 不需要 HTTP Proxy
 
 		// 尝试将 Dialer 转换为支持上下文的 ContextDialer
@@ -67,9 +81,9 @@ func initTransport(cfg *config.Config, transport *http.Transport) {
 	}
 }
 
-// newProxyDial 创建一个 SOCKS5 代理拨号器
-func newProxyDial(proxyUrls string) proxy.Dialer {
-	var proxyDialer proxy.Dialer = proxy.Direct // 初始为直接连接，不使用代理
+// newProxyDial 创建一个 SOCKS5 代理拨号器, forward 用于建立与第一个代理的连接
+func newProxyDial(proxyUrls string, forward proxy.Dialer) proxy.Dialer {
+	proxyDialer := forward // 初始为直接连接，不使用代理
 
 	// 支持多个代理 URL（以逗号分隔）
 	for _, proxyUrl := range strings.Split(proxyUrls, ",") {
@@ -141,3 +155,23 @@ func createSocksDialer(host string, auth *proxy.Auth, previous proxy.Dialer) (pr
 	// 调用 golang.org/x/net/proxy 提供的 SOCKS5 方法创建拨号器
 	return proxy.SOCKS5("tcp", host, auth, previous)
 }
+
+// forwardDialer 返回 Transport 建立连接所用的拨号器
+// 配置了上游解析器或源地址时, 与 SOCKS5 代理的连接同样经解析器并以所选源地址建立
+func forwardDialer(transport *http.Transport) proxy.Dialer {
+	if transport.DialContext == nil {
+		return proxy.Direct
+	}
+	return dialFunc(transport.DialContext)
+}
+
+// dialFunc 将 DialContext 函数适配为 proxy.ContextDialer
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (f dialFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+func (f dialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"fmt"
+	"ghproxy/config"
+	"ghproxy/resolver"
+	"net/http"
+	"time"
+)
+
+// upstreamResolver 上游域名解析器, 未启用时为 nil
+var upstreamResolver *resolver.Resolver
+
+// initResolver 根据 [dns] 配置创建上游域名解析器
+func initResolver(cfg *config.Config) error {
+	upstreamResolver = nil
+	if !cfg.DNS.Enabled {
+		return nil
+	}
+	settings := resolver.Settings{
+		Servers: cfg.DNS.Servers,
+		Hosts:   cfg.DNS.Hosts,
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"timeout", cfg.DNS.Timeout, &settings.Timeout},
+		{"cacheTTL", cfg.DNS.CacheTTL, &settings.CacheTTL},
+		{"dialTimeout", cfg.DNS.DialTimeout, &settings.DialTimeout},
+		{"failCooldown", cfg.DNS.FailCooldown, &settings.FailCooldown},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid dns %s %q: %w", d.name, d.value, err)
+		}
+		*d.dst = v
+	}
+	r, err := resolver.New(settings)
+	if err != nil {
+		return err
+	}
+	upstreamResolver = r
+	return nil
+}
+
+// setTransportResolver 使 Transport 通过上游域名解析器建立连接
+// 须在设置出站代理之前调用, 以便 SOCKS5 代理经该拨号函数连接代理, 代理池包装拨号函数
+func setTransportResolver(transport *http.Transport) {
+	if upstreamResolver != nil {
+		transport.DialContext = upstreamResolver.DialContext
+	}
+}
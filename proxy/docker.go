@@ -31,6 +31,11 @@ type imageInfo struct {
 	User  string
 	Repo  string
 	Image string
+	// BlobDigest 为需要写入缓存的 blob 摘要 (hex), 为空时不缓存
+	BlobDigest string
+	// ManifestKey 与 ManifestRef 为需要写入缓存的 manifest 的缓存键与引用, 为空时不缓存
+	ManifestKey string
+	ManifestRef string
 }
 
 // InitWeakCache 初始化弱引用缓存
@@ -197,12 +202,30 @@ func OciWithImageRouting(cfg *config.Config) touka.HandlerFunc {
 			Image: imageNameForAuth,
 		}
 
+		// blob 按摘要寻址, 命中缓存时无需经过认证流程
+		if digest := ociBlobDigest(extpath); useOciBlobCache(c, cfg, digest) {
+			if serveCachedOciBlob(c, cfg, digest) {
+				return
+			}
+			iInfo.BlobDigest = digest
+		}
+		if ref, ok := ociManifestRef(extpath); ok && useOciManifestCache(c, cfg) {
+			key := ociManifestCacheKey(target, imageNameForAuth, ref, c.Request)
+			if serveCachedOciManifest(c, cfg, key, ref, false) {
+				return
+			}
+			iInfo.ManifestKey = key
+			iInfo.ManifestRef = ref
+		}
+
 		GhcrRequest(c.Request.Context(), c, finalreqUrl, iInfo, cfg, target)
 	}
 }
 
 // GhcrRequest 执行对Docker注册表的HTTP请求, 处理认证和重定向
 func GhcrRequest(ctx context.Context, c *touka.Context, u string, image *imageInfo, cfg *config.Config, target string) {
+	ctx = withUpstreamMatcher(ctx, "docker")
+	ctx = withSourceClient(ctx, c.ClientIP())
 	var (
 		method string
 		req    *http.Request
@@ -241,6 +264,10 @@ func GhcrRequest(ctx context.Context, c *touka.Context, u string, image *imageIn
 	// 设置 Host 头部为上游目标
 	req.Header.Set("Host", target)
 
+	// 客户端的凭据 (如 docker.auth 的 Basic 认证) 不发往注册表, 只使用代理获取的匿名令牌 (ChallengeReq),
+	// 因此拉取到的只有公开镜像, 其 blob 与 manifest 可以写入缓存, 命中时无需认证, 并应用缓存头策略
+	req.Header.Del("Authorization")
+
 	// 尝试从缓存中获取并使用认证令牌
 	if image != nil && image.Image != "" {
 		token, exist := cache.Get(image.Image)
@@ -252,7 +279,10 @@ func GhcrRequest(ctx context.Context, c *touka.Context, u string, image *imageIn
 	// 发送初始请求
 	resp, err = ghcrclient.Do(req)
 	if err != nil {
-		HandleError(c, fmt.Sprintf("Failed to send request: %v", err))
+		if serveStaleOciManifest(c, cfg, image) {
+			return
+		}
+		handleRequestError(c, "Failed to send request", err)
 		return
 	}
 
@@ -304,7 +334,10 @@ func GhcrRequest(ctx context.Context, c *touka.Context, u string, image *imageIn
 
 				resp_retry, err_retry := ghcrclient.Do(req_retry)
 				if err_retry != nil {
-					HandleError(c, fmt.Sprintf("Failed to send retry request: %v", err_retry))
+					if serveStaleOciManifest(c, cfg, image) {
+						return
+					}
+					handleRequestError(c, "Failed to send retry request", err_retry)
 					return
 				}
 				c.Debugf("Retry request completed with status code: %d", resp_retry.StatusCode)
@@ -353,7 +386,7 @@ func GhcrRequest(ctx context.Context, c *touka.Context, u string, image *imageIn
 		c.Debugf("Executing redirect request to: %s", redirectURL.String())
 		redirectResp, err := ghcrclient.Do(redirectReq)
 		if err != nil {
-			HandleError(c, fmt.Sprintf("Failed to execute redirect request to %s: %v", redirectURL.String(), err))
+			handleRequestError(c, fmt.Sprintf("Failed to execute redirect request to %s", redirectURL.String()), err)
 			return
 		}
 		c.Debugf("Redirect request to %s completed with status %d", redirectURL.String(), redirectResp.StatusCode)
@@ -399,8 +432,27 @@ func GhcrRequest(ctx context.Context, c *touka.Context, u string, image *imageIn
 		}
 	}
 
+	// 上游故障时以过期的 manifest 响应
+	if (resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests) && serveStaleOciManifest(c, cfg, image) {
+		_ = resp.Body.Close()
+		return
+	}
+
+	wrapStallBody(c.GetLogger(), clientTimeouts.bodyStall, "docker", resp)
+	// 校验并缓存 blob 与 manifest, 仅 GET 请求携带响应体
+	if image != nil && method == http.MethodGet {
+		if image.BlobDigest != "" {
+			wrapOciBlobBody(c.GetLogger(), image.BlobDigest, resp)
+		}
+		if image.ManifestKey != "" {
+			wrapOciManifestBody(c.GetLogger(), image, resp)
+		}
+	}
+
 	// 将上游响应头部复制到客户端响应
 	c.SetHeaders(resp.Header)
+	ociMatcher, immutable := ociCachePolicy(u)
+	applyCachePolicy(c, cfg, ociMatcher, immutable, resp.StatusCode)
 	// 设置客户端响应状态码
 	c.Status(resp.StatusCode)
 	// bodyReader 的所有权将转移给 SetBodyStream, 不再由此函数管理关闭
@@ -444,7 +496,7 @@ func ChallengeReq(target string, image *imageInfo, ctx context.Context, c *touka
 
 	resp401, err = ghcrclient.Do(req401)
 	if err != nil {
-		HandleError(c, fmt.Sprintf("Failed to send request: %v", err))
+		handleRequestError(c, "Failed to send request", err)
 		return
 	}
 	defer resp401.Body.Close() // 确保响应体关闭
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"ghproxy/config"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// downloadFlights 合并相同上游 URL 的并发下载, 未启用时为 nil
+var downloadFlights *flightGroup
+
+// initFlights 初始化并发下载合并
+// 上游响应体先写入本地暂存文件, 各客户端按自己的速度从暂存文件读取,
+// 因此慢速客户端不会拖慢其他客户端
+func initFlights(cfg *config.Config) error {
+	if !cfg.Cache.Coalesce {
+		return nil
+	}
+	spoolDir := os.TempDir()
+	if cfg.Cache.Enabled {
+		spoolDir = filepath.Join(cfg.Cache.Dir, "spool")
+		// 暂存文件只在进程运行期间有效
+		if err := os.RemoveAll(spoolDir); err != nil {
+			return fmt.Errorf("failed to clean spool dir: %w", err)
+		}
+		if err := os.MkdirAll(spoolDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create spool dir: %w", err)
+		}
+	}
+	downloadFlights = &flightGroup{
+		spoolDir: spoolDir,
+		flights:  make(map[string]*flight),
+	}
+	return nil
+}
+
+// useFlights 判断本次请求是否参与下载合并
+// 携带客户端凭据或 Range 的请求各自独立请求上游
+func useFlights(req *http.Request, matcher string) bool {
+	if downloadFlights == nil || req.Method != http.MethodGet {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" || req.Header.Get("Range") != "" {
+		return false
+	}
+	return matcher == "releases" || matcher == "raw"
+}
+
+// flightKey 返回请求的合并键
+// 条件请求头或可接受的编码不同的请求可能得到不同的响应, 不能合并
+func flightKey(u string, req *http.Request) string {
+	return u + "\x00" + req.Header.Get("If-None-Match") + "\x00" + req.Header.Get("If-Modified-Since") + "\x00" + req.Header.Get("Accept-Encoding")
+}
+
+// flightGroup 按 key 管理进行中的上游下载
+type flightGroup struct {
+	spoolDir string
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight 表示一次进行中的共享上游下载
+type flight struct {
+	group *flightGroup
+	key   string
+	ready chan struct{} // 响应头就绪或请求失败后关闭
+
+	// 以下字段在 ready 关闭后只读
+	resp *http.Response
+	err  error
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	spool    *os.File
+	size     int64 // 已写入暂存文件的字节数
+	done     bool  // 上游响应体已读取完毕 (或失败)
+	bodyErr  error
+	readers  int
+	persist  bool // 响应体同时写入持久化缓存, 无读取方时也继续下载
+	canceled bool // 最后一个读取方已离开, 下载正在取消, 不再接受新的读取方
+	cancel   context.CancelFunc
+}
+
+// Do 执行或加入 key 对应的上游下载, 返回的响应体从共享的暂存文件读取
+// fetch 在独立于客户端的上下文中执行, 单个客户端断开不会取消共享下载
+func (g *flightGroup) Do(ctx context.Context, key string, fetch func(ctx context.Context) (*http.Response, error)) (*http.Response, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if ok {
+		// 正在取消的下载会从 g.flights 中移除, 此时由本次请求重新发起
+		f.mu.Lock()
+		if f.canceled {
+			ok = false
+		} else {
+			f.readers++
+		}
+		f.mu.Unlock()
+	}
+	if !ok {
+		f = &flight{
+			group:   g,
+			key:     key,
+			ready:   make(chan struct{}),
+			readers: 1,
+		}
+		f.cond = sync.NewCond(&f.mu)
+		g.flights[key] = f
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f.cancel = cancel
+		go f.run(fetchCtx, fetch)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.ready:
+	case <-ctx.Done():
+		f.release()
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		f.release()
+		return nil, f.err
+	}
+
+	resp := *f.resp
+	resp.Header = f.resp.Header.Clone()
+	resp.Body = &flightReader{f: f}
+	return &resp, nil
+}
+
+// run 由发起方执行, 将上游响应体写入暂存文件并通知读取方
+func (f *flight) run(ctx context.Context, fetch func(ctx context.Context) (*http.Response, error)) {
+	defer f.cancel()
+
+	resp, err := fetch(ctx)
+	if err == nil {
+		f.spool, err = os.CreateTemp(f.group.spoolDir, "flight-*")
+		if err != nil {
+			resp.Body.Close()
+			err = fmt.Errorf("failed to create spool file: %w", err)
+		}
+	}
+	if err != nil {
+		f.err = err
+		f.group.remove(f)
+		close(f.ready)
+		return
+	}
+	defer resp.Body.Close()
+
+	f.mu.Lock()
+	_, f.persist = resp.Body.(*cacheTeeReader)
+	f.mu.Unlock()
+	f.resp = resp
+	close(f.ready)
+
+	buf := make([]byte, BufferSize)
+	for {
+		n, rerr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := f.spool.Write(buf[:n]); werr != nil {
+				rerr = fmt.Errorf("failed to write spool file: %w", werr)
+			} else {
+				f.mu.Lock()
+				f.size += int64(n)
+				f.cond.Broadcast()
+				f.mu.Unlock()
+			}
+		}
+		if rerr != nil {
+			// 已完成的下载不再接受新的读取方, 之后的请求将重新请求上游或命中缓存
+			f.group.remove(f)
+
+			f.mu.Lock()
+			f.done = true
+			if rerr != io.EOF {
+				f.bodyErr = rerr
+			}
+			idle := f.readers == 0
+			f.cond.Broadcast()
+			f.mu.Unlock()
+
+			if idle {
+				f.closeSpool()
+			}
+			return
+		}
+	}
+}
+
+// release 注销一个读取方, 最后一个读取方离开时清理暂存文件或取消无人需要的下载
+func (f *flight) release() {
+	f.mu.Lock()
+	f.readers--
+	last := f.readers == 0
+	done := f.done
+	persist := f.persist
+	if last && !done && !persist {
+		f.canceled = true
+	}
+	f.mu.Unlock()
+	if !last {
+		return
+	}
+	if done {
+		f.closeSpool()
+		return
+	}
+	if !persist {
+		f.group.remove(f)
+		f.cancel()
+	}
+}
+
+func (f *flight) closeSpool() {
+	if f.spool == nil {
+		return
+	}
+	f.spool.Close()
+	os.Remove(f.spool.Name())
+}
+
+func (g *flightGroup) remove(f *flight) {
+	g.mu.Lock()
+	if g.flights[f.key] == f {
+		delete(g.flights, f.key)
+	}
+	g.mu.Unlock()
+}
+
+// flightReader 从共享暂存文件中按自身进度读取响应体
+type flightReader struct {
+	f         *flight
+	off       int64
+	closed    bool // 由 f.mu 保护
+	closeOnce sync.Once
+}
+
+func (r *flightReader) Read(p []byte) (int, error) {
+	f := r.f
+	f.mu.Lock()
+	for r.off >= f.size && !f.done && !r.closed {
+		f.cond.Wait()
+	}
+	if r.closed {
+		f.mu.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	avail := f.size - r.off
+	done, bodyErr := f.done, f.bodyErr
+	f.mu.Unlock()
+
+	if avail <= 0 {
+		if done && bodyErr != nil {
+			return 0, bodyErr
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > avail {
+		p = p[:avail]
+	}
+	n, err := f.spool.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Close 注销读取方, 并唤醒可能仍阻塞在 Read 中的调用
+func (r *flightReader) Close() error {
+	r.closeOnce.Do(func() {
+		r.f.mu.Lock()
+		r.closed = true
+		r.f.cond.Broadcast()
+		r.f.mu.Unlock()
+		r.f.release()
+	})
+	return nil
+}
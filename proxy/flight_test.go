@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor 等待 cond 成立, 超时后测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlightGroup(t *testing.T) {
+	const body = "shared upstream body"
+	newGroup := func(t *testing.T) *flightGroup {
+		return &flightGroup{spoolDir: t.TempDir(), flights: make(map[string]*flight)}
+	}
+	readers := func(g *flightGroup, key string) int {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		f := g.flights[key]
+		if f == nil {
+			return 0
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.readers
+	}
+	spoolEmpty := func(g *flightGroup) func() bool {
+		return func() bool {
+			entries, _ := os.ReadDir(g.spoolDir)
+			return len(entries) == 0
+		}
+	}
+	okResponse := func(r io.Reader) *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Etag": {`"v1"`}}, Body: io.NopCloser(r)}
+	}
+
+	t.Run("Joiners", func(t *testing.T) {
+		g := newGroup(t)
+		var calls atomic.Int32
+		release := make(chan struct{})
+		fetch := func(ctx context.Context) (*http.Response, error) {
+			calls.Add(1)
+			<-release
+			return okResponse(strings.NewReader(body)), nil
+		}
+
+		const n = 5
+		var wg sync.WaitGroup
+		got := make([]string, n)
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := g.Do(context.Background(), "k", fetch)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer resp.Body.Close()
+				b, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Error(err)
+				}
+				got[i] = string(b)
+			}()
+		}
+		waitFor(t, "joiners", func() bool { return readers(g, "k") == n })
+		close(release)
+		wg.Wait()
+
+		if calls.Load() != 1 {
+			t.Errorf("fetch called %d times", calls.Load())
+		}
+		for i, b := range got {
+			if b != body {
+				t.Errorf("reader %d got %q", i, b)
+			}
+		}
+		// 暂存文件在最后一个读取方关闭后删除
+		waitFor(t, "spool cleanup", spoolEmpty(g))
+		if len(g.flights) != 0 {
+			t.Errorf("finished flight still registered")
+		}
+	})
+
+	t.Run("LeaderCancel", func(t *testing.T) {
+		g := newGroup(t)
+		release := make(chan struct{})
+		fetchCtx := make(chan context.Context, 1)
+		fetch := func(ctx context.Context) (*http.Response, error) {
+			fetchCtx <- ctx
+			<-release
+			return okResponse(strings.NewReader(body)), nil
+		}
+
+		leaderCtx, cancel := context.WithCancel(context.Background())
+		leaderErr := make(chan error, 1)
+		go func() {
+			_, err := g.Do(leaderCtx, "k", fetch)
+			leaderErr <- err
+		}()
+		ctx := <-fetchCtx
+		joined := make(chan string, 1)
+		go func() {
+			resp, err := g.Do(context.Background(), "k", fetch)
+			if err != nil {
+				t.Error(err)
+				joined <- ""
+				return
+			}
+			defer resp.Body.Close()
+			b, _ := io.ReadAll(resp.Body)
+			joined <- string(b)
+		}()
+		waitFor(t, "joiner", func() bool { return readers(g, "k") == 2 })
+
+		// 发起方断开不影响仍在等待的读取方
+		cancel()
+		if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+			t.Errorf("leader error = %v", err)
+		}
+		if ctx.Err() != nil {
+			t.Error("shared fetch canceled while a reader is waiting")
+		}
+		close(release)
+		if got := <-joined; got != body {
+			t.Errorf("joiner got %q", got)
+		}
+		waitFor(t, "spool cleanup", spoolEmpty(g))
+	})
+
+	t.Run("AllCanceled", func(t *testing.T) {
+		g := newGroup(t)
+		fetchDone := make(chan struct{})
+		fetch := func(ctx context.Context) (*http.Response, error) {
+			defer close(fetchDone)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			for readers(g, "k") != 1 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+		}()
+		if _, err := g.Do(ctx, "k", fetch); !errors.Is(err, context.Canceled) {
+			t.Errorf("Do error = %v", err)
+		}
+		// 没有读取方时取消上游请求
+		select {
+		case <-fetchDone:
+		case <-time.After(5 * time.Second):
+			t.Fatal("fetch not canceled after the last caller left")
+		}
+		waitFor(t, "flight removal", func() bool { return readers(g, "k") == 0 })
+	})
+
+	t.Run("JoinAfterCancel", func(t *testing.T) {
+		g := newGroup(t)
+		unblock := make(chan struct{})
+		var once sync.Once
+		defer once.Do(func() { close(unblock) })
+		// 取消后的下载在返回前仍停留一段时间, 新的请求不能加入
+		canceled := func(ctx context.Context) (*http.Response, error) {
+			<-ctx.Done()
+			<-unblock
+			return nil, ctx.Err()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			for readers(g, "k") != 1 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+		}()
+		if _, err := g.Do(ctx, "k", canceled); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Do error = %v", err)
+		}
+		if readers(g, "k") != 0 {
+			t.Error("canceled flight still registered")
+		}
+
+		var calls atomic.Int32
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			resp, err := g.Do(context.Background(), "k", func(context.Context) (*http.Response, error) {
+				calls.Add(1)
+				return okResponse(strings.NewReader(body)), nil
+			})
+			if err != nil {
+				t.Errorf("Do after cancel: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			if b, err := io.ReadAll(resp.Body); err != nil || string(b) != body {
+				t.Errorf("Do after cancel read %q, %v", b, err)
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("new request joined the canceled flight")
+		}
+		if calls.Load() != 1 {
+			t.Errorf("new fetch called %d times", calls.Load())
+		}
+		once.Do(func() { close(unblock) })
+		waitFor(t, "spool cleanup", spoolEmpty(g))
+	})
+
+	t.Run("ReaderClosedMidBody", func(t *testing.T) {
+		g := newGroup(t)
+		pr, pw := io.Pipe()
+		fetch := func(ctx context.Context) (*http.Response, error) {
+			return okResponse(pr), nil
+		}
+		resp, err := g.Do(context.Background(), "k", fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pw.Write([]byte("partial"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(resp.Body, buf); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		// 无人读取且不写入缓存的下载被取消, 暂存文件随之删除
+		pw.CloseWithError(errors.New("upstream aborted"))
+		waitFor(t, "spool cleanup", spoolEmpty(g))
+	})
+
+	t.Run("UpstreamError", func(t *testing.T) {
+		g := newGroup(t)
+		upstreamErr := errors.New("dial failed")
+		if _, err := g.Do(context.Background(), "k", func(context.Context) (*http.Response, error) {
+			return nil, upstreamErr
+		}); !errors.Is(err, upstreamErr) {
+			t.Errorf("Do error = %v", err)
+		}
+		if len(g.flights) != 0 {
+			t.Error("failed flight still registered")
+		}
+	})
+}
+
+func TestFlightKey(t *testing.T) {
+	u := "https://raw.githubusercontent.com/u/r/main/a.sh"
+	plain, _ := http.NewRequest(http.MethodGet, u, nil)
+	gzipped, _ := http.NewRequest(http.MethodGet, u, nil)
+	gzipped.Header.Set("Accept-Encoding", "gzip")
+	conditional, _ := http.NewRequest(http.MethodGet, u, nil)
+	conditional.Header.Set("If-None-Match", `"v1"`)
+
+	keys := map[string]bool{}
+	for _, req := range []*http.Request{plain, gzipped, conditional} {
+		keys[flightKey(u, req)] = true
+	}
+	if len(keys) != 3 {
+		t.Errorf("requests with different encodings or validators share a flight key")
+	}
+	if flightKey(u, plain) != flightKey(u, plain.Clone(context.Background())) {
+		t.Error("identical requests should share a flight key")
+	}
+}
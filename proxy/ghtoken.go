@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"fmt"
+	"ghproxy/config"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/WJQSERVER-STUDIO/httpc"
+)
+
+// githubTokens 服务端 GitHub 令牌池, 未启用时为 nil
+var githubTokens *tokenPool
+
+// githubTokenHosts 接受令牌的 GitHub 主机, 不包括 releases 重定向到的对象存储
+var githubTokenHosts = map[string]bool{
+	"github.com":                 true,
+	"api.github.com":             true,
+	"codeload.github.com":        true,
+	"raw.githubusercontent.com":  true,
+	"gist.github.com":            true,
+	"gist.githubusercontent.com": true,
+}
+
+// tokenScopeHeaders 暴露服务端令牌信息的响应头, 不返回给客户端
+var tokenScopeHeaders = []string{"X-OAuth-Scopes", "X-Accepted-OAuth-Scopes", "X-OAuth-Client-Id", "X-GitHub-SSO"}
+
+// invalidTokenCooldown 被 GitHub 拒绝 (401) 的令牌暂停使用的时间
+const invalidTokenCooldown = 10 * time.Minute
+
+// defaultTokenMatchers 未配置 matchers 时附加令牌的请求类型
+var defaultTokenMatchers = []string{"api"}
+
+// githubToken 为令牌池中的一个令牌, 日志中只使用序号
+type githubToken struct {
+	id    int
+	value string
+
+	mu        sync.Mutex
+	remaining int       // 剩余配额, -1 表示未知
+	reset     time.Time // 配额重置时间
+	disabled  bool      // 令牌可以访问私有仓库, 不再使用
+}
+
+// available 判断令牌当前是否可用, 并返回用于排序的剩余配额, 未知时视为最多
+func (t *githubToken) available(now time.Time) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.disabled || t.remaining == 0 && now.Before(t.reset) {
+		return 0, false
+	}
+	if t.remaining < 0 || !now.Before(t.reset) {
+		return math.MaxInt, true
+	}
+	return t.remaining, true
+}
+
+// tokenPool 为发往 GitHub 的匿名请求附加服务端令牌
+// 优先使用剩余配额最多的令牌, 配额耗尽的令牌在重置前不再使用
+// 令牌供所有匿名用户使用, 只应能访问公开仓库, 带有 repo 权限的令牌在首次响应后停用
+type tokenPool struct {
+	tokens    []*githubToken
+	hosts     map[string]bool
+	matchers  map[string]bool
+	cacheable bool // 使用令牌获取的响应是否可以缓存与合并
+	next      atomic.Uint64
+	drained   atomic.Bool // 所有令牌均不可用, 用于避免重复记录日志
+}
+
+// newTokenPool 解析令牌池配置
+func newTokenPool(cfg config.GithubTokensConfig) (*tokenPool, error) {
+	p := &tokenPool{hosts: githubTokenHosts, cacheable: cfg.Cache}
+	for _, v := range cfg.Tokens {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		p.tokens = append(p.tokens, &githubToken{id: len(p.tokens) + 1, value: v, remaining: -1})
+	}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("githubTokens is enabled but no tokens are configured")
+	}
+	matchers := cfg.Matchers
+	if len(matchers) == 0 {
+		matchers = defaultTokenMatchers
+	}
+	p.matchers = make(map[string]bool, len(matchers))
+	for _, m := range matchers {
+		p.matchers[m] = true
+	}
+	return p, nil
+}
+
+// applies 判断请求是否附加令牌: 发往 GitHub, 匹配的请求类型, 且客户端未提供凭据
+func (p *tokenPool) applies(req *http.Request) bool {
+	if req.Header.Get("Authorization") != "" {
+		return false
+	}
+	if !p.hosts[strings.ToLower(req.URL.Hostname())] {
+		return false
+	}
+	return p.matchers[upstreamMatcher(req.Context())]
+}
+
+// hasRepoScope 判断响应是否表明令牌带有可以访问私有仓库的 repo 权限
+// fine-grained 令牌不返回 X-OAuth-Scopes, 无法据此判断
+func hasRepoScope(resp *http.Response) bool {
+	for _, scope := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		if strings.TrimSpace(scope) == "repo" {
+			return true
+		}
+	}
+	return false
+}
+
+// disable 停用可以访问私有仓库的令牌, 返回令牌此前是否可用
+func (t *githubToken) disable() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.disabled {
+		return false
+	}
+	t.disabled = true
+	return true
+}
+
+// pick 选择剩余配额最多的可用令牌, 配额相同时轮流使用, 全部不可用时返回 nil
+func (p *tokenPool) pick(now time.Time, skip map[*githubToken]bool) *githubToken {
+	var (
+		best     *githubToken
+		bestLeft int
+	)
+	start := int(p.next.Add(1) % uint64(len(p.tokens)))
+	for i := range p.tokens {
+		t := p.tokens[(start+i)%len(p.tokens)]
+		if skip[t] {
+			continue
+		}
+		if left, ok := t.available(now); ok && (best == nil || left > bestLeft) {
+			best, bestLeft = t, left
+		}
+	}
+	return best
+}
+
+// observe 根据 GitHub 返回的配额信息更新令牌状态, 返回令牌是否已不可用
+func (p *tokenPool) observe(t *githubToken, resp *http.Response, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	wasAvailable := t.remaining != 0 || !now.Before(t.reset)
+
+	if v, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		t.remaining = v
+	}
+	if v, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		t.reset = time.Unix(v, 0)
+	}
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		t.remaining = 0
+		t.reset = now.Add(invalidTokenCooldown)
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		// 次级速率限制通过 Retry-After 指明等待时间
+		if v, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			t.remaining = 0
+			t.reset = now.Add(time.Duration(v) * time.Second)
+		}
+	}
+
+	exhausted := t.remaining == 0 && now.Before(t.reset)
+	if exhausted && wasAvailable {
+		if resp.StatusCode == http.StatusUnauthorized {
+			log.Printf("GitHub token #%d was rejected, retrying after %s", t.id, t.reset.Format(time.RFC3339))
+		} else {
+			log.Printf("GitHub token #%d rate limited until %s", t.id, t.reset.Format(time.RFC3339))
+		}
+	}
+	return exhausted
+}
+
+// middleware 返回附加令牌的 httpc 中间件
+// 须位于调试日志之内, 令牌只出现在发往上游的请求副本中, 返回的响应仍关联原请求
+func (p *tokenPool) middleware(next http.RoundTripper) http.RoundTripper {
+	return httpc.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !p.applies(req) {
+			return next.RoundTrip(req)
+		}
+		// 没有请求体的请求在令牌配额耗尽时可以换用下一个令牌重试
+		replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+		tried := make(map[*githubToken]bool, len(p.tokens))
+		sent := 0
+		// attempt 发送请求的副本, 重试时重新获取请求体
+		attempt := func(token string) (*http.Response, error) {
+			r := req.Clone(req.Context())
+			if sent > 0 && req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+			sent++
+			if token != "" {
+				r.Header.Set("Authorization", "token "+token)
+			}
+			resp, err := next.RoundTrip(r)
+			if err != nil {
+				return nil, err
+			}
+			resp.Request = req
+			return resp, nil
+		}
+		discard := func(resp *http.Response) {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+		for {
+			t := p.pick(time.Now(), tried)
+			if t == nil {
+				if len(tried) == 0 && p.drained.CompareAndSwap(false, true) {
+					log.Printf("All GitHub tokens are rate limited, sending requests anonymously")
+				}
+				return attempt("")
+			}
+			p.drained.Store(false)
+			tried[t] = true
+
+			resp, err := attempt(t.value)
+			if err != nil {
+				return nil, err
+			}
+			// 令牌可以访问私有仓库时不使用其响应, 避免匿名用户读取私有内容
+			if hasRepoScope(resp) {
+				if t.disable() {
+					log.Printf("GitHub token #%d has the repo scope and can read private repositories, disabled", t.id)
+				}
+				discard(resp)
+				if !replayable {
+					return nil, fmt.Errorf("GitHub token #%d has the repo scope", t.id)
+				}
+				continue
+			}
+			if p.observe(t, resp, time.Now()) && replayable && len(tried) < len(p.tokens) {
+				discard(resp)
+				continue
+			}
+			if !p.cacheable {
+				markServerCredential(req.Context())
+			}
+			for _, h := range tokenScopeHeaders {
+				resp.Header.Del(h)
+			}
+			return resp, nil
+		}
+	})
+}
+
+// initGithubTokens 根据配置创建服务端令牌池
+func initGithubTokens(cfg *config.Config) error {
+	githubTokens = nil
+	if !cfg.GithubTokens.Enabled {
+		return nil
+	}
+	pool, err := newTokenPool(cfg.GithubTokens)
+	if err != nil {
+		return err
+	}
+	githubTokens = pool
+	matchers := make([]string, 0, len(pool.matchers))
+	for m := range pool.matchers {
+		matchers = append(matchers, m)
+	}
+	sort.Strings(matchers)
+	log.Printf("Using %d server-side GitHub tokens for %s (cache: %t)", len(pool.tokens), strings.Join(matchers, ", "), pool.cacheable)
+	return nil
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"ghproxy/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenPool(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		mu.Lock()
+		seen = append(seen, auth)
+		mu.Unlock()
+		w.Header().Set("X-OAuth-Scopes", "public_repo, read:org")
+		w.Header().Set("X-RateLimit-Reset", reset)
+		switch auth {
+		case "token a":
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+		case "token b":
+			w.Header().Set("X-RateLimit-Remaining", "10")
+		case "token c":
+			w.Header().Set("X-RateLimit-Remaining", "20")
+		}
+	}))
+	defer srv.Close()
+
+	pool, err := newTokenPool(config.GithubTokensConfig{Tokens: []string{"a", " ", "b", "c"}, Matchers: []string{"api"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(srv.URL)
+	pool.hosts = map[string]bool{u.Hostname(): true}
+	rt := pool.middleware(http.DefaultTransport)
+
+	var credentialUsed *atomic.Bool
+	do := func(matcher, auth string) (*http.Response, string) {
+		t.Helper()
+		mu.Lock()
+		seen = nil
+		mu.Unlock()
+		var ctx context.Context
+		ctx, credentialUsed = withServerCredentialFlag(withUpstreamMatcher(context.Background(), matcher))
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.Request != req || resp.Request.Header.Get("Authorization") != auth {
+			t.Error("response should reference the original request")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return resp, seen[len(seen)-1]
+	}
+
+	// 未知配额的令牌依次尝试, 耗尽的令牌换用下一个
+	for range 3 {
+		resp, used := do("api", "")
+		if resp.StatusCode != http.StatusOK || used == "token a" {
+			t.Errorf("status = %d, used %q", resp.StatusCode, used)
+		}
+		if resp.Header.Get("X-OAuth-Scopes") != "" {
+			t.Error("token scopes leaked to response")
+		}
+		if !credentialUsed.Load() {
+			t.Error("request with a server token not marked")
+		}
+	}
+	// 配额已知后使用剩余最多的令牌
+	if _, used := do("api", ""); used != "token c" {
+		t.Errorf("used %q, want token c", used)
+	}
+	if _, used := do("api", "token client"); used != "token client" {
+		t.Errorf("client credentials replaced: %q", used)
+	}
+	if _, used := do("raw", ""); used != "" || credentialUsed.Load() {
+		t.Errorf("token attached to unmatched request: %q", used)
+	}
+
+	// 所有令牌耗尽时匿名请求
+	for _, tok := range pool.tokens {
+		tok.remaining = 0
+		tok.reset = time.Now().Add(time.Minute)
+	}
+	if _, used := do("api", ""); used != "" {
+		t.Errorf("used %q, want anonymous", used)
+	}
+	// 重置时间过后恢复使用
+	for _, tok := range pool.tokens {
+		tok.reset = time.Now().Add(-time.Second)
+	}
+	if _, used := do("api", ""); used == "" {
+		t.Error("tokens should be usable after reset")
+	}
+}
+
+func TestTokenPoolRepoScope(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "token private" {
+			w.Header().Set("X-OAuth-Scopes", "read:org, repo")
+		}
+		w.Header().Set("X-Auth", r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	newPool := func(cfg config.GithubTokensConfig) (*tokenPool, http.RoundTripper) {
+		pool, err := newTokenPool(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, _ := url.Parse(srv.URL)
+		pool.hosts = map[string]bool{u.Hostname(): true}
+		return pool, pool.middleware(http.DefaultTransport)
+	}
+	do := func(rt http.RoundTripper, matcher string) (string, bool) {
+		t.Helper()
+		ctx, used := withServerCredentialFlag(withUpstreamMatcher(context.Background(), matcher))
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("X-Auth"), used.Load()
+	}
+
+	// 可以访问私有仓库的令牌被停用, 其响应不会返回给客户端
+	pool, rt := newPool(config.GithubTokensConfig{Tokens: []string{"private"}})
+	for range 2 {
+		if auth, used := do(rt, "api"); auth != "" || used {
+			t.Errorf("response fetched with %q returned (marked: %t), want anonymous", auth, used)
+		}
+	}
+	if _, ok := pool.tokens[0].available(time.Now()); ok {
+		t.Error("repo scoped token still available")
+	}
+
+	// 未配置 matchers 时只用于 api
+	_, rt = newPool(config.GithubTokensConfig{Tokens: []string{"public"}})
+	if auth, used := do(rt, "api"); auth != "token public" || !used {
+		t.Errorf("api request sent with %q (marked: %t)", auth, used)
+	}
+	if auth, _ := do(rt, "releases"); auth != "" {
+		t.Errorf("token attached to releases request by default: %q", auth)
+	}
+
+	// 开启 cache 后使用令牌的响应不标记
+	_, rt = newPool(config.GithubTokensConfig{Tokens: []string{"public"}, Cache: true})
+	if auth, used := do(rt, "api"); auth != "token public" || used {
+		t.Errorf("api request sent with %q (marked: %t), want unmarked", auth, used)
+	}
+}
@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"ghproxy/config"
+	"ghproxy/gitmirror"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/WJQSERVER-STUDIO/go-utils/limitreader"
+	"github.com/infinite-iroha/touka"
+)
+
+// gitMirrors 本地 git 镜像, 仅在 mirror 模式下初始化
+var gitMirrors *gitmirror.Manager
+
+// initGitMirror 初始化 mirror 模式使用的本地镜像管理器
+func initGitMirror(cfg *config.Config) error {
+	refresh, err := time.ParseDuration(cfg.GitClone.MirrorRefresh)
+	if err != nil {
+		return fmt.Errorf("invalid gitclone mirrorRefresh %q: %w", cfg.GitClone.MirrorRefresh, err)
+	}
+	gitProxy, gitConfig, err := gitMirrorOptions(cfg)
+	if err != nil {
+		return fmt.Errorf("gitclone mirror mode: %w", err)
+	}
+	idle, err := time.ParseDuration(cfg.GitClone.MirrorIdle)
+	if err != nil {
+		return fmt.Errorf("invalid gitclone mirrorIdle %q: %w", cfg.GitClone.MirrorIdle, err)
+	}
+	if len(cfg.GitClone.MirrorRepos) == 0 {
+		log.Printf("Git mirror mode is enabled but mirrorRepos is empty, all clones go to upstream")
+	}
+	if len(cfg.Instances) > 0 {
+		log.Printf("Git mirror mode only mirrors github.com, clones from GitHub instances go to upstream")
+	}
+	gitMirrors, err = gitmirror.New(cfg.GitClone.MirrorDir, gitmirror.Options{
+		Refresh:     refresh,
+		MaxClones:   cfg.GitClone.MirrorMaxClones,
+		MaxSize:     int64(cfg.GitClone.MirrorMaxSize) * 1024 * 1024,
+		IdleTimeout: idle,
+		GitProxy:    gitProxy,
+		GitConfig:   gitConfig,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to init git mirror: %w", err)
+	}
+	return nil
+}
+
+// gitMirrorHost 为本地镜像克隆与刷新的上游主机, 发往 GitHub 实例的请求不使用镜像
+const gitMirrorHost = "github.com"
+
+// gitMirrorOptions 将上游连接设置转换为 git 子进程的设置
+// 出站代理 (含代理池与匹配 clone 的出站路由) 以 http.proxy 传递, 匹配 github.com 的 TLS 规则中的
+// 客户端证书, 最低版本与公钥固定以 http.sslCert / http.sslKey / http.sslVersion / http.pinnedPubkey 传递;
+// git 无法使用的设置 (上游 DNS, 源地址, socks5 代理链, 附加的 CA 证书, 作用于 HTTPS 代理的 TLS 规则) 返回错误
+func gitMirrorOptions(cfg *config.Config) (func() string, []string, error) {
+	if cfg.DNS.Enabled {
+		return nil, nil, fmt.Errorf("[dns] is not supported, git resolves %s with the system resolver", gitMirrorHost)
+	}
+	if cfg.Httpc.Source.Enabled {
+		return nil, nil, fmt.Errorf("[httpc.source] is not supported, git cannot bind to a source address")
+	}
+
+	var (
+		gitProxy func() string
+		proxies  []string
+	)
+	router, err := newOutboundRouter(cfg.Outbound, &http.Transport{})
+	if err != nil {
+		return nil, nil, err
+	}
+	req, _ := http.NewRequestWithContext(withUpstreamMatcher(context.Background(), "clone"), http.MethodGet, "https://"+gitMirrorHost+"/", nil)
+	var route *outboundRoute
+	if router != nil {
+		route = router.route(req)
+	}
+	if route != nil {
+		if route.via != "direct" {
+			proxies = []string{cfg.Outbound.Proxies[route.via]}
+		}
+	} else if pool := outboundPool; pool != nil {
+		gitProxy = func() string { return gitProxyURL(pool.ProxyURL()) }
+		proxies = cfg.Outbound.Pool
+	} else if cfg.Outbound.Enabled && cfg.Outbound.Url != "" {
+		proxies = []string{cfg.Outbound.Url}
+	}
+	for _, p := range proxies {
+		if strings.Contains(p, ",") {
+			return nil, nil, fmt.Errorf("outbound proxy chain %q is not supported by git", p)
+		}
+		u, err := url.Parse(strings.TrimSpace(p))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid outbound proxy %q: %w", p, err)
+		}
+		if strings.EqualFold(u.Scheme, "https") && gitTLSRule(cfg, u.Hostname()) != nil {
+			return nil, nil, fmt.Errorf("[[httpc.tls]] rules for HTTPS proxy %s are not supported", u.Hostname())
+		}
+	}
+	if gitProxy == nil && len(proxies) == 1 {
+		proxyURL := gitProxyURL(strings.TrimSpace(proxies[0]))
+		gitProxy = func() string { return proxyURL }
+	}
+
+	rule := gitTLSRule(cfg, gitMirrorHost)
+	if rule == nil {
+		return gitProxy, nil, nil
+	}
+	// git (libcurl) 的 http.sslCAInfo 会替换系统根证书, 与规则附加信任的语义不同
+	if len(rule.CAFiles) > 0 {
+		return nil, nil, fmt.Errorf("[[httpc.tls]] caFiles for %s are not supported, git would no longer trust the system roots", gitMirrorHost)
+	}
+	var gitConfig []string
+	if rule.CertFile != "" {
+		gitConfig = append(gitConfig, "http.sslCert="+rule.CertFile, "http.sslKey="+rule.KeyFile)
+	}
+	if rule.MinVersion != "" {
+		gitConfig = append(gitConfig, "http.sslVersion=tlsv"+rule.MinVersion)
+	}
+	if len(rule.SPKIPins) > 0 {
+		pins := make([]string, 0, len(rule.SPKIPins))
+		for _, pin := range rule.SPKIPins {
+			sum, err := parseSPKIPin(pin)
+			if err != nil {
+				return nil, nil, err
+			}
+			pins = append(pins, "sha256//"+base64.StdEncoding.EncodeToString(sum))
+		}
+		gitConfig = append(gitConfig, "http.pinnedPubkey="+strings.Join(pins, ";"))
+	}
+	return gitProxy, gitConfig, nil
+}
+
+// gitProxyURL 返回传递给 git 的代理地址
+// 经 socks5 代理时由代理解析上游域名, 与 ghproxy 自身一致, 对应 libcurl 的 socks5h
+func gitProxyURL(proxyURL string) string {
+	if scheme, rest, ok := strings.Cut(proxyURL, "://"); ok && strings.EqualFold(scheme, "socks5") {
+		return "socks5h://" + rest
+	}
+	return proxyURL
+}
+
+// gitTLSRule 返回 [[httpc.tls]] 中第一条匹配 host 的规则, 均不匹配时返回 nil
+func gitTLSRule(cfg *config.Config, host string) *config.UpstreamTLSConfig {
+	host = strings.ToLower(host)
+	for i, rc := range cfg.Httpc.TLS {
+		for _, pattern := range rc.Hosts {
+			if ok, _ := path.Match(strings.ToLower(strings.TrimSpace(pattern)), host); ok {
+				return &cfg.Httpc.TLS[i]
+			}
+		}
+	}
+	return nil
+}
+
+// useGitMirror 判断仓库是否使用本地镜像, 只有 mirrorRepos 选中的仓库使用
+func useGitMirror(cfg *config.Config, user, repo string) bool {
+	name := strings.ToLower(user + "/" + repo)
+	for _, pattern := range cfg.GitClone.MirrorRepos {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// serveGitMirror 尝试以本地镜像响应 git 克隆请求, 已处理时返回 true
+// 推送, 携带凭据的请求, 发往 GitHub 实例的请求, 未选中的仓库以及首次克隆尚未完成的仓库返回 false, 由调用方直接请求上游
+func serveGitMirror(c *touka.Context, u string, cfg *config.Config, body io.Reader) bool {
+	if c.Request.Header.Get("Authorization") != "" {
+		return false
+	}
+	// 镜像按 user/repo 存放且克隆时不使用实例的 CA 与令牌, 只用于 github.com
+	if inst, _, _ := matchInstance(u); inst != nil {
+		return false
+	}
+	userPath, repoPath, remainingPath, queryParams, err := extractParts(u)
+	if err != nil {
+		return false
+	}
+	user := strings.TrimPrefix(userPath, "/")
+	repo := strings.TrimSuffix(strings.TrimPrefix(repoPath, "/"), ".git")
+
+	var advertise bool
+	switch {
+	case remainingPath == "/info/refs" && c.Request.Method == http.MethodGet && queryParams.Get("service") == "git-upload-pack":
+		advertise = true
+	case remainingPath == "/git-upload-pack" && c.Request.Method == http.MethodPost:
+	default:
+		return false
+	}
+	if !gitmirror.ValidName(user) || !gitmirror.ValidName(repo) || !useGitMirror(cfg, user, repo) {
+		return false
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	remote := parsed.Scheme + "://" + parsed.Host + "/" + user + "/" + repo + ".git"
+
+	ctx := c.Request.Context()
+	repoDir, err := gitMirrors.Prepare(ctx, remote, user, repo)
+	if errors.Is(err, gitmirror.ErrNotReady) {
+		if lastErr := gitMirrors.LastError(user, repo); lastErr != nil {
+			c.Debugf("Git mirror for %s/%s unavailable: %v", user, repo, lastErr)
+		} else {
+			c.Debugf("Git mirror for %s/%s is being cloned, bypassing", user, repo)
+		}
+		return false
+	}
+	if repoDir == "" {
+		c.Warnf("Git mirror for %s/%s unavailable: %v", user, repo, err)
+		return false
+	}
+	if err != nil {
+		// 刷新失败时使用已有的镜像
+		c.Warnf("Failed to refresh git mirror for %s/%s, serving existing mirror: %v", user, repo, err)
+	}
+
+	protocol := c.Request.Header.Get("Git-Protocol")
+	var (
+		out         io.ReadCloser
+		contentType string
+	)
+	if advertise {
+		out, err = gitMirrors.AdvertiseRefs(ctx, repoDir, protocol)
+		contentType = "application/x-git-upload-pack-advertisement"
+	} else {
+		if c.Request.Header.Get("Content-Encoding") == "gzip" {
+			gz, gzErr := gzip.NewReader(body)
+			if gzErr != nil {
+				HandleError(c, fmt.Sprintf("Failed to decompress request body: %v", gzErr))
+				return true
+			}
+			defer gz.Close()
+			body = gz
+		}
+		out, err = gitMirrors.UploadPack(ctx, repoDir, protocol, body)
+		contentType = "application/x-git-upload-pack-result"
+	}
+	if err != nil {
+		c.Warnf("Git mirror for %s/%s failed, bypassing: %v", user, repo, err)
+		return false
+	}
+
+	var bodyReader io.ReadCloser = out
+	// protocol v0/v1 的引用通告需要加上服务声明, v2 由 upload-pack 自行输出能力通告
+	if advertise && !strings.Contains(protocol, "version=2") {
+		bodyReader = readCloser{
+			Reader: io.MultiReader(strings.NewReader("001e# service=git-upload-pack\n0000"), out),
+			Closer: out,
+		}
+	}
+	if cfg.RateLimit.BandwidthLimit.Enabled {
+		bodyReader = limitreader.NewRateLimitedReader(bodyReader, bandwidthLimit, int(bandwidthBurst), ctx)
+	}
+
+	c.SetHeader("Content-Type", contentType)
+	// 响应由本地 upload-pack 按每个请求生成, 不应用 clone 的缓存头策略
+	c.SetHeader("Cache-Control", "no-store, no-cache, must-revalidate")
+	c.SetHeader("X-GHProxy-Cache", "MIRROR")
+	setCorsHeader(c, cfg)
+	c.Status(http.StatusOK)
+	c.SetBodyStream(bodyReader, -1)
+	return true
+}
+
+// readCloser 组合读取器与关闭器
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
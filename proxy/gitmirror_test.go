@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"ghproxy/config"
+	"reflect"
+	"testing"
+)
+
+func TestGitMirrorOptions(t *testing.T) {
+	pin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	withOutbound := func(url string, routes ...config.OutboundRouteConfig) *config.Config {
+		cfg := config.DefaultConfig()
+		cfg.Outbound.Enabled = url != ""
+		cfg.Outbound.Url = url
+		cfg.Outbound.Proxies = map[string]string{"corp": "http://proxy.corp:3128", "chain": "socks5://a:1080,socks5://b:1080"}
+		cfg.Outbound.Routes = routes
+		return cfg
+	}
+	withTLS := func(rules ...config.UpstreamTLSConfig) *config.Config {
+		cfg := config.DefaultConfig()
+		cfg.Httpc.TLS = rules
+		return cfg
+	}
+
+	for _, tc := range []struct {
+		name      string
+		cfg       *config.Config
+		wantProxy string
+		wantGit   []string
+	}{
+		{"Default", config.DefaultConfig(), "", nil},
+		{"Socks5", withOutbound("socks5://u:p@127.0.0.1:1080"), "socks5h://u:p@127.0.0.1:1080", nil},
+		{"HTTP", withOutbound("http://127.0.0.1:7890"), "http://127.0.0.1:7890", nil},
+		{"RouteDirect", withOutbound("socks5://127.0.0.1:1080", config.OutboundRouteConfig{Matchers: []string{"clone"}, Via: "direct"}), "", nil},
+		{"RouteProxy", withOutbound("", config.OutboundRouteConfig{Hosts: []string{"github.com"}, Via: "corp"}), "http://proxy.corp:3128", nil},
+		{"RouteOtherMatcher", withOutbound("http://127.0.0.1:7890", config.OutboundRouteConfig{Matchers: []string{"docker"}, Via: "direct"}), "http://127.0.0.1:7890", nil},
+		{"TLSRule", withTLS(
+			config.UpstreamTLSConfig{Hosts: []string{"ghe.example.com"}, CAFiles: []string{"/ca.pem"}},
+			config.UpstreamTLSConfig{Hosts: []string{"GitHub.com"}, CertFile: "/c.pem", KeyFile: "/c.key", MinVersion: "1.3", SPKIPins: []string{"sha256/" + pin, pin}},
+		), "", []string{"http.sslCert=/c.pem", "http.sslKey=/c.key", "http.sslVersion=tlsv1.3", "http.pinnedPubkey=sha256//" + pin + ";sha256//" + pin}},
+	} {
+		gitProxy, gitConfig, err := gitMirrorOptions(tc.cfg)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		got := ""
+		if gitProxy != nil {
+			got = gitProxy()
+		}
+		if got != tc.wantProxy {
+			t.Errorf("%s: http.proxy = %q, want %q", tc.name, got, tc.wantProxy)
+		}
+		if !reflect.DeepEqual(gitConfig, tc.wantGit) {
+			t.Errorf("%s: git config = %q, want %q", tc.name, gitConfig, tc.wantGit)
+		}
+	}
+
+	dns := config.DefaultConfig()
+	dns.DNS.Enabled = true
+	source := config.DefaultConfig()
+	source.Httpc.Source.Enabled = true
+	httpsProxy := withOutbound("https://proxy.corp:8443")
+	httpsProxy.Httpc.TLS = []config.UpstreamTLSConfig{{Hosts: []string{"*.corp"}, CAFiles: []string{"/ca.pem"}}}
+	for name, cfg := range map[string]*config.Config{
+		"dns":         dns,
+		"source":      source,
+		"chain":       withOutbound("socks5://a:1080,socks5://b:1080"),
+		"route chain": withOutbound("", config.OutboundRouteConfig{Matchers: []string{"clone"}, Via: "chain"}),
+		"caFiles":     withTLS(config.UpstreamTLSConfig{Hosts: []string{"*"}, CAFiles: []string{"/ca.pem"}}),
+		"https proxy": httpsProxy,
+	} {
+		if _, _, err := gitMirrorOptions(cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -12,6 +12,9 @@ import (
 )
 
 func GitReq(ctx context.Context, c *touka.Context, u string, cfg *config.Config, mode string) {
+	ctx = withUpstreamMatcher(ctx, "clone")
+	ctx = withSourceClient(ctx, c.ClientIP())
+	ctx, credentialUsed := withServerCredentialFlag(ctx)
 
 	var (
 		resp *http.Response
@@ -23,6 +26,11 @@ func GitReq(ctx context.Context, c *touka.Context, u string, cfg *config.Config,
 		return
 	}
 
+	// mirror 模式下优先使用本地镜像, 无法使用时按 bypass 模式请求上游
+	if cfg.GitClone.Mode == "mirror" && serveGitMirror(c, u, cfg, reqBodyReader) {
+		return
+	}
+
 	if cfg.GitClone.Mode == "cache" {
 		userPath, repoPath, remainingPath, queryParams, err := extractParts(u)
 		if err != nil {
@@ -57,6 +65,7 @@ func GitReq(ctx context.Context, c *touka.Context, u string, cfg *config.Config,
 			HandleError(c, fmt.Sprintf("Failed to send request: %v", err))
 			return
 		}
+		wrapStallBody(c.GetLogger(), gitTimeouts.bodyStall, "clone", resp)
 		defer resp.Body.Close()
 	} else {
 		rb := client.NewRequestBuilder(c.Request.Method, u)
@@ -75,9 +84,10 @@ func GitReq(ctx context.Context, c *touka.Context, u string, cfg *config.Config,
 
 		resp, err = client.Do(req)
 		if err != nil {
-			HandleError(c, fmt.Sprintf("Failed to send request: %v", err))
+			handleRequestError(c, "Failed to send request", err)
 			return
 		}
+		wrapStallBody(c.GetLogger(), clientTimeouts.bodyStall, "clone", resp)
 		defer resp.Body.Close()
 	}
 
@@ -108,23 +118,16 @@ func GitReq(ctx context.Context, c *touka.Context, u string, cfg *config.Config,
 		resp.Header.Del(header)
 	}
 
-	switch cfg.Server.Cors {
-	case "*":
-		c.Header("Access-Control-Allow-Origin", "*")
-	case "":
-		c.Header("Access-Control-Allow-Origin", "*")
-	case "nil":
-		c.Header("Access-Control-Allow-Origin", "")
-	default:
-		c.Header("Access-Control-Allow-Origin", cfg.Server.Cors)
-	}
+	setCorsHeader(c, cfg)
 
-	c.Status(resp.StatusCode)
-	if cfg.GitClone.Mode == "cache" {
+	// 附加了服务端凭据的响应可能是私有内容, 不应用缓存头策略并禁止缓存
+	applied := !credentialUsed.Load() && applyCachePolicy(c, cfg, "clone", false, resp.StatusCode)
+	if !applied && (cfg.GitClone.Mode == "cache" || credentialUsed.Load()) {
 		c.SetHeader("Cache-Control", "no-store, no-cache, must-revalidate")
 		c.SetHeader("Pragma", "no-cache")
 		c.SetHeader("Expires", "0")
 	}
+	c.Status(resp.StatusCode)
 
 	bodyReader := resp.Body
 
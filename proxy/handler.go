@@ -59,9 +59,7 @@ func NoRouteHandler(cfg *config.Config) touka.HandlerFunc {
 
 		// 处理blob/raw路径
 		if matcher == "blob" {
-			rawPath = rawPath[18:]
-			rawPath = "https://raw.githubusercontent.com" + rawPath
-			rawPath = strings.Replace(rawPath, "/blob/", "/", 1)
+			rawPath = blobToRaw(rawPath)
 			matcher = "raw"
 		}
 
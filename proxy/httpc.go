@@ -18,11 +18,65 @@ var (
 )
 
 func InitReq(cfg *config.Config) (*httpc.Client, error) {
-	client := initHTTPClient(cfg)
+	var err error
+	if clientTimeouts, err = parseTimeouts("timeouts", cfg.Httpc.Timeouts); err != nil {
+		return nil, err
+	}
+	if gitTimeouts, err = parseTimeouts("gitTimeouts", cfg.Httpc.GitTimeouts); err != nil {
+		return nil, err
+	}
+	resumeAttempts = 0
+	if cfg.Httpc.Resume.Enabled {
+		resumeAttempts = cfg.Httpc.Resume.MaxAttempts
+	}
+	if err = initSegments(cfg.Httpc.Segmented); err != nil {
+		return nil, err
+	}
+	if err := initResolver(cfg); err != nil {
+		return nil, err
+	}
+	if err := initSources(cfg); err != nil {
+		return nil, err
+	}
+	if err := initOutboundPool(cfg); err != nil {
+		return nil, err
+	}
+	if err := initGithubTokens(cfg); err != nil {
+		return nil, err
+	}
+	if err := initInstances(cfg); err != nil {
+		return nil, err
+	}
+	if err := initUpstreamTLS(cfg); err != nil {
+		return nil, err
+	}
+	client, err := initHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
 	if cfg.GitClone.Mode == "cache" {
-		initGitHTTPClient(cfg)
+		if err := initGitHTTPClient(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.GitClone.Mode == "mirror" {
+		if err := initGitMirror(cfg); err != nil {
+			return nil, err
+		}
 	}
-	err := SetGlobalRateLimit(cfg)
+	err = SetGlobalRateLimit(cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = InitCache(cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = initFlights(cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = initMirrors(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +84,7 @@ func InitReq(cfg *config.Config) (*httpc.Client, error) {
 
 }
 
-func initHTTPClient(cfg *config.Config) *httpc.Client {
+func initHTTPClient(cfg *config.Config) (*httpc.Client, error) {
 	var proTolcols = new(http.Protocols)
 	proTolcols.SetHTTP1(true)
 	proTolcols.SetHTTP2(true)
@@ -57,23 +111,60 @@ func initHTTPClient(cfg *config.Config) *httpc.Client {
 		panic("unknown httpc mode: " + cfg.Httpc.Mode)
 	}
 
+	setTransportResolver(tr)
+	setTransportSource(tr)
+	// 路由规则的 Transport 基于未设置默认出站代理的 Transport 复制
+	router, err := newOutboundRouter(cfg.Outbound, tr)
+	if err != nil {
+		return nil, err
+	}
 	if cfg.Outbound.Enabled {
 		initTransport(cfg, tr)
 	}
+	applyTransportTimeouts(tr, clientTimeouts)
+	if router != nil {
+		for _, t := range router.transports() {
+			applyTransportTimeouts(t, clientTimeouts)
+		}
+	}
+	opts := []httpc.Option{httpc.WithTransport(tr)}
 	if cfg.Server.Debug {
-		client = httpc.New(
-			httpc.WithTransport(tr),
-			httpc.WithDumpLog(),
-		)
-	} else {
-		client = httpc.New(
-			httpc.WithTransport(tr),
-		)
+		opts = append(opts, httpc.WithDumpLog())
+	}
+	hostBreakers = nil
+	if cfg.Httpc.Breaker.Enabled {
+		group, err := newBreakerGroup(cfg.Httpc.Breaker)
+		if err != nil {
+			return nil, err
+		}
+		hostBreakers = group
+		opts = append(opts, httpc.WithMiddleware(breakerMiddleware(group)))
 	}
-	return client
+	if cfg.Httpc.Retry.Enabled {
+		policy, err := newRetryPolicy(cfg.Httpc.Retry)
+		if err != nil {
+			return nil, err
+		}
+		// 由 retryPolicy 接管重试, 关闭 httpc 自带的重试
+		opts = append(opts, httpc.WithRetryOptions(httpc.RetryOptions{}), httpc.WithMiddleware(policy.middleware))
+	}
+	if githubTokens != nil {
+		opts = append(opts, httpc.WithMiddleware(githubTokens.middleware))
+	}
+	if instanceHasToken() {
+		opts = append(opts, httpc.WithMiddleware(instanceCredentials))
+	}
+	if router != nil {
+		opts = append(opts, httpc.WithMiddleware(router.middleware))
+	}
+	if needUpstreamMiddleware() {
+		opts = append(opts, httpc.WithMiddleware(upstreamMiddleware))
+	}
+	client = httpc.New(opts...)
+	return client, nil
 }
 
-func initGitHTTPClient(cfg *config.Config) {
+func initGitHTTPClient(cfg *config.Config) error {
 	switch cfg.Httpc.Mode {
 	case "auto", "":
 		gittr = &http.Transport{
@@ -93,9 +184,21 @@ func initGitHTTPClient(cfg *config.Config) {
 		panic("unknown httpc mode: " + cfg.Httpc.Mode)
 	}
 
+	setTransportResolver(gittr)
+	setTransportSource(gittr)
+	router, err := newOutboundRouter(cfg.Outbound, gittr)
+	if err != nil {
+		return err
+	}
 	if cfg.Outbound.Enabled {
 		initTransport(cfg, gittr)
 	}
+	applyTransportTimeouts(gittr, gitTimeouts)
+	if router != nil {
+		for _, t := range router.transports() {
+			applyTransportTimeouts(t, gitTimeouts)
+		}
+	}
 
 	var opts []httpc.Option // 使用切片来收集选项
 	opts = append(opts, httpc.WithTransport(gittr))
@@ -113,6 +216,16 @@ func initGitHTTPClient(cfg *config.Config) {
 	if cfg.Server.Debug {
 		opts = append(opts, httpc.WithDumpLog())
 	}
+	if instanceHasToken() {
+		opts = append(opts, httpc.WithMiddleware(instanceCredentials))
+	}
+	if router != nil {
+		opts = append(opts, httpc.WithMiddleware(router.middleware))
+	}
+	if needUpstreamMiddleware() {
+		opts = append(opts, httpc.WithMiddleware(upstreamMiddleware))
+	}
 
 	gitclient = httpc.New(opts...)
+	return nil
 }
@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"ghproxy/config"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/WJQSERVER-STUDIO/httpc"
+)
+
+// instanceKind 为实例端点的类型
+type instanceKind int
+
+const (
+	instanceWeb instanceKind = iota
+	instanceRaw
+	instanceApi
+	instanceGist
+)
+
+// githubInstance 为一个已配置的 GitHub 实例 (如 GitHub Enterprise Server)
+// 各端点均为以 "/" 结尾的 https URL 前缀
+type githubInstance struct {
+	name  string
+	web   string
+	raw   string
+	api   string
+	gist  string
+	token string
+}
+
+// instancePrefix 为实例端点的 URL 前缀
+type instancePrefix struct {
+	prefix string
+	inst   *githubInstance
+	kind   instanceKind
+}
+
+var (
+	// instancePrefixes 按前缀长度降序排列, 嵌套的端点 (如 {web}/raw/) 优先匹配
+	instancePrefixes []instancePrefix
+	// instanceHosts 实例使用的主机 (不含端口, 小写) 到实例的映射, 用于附加凭据
+	instanceHosts map[string]*githubInstance
+)
+
+// builtinPrefixes 为 GitHub 本身的端点前缀, 实例不能与其重叠
+var builtinPrefixes = []string{githubPrefix, rawPrefix, gistPrefix, gistContentPrefix, apiPrefix}
+
+// matchInstance 查找 rawPath 所属的实例端点, 返回实例, 端点类型与前缀之后的路径
+func matchInstance(rawPath string) (*githubInstance, instanceKind, string) {
+	for _, p := range instancePrefixes {
+		if strings.HasPrefix(rawPath, p.prefix) {
+			return p.inst, p.kind, rawPath[len(p.prefix):]
+		}
+	}
+	return nil, 0, ""
+}
+
+// instancePrefixFor 解析实例端点的地址, 为空时使用 web 之下的默认路径
+func instancePrefixFor(name, endpoint, value, web, defaultPath string) (string, error) {
+	if value == "" {
+		return web + defaultPath + "/", nil
+	}
+	u, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return "", fmt.Errorf("instance %q: invalid %s url %q: %w", name, endpoint, value, err)
+	}
+	if u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("instance %q: %s url must be an https url without query, got %q", name, endpoint, value)
+	}
+	return "https://" + strings.ToLower(u.Host) + strings.TrimSuffix(u.Path, "/") + "/", nil
+}
+
+// newGithubInstance 解析实例配置
+func newGithubInstance(cfg config.InstanceConfig) (*githubInstance, error) {
+	inst := &githubInstance{name: cfg.Name, token: strings.TrimSpace(cfg.Token)}
+	if inst.name == "" {
+		inst.name = cfg.Web
+	}
+	if cfg.Web == "" {
+		return nil, fmt.Errorf("instance %q: web url is required", cfg.Name)
+	}
+	web, err := instancePrefixFor(cfg.Name, "web", cfg.Web, "", "")
+	if err != nil {
+		return nil, err
+	}
+	inst.web = web
+	web = strings.TrimSuffix(web, "/")
+	if inst.raw, err = instancePrefixFor(cfg.Name, "raw", cfg.Raw, web, "/raw"); err != nil {
+		return nil, err
+	}
+	if inst.api, err = instancePrefixFor(cfg.Name, "api", cfg.Api, web, "/api/v3"); err != nil {
+		return nil, err
+	}
+	if inst.gist, err = instancePrefixFor(cfg.Name, "gist", cfg.Gist, web, "/gist"); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// prefixes 返回实例的各端点前缀
+func (inst *githubInstance) prefixes() []instancePrefix {
+	return []instancePrefix{
+		{inst.web, inst, instanceWeb},
+		{inst.raw, inst, instanceRaw},
+		{inst.api, inst, instanceApi},
+		{inst.gist, inst, instanceGist},
+	}
+}
+
+// hosts 返回实例各端点使用的主机
+func (inst *githubInstance) hosts() []string {
+	seen := make(map[string]bool, 4)
+	var hosts []string
+	for _, p := range inst.prefixes() {
+		u, _ := url.Parse(p.prefix)
+		if h := u.Hostname(); !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// initInstances 根据 [[instances]] 配置注册 GitHub 实例, 并为实例的主机设置 CA 证书
+func initInstances(cfg *config.Config) error {
+	instancePrefixes = nil
+	instanceHosts = make(map[string]*githubInstance)
+	hostTLSConfigs = make(map[string]*tls.Config)
+	resetTLSVariants()
+
+	seen := make(map[string]*githubInstance)
+	caFiles := make(map[string]string) // 主机到 CA 文件, 检查共用主机的实例是否一致
+	for _, ic := range cfg.Instances {
+		inst, err := newGithubInstance(ic)
+		if err != nil {
+			return err
+		}
+		for _, p := range inst.prefixes() {
+			// 与 github.com 重叠的前缀会先被 GitHub 的规则匹配
+			for _, b := range builtinPrefixes {
+				if strings.HasPrefix(p.prefix, b) || strings.HasPrefix(b, p.prefix) {
+					return fmt.Errorf("instance %q: %s overlaps with %s", inst.name, p.prefix, b)
+				}
+			}
+			if other, dup := seen[p.prefix]; dup {
+				if other != inst {
+					return fmt.Errorf("instance %q: %s is already used by %q", inst.name, p.prefix, other.name)
+				}
+				continue
+			}
+			seen[p.prefix] = inst
+			instancePrefixes = append(instancePrefixes, p)
+		}
+
+		// 实例令牌附加于所有未携带凭据的请求, 未启用认证时任何人都可以使用
+		if inst.token != "" && !cfg.Auth.Enabled && !ic.Public {
+			return fmt.Errorf("instance %q: token is usable by anonymous users, enable [auth] or set public = true", inst.name)
+		}
+
+		var tlsConfig *tls.Config
+		if ic.CAFile != "" {
+			pool, err := loadCAFile(ic.CAFile)
+			if err != nil {
+				return fmt.Errorf("instance %q: %w", inst.name, err)
+			}
+			tlsConfig = &tls.Config{RootCAs: pool}
+		}
+		for _, host := range inst.hosts() {
+			if other, ok := instanceHosts[host]; ok && other != inst {
+				if other.token != inst.token || caFiles[host] != ic.CAFile {
+					return fmt.Errorf("instance %q: host %s is shared with %q but has different token or caFile", inst.name, host, other.name)
+				}
+				continue
+			}
+			instanceHosts[host] = inst
+			caFiles[host] = ic.CAFile
+			if tlsConfig != nil {
+				hostTLSConfigs[host] = tlsConfig
+			}
+		}
+		log.Printf("GitHub instance %q: web=%s raw=%s api=%s gist=%s", inst.name, inst.web, inst.raw, inst.api, inst.gist)
+	}
+	sort.SliceStable(instancePrefixes, func(i, j int) bool {
+		return len(instancePrefixes[i].prefix) > len(instancePrefixes[j].prefix)
+	})
+	return nil
+}
+
+// instanceHasToken 判断是否有实例配置了令牌
+func instanceHasToken() bool {
+	for _, inst := range instanceHosts {
+		if inst.token != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceCredentials 为发往实例且未携带客户端凭据的请求附加该实例的令牌
+// 须位于调试日志之内, 令牌只出现在发往上游的请求副本中; 附加了令牌的响应不写入缓存, 也不参与合并下载
+func instanceCredentials(next http.RoundTripper) http.RoundTripper {
+	return httpc.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		inst := instanceHosts[strings.ToLower(req.URL.Hostname())]
+		if inst == nil || inst.token == "" || req.Header.Get("Authorization") != "" {
+			return next.RoundTrip(req)
+		}
+		markServerCredential(req.Context())
+		r := req.Clone(req.Context())
+		if upstreamMatcher(req.Context()) == "clone" {
+			// git 的 HTTP 端点只接受 Basic 认证
+			r.SetBasicAuth("x-access-token", inst.token)
+		} else {
+			r.Header.Set("Authorization", "token "+inst.token)
+		}
+		resp, err := next.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		resp.Request = req
+		for _, h := range tokenScopeHeaders {
+			resp.Header.Del(h)
+		}
+		return resp, nil
+	})
+}
+
+// InstanceRoutes 为实例各端点不含协议的主机与路径, 如 "ghe.example.com"
+type InstanceRoutes struct {
+	Web  string
+	Raw  string
+	Api  string
+	Gist string
+}
+
+// RoutableInstances 返回可以注册独立路由的实例
+// 端点互相嵌套 (如 GHES 未启用子域隔离时的 {web}/raw) 的实例无法注册路由, 由 NoRoute 经 Matcher 处理
+func RoutableInstances() []InstanceRoutes {
+	all := append([]string(nil), builtinPrefixes...)
+	for _, p := range instancePrefixes {
+		all = append(all, p.prefix)
+	}
+	nested := func(prefix string) bool {
+		for _, other := range all {
+			if other != prefix && (strings.HasPrefix(other, prefix) || strings.HasPrefix(prefix, other)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var routes []InstanceRoutes
+	done := make(map[*githubInstance]bool)
+	for _, p := range instancePrefixes {
+		inst := p.inst
+		if done[inst] {
+			continue
+		}
+		done[inst] = true
+		ok := true
+		distinct := make(map[string]bool, 4)
+		for _, ip := range inst.prefixes() {
+			distinct[ip.prefix] = true
+			if nested(ip.prefix) {
+				ok = false
+				break
+			}
+		}
+		if !ok || len(distinct) < 4 {
+			continue
+		}
+		trim := func(prefix string) string {
+			return strings.TrimSuffix(strings.TrimPrefix(prefix, "https://"), "/")
+		}
+		routes = append(routes, InstanceRoutes{Web: trim(inst.web), Raw: trim(inst.raw), Api: trim(inst.api), Gist: trim(inst.gist)})
+	}
+	return routes
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"context"
+	"encoding/pem"
+	"ghproxy/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WJQSERVER-STUDIO/httpc"
+)
+
+func TestInstances(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.ForceAllowApi = true
+	cfg.Instances = []config.InstanceConfig{
+		{Name: "corp", Web: "https://ghe.example.com/"},
+		{Name: "isolated", Web: "https://git.example.org", Raw: "https://raw.git.example.org", Api: "https://api.git.example.org", Gist: "https://gist.git.example.org"},
+	}
+	if err := initInstances(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer initInstances(config.DefaultConfig())
+
+	for _, tc := range []struct {
+		path, user, repo, matcher string
+	}{
+		{"https://ghe.example.com/u/r/releases/download/v1/a.tgz", "u", "r", "releases"},
+		{"https://ghe.example.com/u/r/blob/main/a.sh", "u", "r", "blob"},
+		{"https://ghe.example.com/raw/u/r/main/a.sh", "u", "r", "raw"},
+		{"https://ghe.example.com/api/v3/repos/u/r/releases", "u", "r", "api"},
+		{"https://ghe.example.com/gist/u/abc", "u", "", "gist"},
+		{"https://raw.git.example.org/u/r/main/a.sh", "u", "r", "raw"},
+		{"https://git.example.org/u/r/info/refs", "u", "r", "clone"},
+	} {
+		user, repo, matcher, err := Matcher(tc.path, cfg)
+		if err != nil || user != tc.user || repo != tc.repo || matcher != tc.matcher {
+			t.Errorf("Matcher(%s) = %q %q %q %v", tc.path, user, repo, matcher, err)
+		}
+	}
+	if _, _, _, err := Matcher("https://other.example.com/u/r/blob/x", cfg); err == nil {
+		t.Error("unknown host should not match")
+	}
+
+	for in, want := range map[string]string{
+		"https://ghe.example.com/u/r/blob/main/a.sh": "https://ghe.example.com/raw/u/r/main/a.sh",
+		"https://git.example.org/u/r/blob/main/a.sh": "https://raw.git.example.org/u/r/main/a.sh",
+		"https://github.com/blob/r/blob/main/a.sh":   "https://raw.githubusercontent.com/blob/r/main/a.sh",
+	} {
+		if got := blobToRaw(in); got != want {
+			t.Errorf("blobToRaw(%s) = %s, want %s", in, got, want)
+		}
+	}
+
+	if ok, _ := EditorMatcher("https://ghe.example.com/raw/u/r/main/a.sh", cfg); !ok {
+		t.Error("instance links should be rewritten")
+	}
+	if ok, _ := EditorMatcher("https://ghe.example.com/api/v3/repos/u/r", cfg); ok {
+		t.Error("instance API links should only be rewritten with rewriteAPI")
+	}
+
+	routes := RoutableInstances()
+	if len(routes) != 1 || routes[0].Web != "git.example.org" || routes[0].Api != "api.git.example.org" {
+		t.Errorf("RoutableInstances() = %+v", routes)
+	}
+
+	for _, bad := range []config.InstanceConfig{
+		{Name: "plain", Web: "http://ghe.example.com"},
+		{Name: "github", Web: "https://github.com/enterprise"},
+		{Name: "dup", Web: "https://ghe.example.com"},
+	} {
+		c := config.DefaultConfig()
+		c.Instances = append(cfg.Instances[:1:1], bad)
+		if err := initInstances(c); err == nil {
+			t.Errorf("instance %+v should be rejected", bad)
+		}
+	}
+}
+
+func TestInstanceTLSAndCredentials(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-OAuth-Scopes", "repo")
+		io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.Instances = []config.InstanceConfig{{Name: "corp", Web: srv.URL, CAFile: caFile, Token: "secret"}}
+	// 未启用认证时, 令牌须显式允许匿名使用
+	if err := initInstances(cfg); err == nil {
+		t.Fatal("instance token without auth or public should be rejected")
+	}
+	cfg.Auth.Enabled = true
+	if err := initInstances(cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Auth.Enabled = false
+	cfg.Instances[0].Public = true
+	if err := initInstances(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer initInstances(config.DefaultConfig())
+
+	hc := httpc.New(httpc.WithMiddleware(instanceCredentials), httpc.WithMiddleware(upstreamMiddleware))
+	get := func(auth string) string {
+		t.Helper()
+		ctx, credentialUsed := withServerCredentialFlag(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/raw/u/r/main/a", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		// 附加实例令牌的请求不参与合并下载, 其响应被标记为不可缓存
+		if serverCredentialMayApply(req) != (auth == "") {
+			t.Errorf("serverCredentialMayApply = %t with Authorization %q", !(auth == ""), auth)
+		}
+		defer func() {
+			if credentialUsed.Load() != (auth == "") {
+				t.Errorf("credential marked = %t with Authorization %q", credentialUsed.Load(), auth)
+			}
+		}()
+		resp, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.Header.Get("X-OAuth-Scopes") != "" && auth == "" {
+			t.Error("token scopes leaked to response")
+		}
+		if resp.Request.Header.Get("Authorization") != auth {
+			t.Error("token leaked into the response request")
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	// 实例的 CA 证书生效, 且附加了实例的令牌
+	if got := get(""); got != "token secret" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := get("token client"); got != "token client" {
+		t.Errorf("client credentials replaced: %q", got)
+	}
+
+	// 其他主机不使用实例的 CA 证书
+	req, _ := http.NewRequest(http.MethodGet, "https://localhost:"+srv.URL[len("https://127.0.0.1:"):], nil)
+	if resp, err := hc.Do(req); err == nil {
+		resp.Body.Close()
+		t.Error("untrusted upstream should fail verification")
+	}
+}
@@ -45,123 +45,162 @@ func Matcher(rawPath string, cfg *config.Config) (string, string, string, *GHPro
 
 	// 匹配 "https://github.com/"
 	if strings.HasPrefix(rawPath, githubPrefix) {
-		pathAfterDomain := rawPath[githubPrefixLen:]
+		return matchWebPath(rawPath[githubPrefixLen:])
+	}
 
-		// 解析 user
-		i := strings.IndexByte(pathAfterDomain, '/')
-		if i <= 0 {
-			return "", "", "", NewErrorWithStatusLookup(400, "malformed github path: missing user")
-		}
-		user := pathAfterDomain[:i]
-		pathAfterUser := pathAfterDomain[i+1:]
+	// 匹配 "https://raw.githubusercontent.com/"
+	if strings.HasPrefix(rawPath, rawPrefix) {
+		return matchRawPath(rawPath[rawPrefixLen:])
+	}
 
-		// 解析 repo
-		i = strings.IndexByte(pathAfterUser, '/')
-		if i <= 0 {
-			return "", "", "", NewErrorWithStatusLookup(400, "malformed github path: missing action")
-		}
-		repo := pathAfterUser[:i]
-		pathAfterRepo := pathAfterUser[i+1:]
+	// 匹配 "https://gist.github.com/" 或 "https://gist.githubusercontent.com/"
+	if strings.HasPrefix(rawPath, gistPrefix) {
+		return matchGistPath(rawPath[gistPrefixLen:])
+	}
+	if strings.HasPrefix(rawPath, gistContentPrefix) {
+		return matchGistPath(rawPath[gistContentPrefixLen:])
+	}
+
+	// 匹配 "https://api.github.com/"
+	if strings.HasPrefix(rawPath, apiPrefix) {
+		return matchApiPath(rawPath[apiPrefixLen:], cfg)
+	}
 
-		if len(pathAfterRepo) == 0 {
-			return "", "", "", NewErrorWithStatusLookup(400, "malformed github path: missing action")
+	// 匹配已配置的其他 GitHub 实例
+	if inst, kind, rest := matchInstance(rawPath); inst != nil {
+		switch kind {
+		case instanceWeb:
+			return matchWebPath(rest)
+		case instanceRaw:
+			return matchRawPath(rest)
+		case instanceGist:
+			return matchGistPath(rest)
+		case instanceApi:
+			return matchApiPath(rest, cfg)
 		}
+	}
+
+	return "", "", "", NewErrorWithStatusLookup(404, "no matcher found for the given path")
+}
+
+// matchWebPath 解析 web 站点 (如 github.com) 域名之后的路径
+func matchWebPath(pathAfterDomain string) (string, string, string, *GHProxyErrors) {
+	// 解析 user
+	i := strings.IndexByte(pathAfterDomain, '/')
+	if i <= 0 {
+		return "", "", "", NewErrorWithStatusLookup(400, "malformed github path: missing user")
+	}
+	user := pathAfterDomain[:i]
+	pathAfterUser := pathAfterDomain[i+1:]
 
-		// 优先处理所有 "releases" 相关的下载路径
-		if strings.HasPrefix(pathAfterRepo, "releases/") {
-			// 情况 A: "releases/download/..."
-			if strings.HasPrefix(pathAfterRepo, "releases/download/") {
+	// 解析 repo
+	i = strings.IndexByte(pathAfterUser, '/')
+	if i <= 0 {
+		return "", "", "", NewErrorWithStatusLookup(400, "malformed github path: missing action")
+	}
+	repo := pathAfterUser[:i]
+	pathAfterRepo := pathAfterUser[i+1:]
+
+	if len(pathAfterRepo) == 0 {
+		return "", "", "", NewErrorWithStatusLookup(400, "malformed github path: missing action")
+	}
+
+	// 优先处理所有 "releases" 相关的下载路径
+	if strings.HasPrefix(pathAfterRepo, "releases/") {
+		// 情况 A: "releases/download/..."
+		if strings.HasPrefix(pathAfterRepo, "releases/download/") {
+			return user, repo, "releases", nil
+		}
+		// 情况 B: "releases/:tag/download/..."
+		pathAfterReleases := pathAfterRepo[len("releases/"):]
+		slashIndex := strings.IndexByte(pathAfterReleases, '/')
+		if slashIndex > 0 { // 确保tag不为空
+			pathAfterTag := pathAfterReleases[slashIndex+1:]
+			if strings.HasPrefix(pathAfterTag, "download/") {
 				return user, repo, "releases", nil
 			}
-			// 情况 B: "releases/:tag/download/..."
-			pathAfterReleases := pathAfterRepo[len("releases/"):]
-			slashIndex := strings.IndexByte(pathAfterReleases, '/')
-			if slashIndex > 0 { // 确保tag不为空
-				pathAfterTag := pathAfterReleases[slashIndex+1:]
-				if strings.HasPrefix(pathAfterTag, "download/") {
-					return user, repo, "releases", nil
-				}
-			}
-			// 如果不满足上述下载链接的结构, 则为网页浏览路径, 予以拒绝
-			return "", "", "", NewErrorWithStatusLookup(400, "unsupported releases page, only download links are allowed")
 		}
+		// 如果不满足上述下载链接的结构, 则为网页浏览路径, 予以拒绝
+		return "", "", "", NewErrorWithStatusLookup(400, "unsupported releases page, only download links are allowed")
+	}
 
-		// 检查 "archive/" 路径
-		if strings.HasPrefix(pathAfterRepo, "archive/") {
-			// 根据测试用例, archive路径的matcher也应为releases
-			return user, repo, "releases", nil
-		}
+	// 检查 "archive/" 路径
+	if strings.HasPrefix(pathAfterRepo, "archive/") {
+		// 根据测试用例, archive路径的matcher也应为releases
+		return user, repo, "releases", nil
+	}
 
-		// 如果不是下载路径, 则解析action并进行分类
-		i = strings.IndexByte(pathAfterRepo, '/')
-		action := pathAfterRepo
-		if i != -1 {
-			action = pathAfterRepo[:i]
-		}
+	// 如果不是下载路径, 则解析action并进行分类
+	i = strings.IndexByte(pathAfterRepo, '/')
+	action := pathAfterRepo
+	if i != -1 {
+		action = pathAfterRepo[:i]
+	}
 
-		var matcher string
-		switch action {
-		case "blob":
-			matcher = "blob"
-		case "raw":
-			matcher = "raw"
-		case "info", "git-upload-pack":
-			matcher = "clone"
-		default:
-			return "", "", "", NewErrorWithStatusLookup(400, fmt.Sprintf("unsupported github action: %s", action))
-		}
-		return user, repo, matcher, nil
+	var matcher string
+	switch action {
+	case "blob":
+		matcher = "blob"
+	case "raw":
+		matcher = "raw"
+	case "info", "git-upload-pack":
+		matcher = "clone"
+	default:
+		return "", "", "", NewErrorWithStatusLookup(400, fmt.Sprintf("unsupported github action: %s", action))
 	}
+	return user, repo, matcher, nil
+}
 
-	// 匹配 "https://raw.githubusercontent.com/"
-	if strings.HasPrefix(rawPath, rawPrefix) {
-		remaining := rawPath[rawPrefixLen:]
-		parts := strings.SplitN(remaining, "/", 3)
-		if len(parts) < 3 {
-			return "", "", "", NewErrorWithStatusLookup(400, "malformed raw url: path too short")
-		}
-		return parts[0], parts[1], "raw", nil
+// matchRawPath 解析 raw 站点域名之后的路径
+func matchRawPath(remaining string) (string, string, string, *GHProxyErrors) {
+	parts := strings.SplitN(remaining, "/", 3)
+	if len(parts) < 3 {
+		return "", "", "", NewErrorWithStatusLookup(400, "malformed raw url: path too short")
 	}
+	return parts[0], parts[1], "raw", nil
+}
 
-	// 匹配 "https://gist.github.com/" 或 "https://gist.githubusercontent.com/"
-	isGist := strings.HasPrefix(rawPath, gistPrefix)
-	if isGist || strings.HasPrefix(rawPath, gistContentPrefix) {
-		var remaining string
-		if isGist {
-			remaining = rawPath[gistPrefixLen:]
-		} else {
-			remaining = rawPath[gistContentPrefixLen:]
-		}
-		parts := strings.SplitN(remaining, "/", 2)
-		if len(parts) == 0 || parts[0] == "" {
-			return "", "", "", NewErrorWithStatusLookup(400, "malformed gist url: missing user")
-		}
-		return parts[0], "", "gist", nil
+// matchGistPath 解析 gist 站点域名之后的路径
+func matchGistPath(remaining string) (string, string, string, *GHProxyErrors) {
+	parts := strings.SplitN(remaining, "/", 2)
+	if len(parts) == 0 || parts[0] == "" {
+		return "", "", "", NewErrorWithStatusLookup(400, "malformed gist url: missing user")
 	}
+	return parts[0], "", "gist", nil
+}
 
-	// 匹配 "https://api.github.com/"
-	if strings.HasPrefix(rawPath, apiPrefix) {
-		if !cfg.Auth.ForceAllowApi && (cfg.Auth.Method != "header" || !cfg.Auth.Enabled) {
-			return "", "", "", NewErrorWithStatusLookup(403, "API proxy requires header authentication")
+// matchApiPath 解析 API 根路径之后的路径
+func matchApiPath(remaining string, cfg *config.Config) (string, string, string, *GHProxyErrors) {
+	if !cfg.Auth.ForceAllowApi && (cfg.Auth.Method != "header" || !cfg.Auth.Enabled) {
+		return "", "", "", NewErrorWithStatusLookup(403, "API proxy requires header authentication")
+	}
+	var user, repo string
+	if strings.HasPrefix(remaining, "repos/") {
+		parts := strings.SplitN(remaining[6:], "/", 3)
+		if len(parts) >= 2 {
+			user = parts[0]
+			repo = parts[1]
 		}
-		remaining := rawPath[apiPrefixLen:]
-		var user, repo string
-		if strings.HasPrefix(remaining, "repos/") {
-			parts := strings.SplitN(remaining[6:], "/", 3)
-			if len(parts) >= 2 {
-				user = parts[0]
-				repo = parts[1]
-			}
-		} else if strings.HasPrefix(remaining, "users/") {
-			parts := strings.SplitN(remaining[6:], "/", 2)
-			if len(parts) >= 1 {
-				user = parts[0]
-			}
+	} else if strings.HasPrefix(remaining, "users/") {
+		parts := strings.SplitN(remaining[6:], "/", 2)
+		if len(parts) >= 1 {
+			user = parts[0]
 		}
-		return user, repo, "api", nil
 	}
+	return user, repo, "api", nil
+}
 
-	return "", "", "", NewErrorWithStatusLookup(404, "no matcher found for the given path")
+// blobToRaw 将 web 站点的 blob 链接转换为对应 raw 站点的链接
+func blobToRaw(u string) string {
+	rawBase, rest := rawPrefix, ""
+	if strings.HasPrefix(u, githubPrefix) {
+		rest = u[githubPrefixLen:]
+	} else if inst, kind, r := matchInstance(u); inst != nil && kind == instanceWeb {
+		rawBase, rest = inst.raw, r
+	} else {
+		return u
+	}
+	return rawBase + strings.Replace(rest, "/blob/", "/", 1)
 }
 
 var (
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"ghproxy/config"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// mirrorHeader 为告知客户端响应由哪个镜像提供的响应头
+const mirrorHeader = "X-GHProxy-Mirror"
+
+// mirrorTarget 为回退链中的一项, url 为空表示 GitHub 本身
+type mirrorTarget struct {
+	name string
+	url  string
+}
+
+var (
+	mirrorChains           map[string][]mirrorTarget
+	mirrorFallbackStatuses map[int]struct{}
+)
+
+// initMirrors 解析各 matcher 的镜像回退链
+func initMirrors(cfg *config.Config) error {
+	mirrorChains = nil
+	mirrorFallbackStatuses = nil
+	if !cfg.Mirrors.Enabled {
+		return nil
+	}
+	chains := make(map[string][]mirrorTarget)
+	for matcher, entries := range map[string][]config.MirrorConfig{
+		"releases": cfg.Mirrors.Releases,
+		"raw":      cfg.Mirrors.Raw,
+		"gist":     cfg.Mirrors.Gist,
+	} {
+		seen := make(map[string]struct{}, len(entries))
+		for i, m := range entries {
+			if m.Name == "" {
+				return fmt.Errorf("mirrors.%s[%d]: name is empty", matcher, i)
+			}
+			if _, dup := seen[m.Name]; dup {
+				return fmt.Errorf("mirrors.%s: duplicate mirror %q", matcher, m.Name)
+			}
+			seen[m.Name] = struct{}{}
+			if m.Url != "" {
+				// 占位符替换为示例值后校验
+				sample := strings.NewReplacer("{user}", "u", "{repo}", "r", "{rest}", "x", "{path}", "u/r/x").Replace(m.Url)
+				parsed, err := url.Parse(sample)
+				if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+					return fmt.Errorf("mirrors.%s: invalid url %q for %s", matcher, m.Url, m.Name)
+				}
+			}
+			chains[matcher] = append(chains[matcher], mirrorTarget{name: m.Name, url: m.Url})
+		}
+	}
+	mirrorChains = chains
+	mirrorFallbackStatuses = make(map[int]struct{}, len(cfg.Mirrors.FallbackStatuses))
+	for _, status := range cfg.Mirrors.FallbackStatuses {
+		mirrorFallbackStatuses[status] = struct{}{}
+	}
+	return nil
+}
+
+// mirrorChainFor 返回请求使用的回退链, 只作用于 GitHub 上的地址
+// 镜像返回的跳转地址不再经过回退链
+func mirrorChainFor(matcher string, u string) []mirrorTarget {
+	chain := mirrorChains[matcher]
+	if len(chain) == 0 {
+		return nil
+	}
+	for _, prefix := range []string{githubPrefix, rawPrefix, gistPrefix, gistContentPrefix} {
+		if strings.HasPrefix(u, prefix) {
+			return chain
+		}
+	}
+	return nil
+}
+
+// mirrorURL 将 GitHub 地址映射到镜像
+// base 不含占位符时直接拼接 GitHub 路径, 否则替换 {user} {repo} {rest} {path}
+func mirrorURL(base string, u *url.URL) string {
+	p := u.EscapedPath()
+	var out string
+	if !strings.Contains(base, "{") {
+		out = strings.TrimSuffix(base, "/") + p
+	} else {
+		trimmed := strings.TrimPrefix(p, "/")
+		parts := strings.SplitN(trimmed, "/", 3)
+		for len(parts) < 3 {
+			parts = append(parts, "")
+		}
+		out = strings.NewReplacer("{user}", parts[0], "{repo}", parts[1], "{rest}", parts[2], "{path}", trimmed).Replace(base)
+	}
+	if u.RawQuery != "" {
+		if strings.Contains(out, "?") {
+			out += "&" + u.RawQuery
+		} else {
+			out += "?" + u.RawQuery
+		}
+	}
+	return out
+}
+
+// fetchFromMirrors 按回退链依次请求, 返回响应与提供响应的镜像名称
+// 请求失败或返回 fallbackStatuses 中的状态码时尝试下一项, 最后一项的结果直接返回
+// 带请求体的请求无法重放, 只请求 GitHub
+func fetchFromMirrors(req *http.Request, chain []mirrorTarget) (*http.Response, string, error) {
+	if !retryableRequest(req) {
+		resp, err := client.Do(req)
+		return resp, "github", err
+	}
+	for i, m := range chain {
+		r := req
+		if m.url != "" {
+			target, err := url.Parse(mirrorURL(m.url, req.URL))
+			if err != nil {
+				return nil, m.name, err
+			}
+			r = req.Clone(req.Context())
+			r.URL = target
+			r.Host = ""
+			// GitHub 的凭据不发送给镜像
+			r.Header.Del("Authorization")
+		}
+		resp, err := client.Do(r)
+		if i == len(chain)-1 {
+			return resp, m.name, err
+		}
+		if err != nil {
+			if req.Context().Err() != nil {
+				return nil, m.name, err
+			}
+			continue
+		}
+		if _, fallback := mirrorFallbackStatuses[resp.StatusCode]; !fallback {
+			return resp, m.name, nil
+		}
+		// 读取少量剩余内容以便复用连接
+		io.CopyN(io.Discard, resp.Body, 4096)
+		resp.Body.Close()
+	}
+	return nil, "", errors.New("empty mirror chain")
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"ghproxy/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/WJQSERVER-STUDIO/httpc"
+)
+
+func TestMirrorURL(t *testing.T) {
+	u, _ := url.Parse("https://raw.githubusercontent.com/owner/repo/main/dir/a%20b.sh?token=1")
+	tests := []struct {
+		base string
+		want string
+	}{
+		{"https://mirror.example.com/", "https://mirror.example.com/owner/repo/main/dir/a%20b.sh?token=1"},
+		{"https://gitea.example.com/{user}/{repo}/raw/{rest}", "https://gitea.example.com/owner/repo/raw/main/dir/a%20b.sh?token=1"},
+		{"https://cdn.example.com/gh?p={path}", "https://cdn.example.com/gh?p=owner/repo/main/dir/a%20b.sh&token=1"},
+	}
+	for _, tt := range tests {
+		if got := mirrorURL(tt.base, u); got != tt.want {
+			t.Errorf("mirrorURL(%s) = %s, want %s", tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestFetchFromMirrors(t *testing.T) {
+	var paths []string
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, "missing"+r.URL.Path)
+		http.NotFound(w, r)
+	}))
+	defer missing.Close()
+	gitea := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, "gitea"+r.URL.Path)
+		if r.Header.Get("Authorization") != "" {
+			t.Error("Authorization should not be sent to mirrors")
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer gitea.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Mirrors.Enabled = true
+	cfg.Mirrors.Releases = []config.MirrorConfig{
+		{Name: "missing", Url: missing.URL},
+		{Name: "gitea", Url: gitea.URL},
+		{Name: "github"},
+	}
+	if err := initMirrors(cfg); err != nil {
+		t.Fatal(err)
+	}
+	oldClient := client
+	client = httpc.New()
+	defer func() {
+		mirrorChains = nil
+		client = oldClient
+	}()
+
+	u := "https://github.com/owner/repo/releases/download/v1/a.tar.gz"
+	chain := mirrorChainFor("releases", u)
+	if len(chain) != 3 {
+		t.Fatalf("chain = %v", chain)
+	}
+	if mirrorChainFor("raw", u) != nil || mirrorChainFor("releases", "https://objects.githubusercontent.com/x") != nil {
+		t.Fatal("chain should only apply to configured matchers and GitHub URLs")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	req.Header.Set("Authorization", "token secret")
+	resp, name, err := fetchFromMirrors(req, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if name != "gitea" || resp.StatusCode != http.StatusOK {
+		t.Fatalf("served by %s with %d, want gitea with 200", name, resp.StatusCode)
+	}
+	want := []string{"missing/owner/repo/releases/download/v1/a.tar.gz", "gitea/owner/repo/releases/download/v1/a.tar.gz"}
+	if len(paths) != 2 || paths[0] != want[0] || paths[1] != want[1] {
+		t.Fatalf("paths = %v, want %v", paths, want)
+	}
+
+	cfg.Mirrors.Raw = []config.MirrorConfig{{Name: "bad", Url: "ftp://x"}}
+	if err := initMirrors(cfg); err == nil {
+		t.Error("expected error for invalid mirror url")
+	}
+}
@@ -38,6 +38,10 @@ func EditorMatcher(rawPath string, cfg *config.Config) (bool, error) {
 			return true, nil
 		}
 	}
+	// 匹配已配置的其他 GitHub 实例
+	if inst, kind, _ := matchInstance(rawPath); inst != nil {
+		return kind != instanceApi || cfg.Shell.RewriteAPI, nil
+	}
 	return false, nil
 }
 
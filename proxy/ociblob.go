@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"ghproxy/config"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/WJQSERVER-STUDIO/go-utils/limitreader"
+	"github.com/fenthope/reco"
+	"github.com/infinite-iroha/touka"
+)
+
+// ociBlobDigest 从 OCI 请求路径中提取 blob 的 sha256 摘要 (hex), 非 blob 请求返回空字符串
+//
+//	/blobs/sha256:<hex>
+func ociBlobDigest(extpath string) string {
+	if i := strings.IndexByte(extpath, '?'); i >= 0 {
+		extpath = extpath[:i]
+	}
+	digest, ok := strings.CutPrefix(extpath, "/blobs/sha256:")
+	if !ok || len(digest) != 64 {
+		return ""
+	}
+	for i := 0; i < len(digest); i++ {
+		ch := digest[i]
+		if (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') {
+			return ""
+		}
+	}
+	return digest
+}
+
+// ociBlobCacheKey 返回 blob 的缓存键
+// 键只与摘要相关, 因此引用同一层的不同镜像 (以及不同注册表) 共用同一份缓存
+func ociBlobCacheKey(digest string) string {
+	return "oci/blobs/sha256:" + digest
+}
+
+// useOciBlobCache 判断本次请求是否使用 blob 缓存
+func useOciBlobCache(c *touka.Context, cfg *config.Config, digest string) bool {
+	if artifactCache == nil || !cfg.Cache.OciBlobs || digest == "" {
+		return false
+	}
+	return c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead
+}
+
+// serveCachedOciBlob 尝试直接从缓存响应 blob 请求, 命中时返回 true
+// 缓存的内容已经过摘要校验, 无需再向注册表认证
+func serveCachedOciBlob(c *touka.Context, cfg *config.Config, digest string) bool {
+	key := ociBlobCacheKey(digest)
+	entry, f, err := artifactCache.Open(key)
+	if err != nil {
+		return false
+	}
+
+	c.SetHeaders(entry.Header)
+	c.SetHeader("Docker-Distribution-API-Version", "registry/2.0")
+	c.SetHeader("X-GHProxy-Cache", "HIT")
+	c.SetHeader("Content-Length", strconv.FormatInt(entry.Size, 10))
+	c.Debugf("Serving blob sha256:%s from cache (Size: %d)", digest, entry.Size)
+	applyCachePolicy(c, cfg, "blobs", true, http.StatusOK)
+
+	if c.Request.Method == http.MethodHead {
+		f.Close()
+		c.Status(http.StatusOK)
+		artifactCache.RecordHit(0)
+		return true
+	}
+	artifactCache.RecordHit(entry.Size)
+
+	c.Status(http.StatusOK)
+	var bodyReader io.ReadCloser = f
+	if cfg.RateLimit.BandwidthLimit.Enabled {
+		bodyReader = limitreader.NewRateLimitedReader(bodyReader, bandwidthLimit, int(bandwidthBurst), c.Request.Context())
+	}
+	c.SetBodyStream(bodyReader, int(entry.Size))
+	return true
+}
+
+// wrapOciBlobBody 为上游返回的 blob 响应体加上摘要校验, 并在校验通过后写入缓存
+func wrapOciBlobBody(logger *reco.Logger, digest string, resp *http.Response) {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	key := ociBlobCacheKey(digest)
+	artifactCache.RecordMiss()
+
+	// 重定向后的响应头来自对象存储, 只保留客户端需要的部分
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Docker-Content-Digest", "sha256:"+digest)
+	header.Set("ETag", `"sha256:`+digest+`"`)
+
+	w, err := artifactCache.Create(key, header)
+	if err != nil {
+		logger.Warnf("Failed to create cache writer for %s: %v", key, err)
+	} else {
+		w.ExpectDigest(digest)
+		resp.Body = &cacheTeeReader{
+			rc:     resp.Body,
+			w:      w,
+			key:    key,
+			expect: resp.ContentLength,
+			logger: logger,
+		}
+	}
+	resp.Body = newDigestVerifyReader(resp.Body, digest)
+}
+
+// digestVerifyReader 在流式传输的同时校验内容的 sha256 摘要
+// 每次读取的数据会保留到下一次上游读取成功后才交给调用方,
+// 因此摘要不匹配时最后一段数据不会被发送, 客户端只会收到不完整的响应而不是错误的内容
+type digestVerifyReader struct {
+	rc   io.ReadCloser
+	h    hash.Hash
+	want string
+
+	bufs [2][]byte
+	cur  int    // held 所在的缓冲区下标
+	held []byte // 最近一次读取, 尚未确认可以发送的数据
+	out  []byte // 可以发送给调用方的数据
+	done bool
+	err  error
+}
+
+func newDigestVerifyReader(rc io.ReadCloser, digest string) *digestVerifyReader {
+	return &digestVerifyReader{
+		rc:   rc,
+		h:    sha256.New(),
+		want: digest,
+		bufs: [2][]byte{make([]byte, BufferSize), make([]byte, BufferSize)},
+	}
+}
+
+func (r *digestVerifyReader) Read(p []byte) (int, error) {
+	for {
+		if len(r.out) > 0 {
+			n := copy(p, r.out)
+			r.out = r.out[n:]
+			return n, nil
+		}
+		if r.done {
+			if r.err == io.EOF && len(r.held) > 0 {
+				r.out, r.held = r.held, nil
+				continue
+			}
+			return 0, r.err
+		}
+
+		// out 已经读完, 其所在的缓冲区可以复用
+		free := r.bufs[1-r.cur]
+		n, err := r.rc.Read(free)
+		if n > 0 {
+			r.h.Write(free[:n])
+			r.out = r.held
+			r.held = free[:n]
+			r.cur = 1 - r.cur
+		}
+		switch {
+		case err == io.EOF:
+			r.done = true
+			r.err = io.EOF
+			if got := hex.EncodeToString(r.h.Sum(nil)); got != r.want {
+				r.err = fmt.Errorf("blob digest mismatch: got sha256:%s, want sha256:%s", got, r.want)
+				r.out, r.held = nil, nil
+			}
+		case err != nil:
+			r.done = true
+			r.err = err
+		}
+	}
+}
+
+func (r *digestVerifyReader) Close() error {
+	return r.rc.Close()
+}
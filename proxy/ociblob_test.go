@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"ghproxy/objcache"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/fenthope/reco"
+)
+
+func TestDigestVerifyReader(t *testing.T) {
+	body := bytes.Repeat([]byte("layer data "), 10000) // 跨越多个缓冲区
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+	wrong := strings.Repeat("0", 64)
+
+	t.Run("Match", func(t *testing.T) {
+		for name, r := range map[string]io.Reader{
+			"whole":   bytes.NewReader(body),
+			"onebyte": iotest.OneByteReader(bytes.NewReader(body)),
+			"dataEOF": iotest.DataErrReader(bytes.NewReader(body)),
+		} {
+			got, err := io.ReadAll(newDigestVerifyReader(io.NopCloser(r), digest))
+			if err != nil || !bytes.Equal(got, body) {
+				t.Errorf("%s: read %d bytes, err %v", name, len(got), err)
+			}
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		for name, r := range map[string]io.Reader{
+			"whole":   bytes.NewReader(body),
+			"dataEOF": iotest.DataErrReader(bytes.NewReader(body)),
+		} {
+			got, err := io.ReadAll(newDigestVerifyReader(io.NopCloser(r), wrong))
+			if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+				t.Errorf("%s: err = %v, want digest mismatch", name, err)
+			}
+			// 最后一段数据被扣留, 客户端不会收到完整的内容
+			if len(got) >= len(body) {
+				t.Errorf("%s: received %d of %d bytes despite mismatch", name, len(got), len(body))
+			}
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		r := io.MultiReader(bytes.NewReader(body[:len(body)/2]), iotest.ErrReader(io.ErrUnexpectedEOF))
+		got, err := io.ReadAll(newDigestVerifyReader(io.NopCloser(r), digest))
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("err = %v, want unexpected EOF", err)
+		}
+		if len(got) >= len(body)/2 {
+			t.Errorf("received %d bytes, want the last chunk held back", len(got))
+		}
+	})
+}
+
+func TestOciBlobCacheVerify(t *testing.T) {
+	store, err := objcache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old *objcache.Store) { artifactCache = old }(artifactCache)
+	artifactCache = store
+	logger, err := reco.New(reco.Config{Output: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	body := []byte("blob content")
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+	fetch := func(digest string) error {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, ContentLength: int64(len(body)), Body: io.NopCloser(bytes.NewReader(body))}
+		wrapOciBlobBody(logger, digest, resp)
+		_, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return err
+	}
+
+	// 内容与摘要不符时不写入缓存
+	wrong := strings.Repeat("a", 64)
+	if err := fetch(wrong); err == nil {
+		t.Error("mismatching blob should fail")
+	}
+	if _, ok := store.Get(ociBlobCacheKey(wrong)); ok {
+		t.Error("mismatching blob was cached")
+	}
+
+	if err := fetch(digest); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := store.Get(ociBlobCacheKey(digest))
+	if !ok || entry.Size != int64(len(body)) || entry.Header.Get("Docker-Content-Digest") != "sha256:"+digest {
+		t.Errorf("cached entry = %+v, %t", entry, ok)
+	}
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"ghproxy/config"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenthope/reco"
+	"github.com/infinite-iroha/touka"
+)
+
+// manifestTTL 按标签查询的 manifest 的缓存有效期
+var manifestTTL time.Duration
+
+// ociManifestRef 从 OCI 请求路径中提取 manifest 的引用 (标签或摘要)
+//
+//	/manifests/<tag>
+//	/manifests/sha256:<hex>
+func ociManifestRef(extpath string) (string, bool) {
+	if i := strings.IndexByte(extpath, '?'); i >= 0 {
+		extpath = extpath[:i]
+	}
+	ref, ok := strings.CutPrefix(extpath, "/manifests/")
+	if !ok || ref == "" || strings.Contains(ref, "/") {
+		return "", false
+	}
+	return ref, true
+}
+
+// manifestRefDigest 返回摘要引用中的 sha256 值 (hex), 标签引用返回空字符串
+func manifestRefDigest(ref string) string {
+	return ociBlobDigest("/blobs/" + ref)
+}
+
+// ociManifestCacheKey 返回 manifest 的缓存键
+// 标签引用的 manifest 由注册表按 Accept 协商 (索引, 单一平台或旧版格式), 键包含 Accept 的摘要,
+// 使不同客户端的条目互不覆盖; 摘要引用的内容是确定的, 不区分 Accept
+func ociManifestCacheKey(target, image, ref string, req *http.Request) string {
+	key := "oci/manifests/" + target + "/" + image + "/" + ref
+	if manifestRefDigest(ref) == "" {
+		key += "#" + acceptFingerprint(req)
+	}
+	return key
+}
+
+// acceptFingerprint 返回请求可接受的媒体类型集合的摘要, 与顺序和参数无关
+func acceptFingerprint(req *http.Request) string {
+	var types []string
+	for _, accept := range req.Header.Values("Accept") {
+		for _, candidate := range strings.Split(accept, ",") {
+			if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(candidate)); err == nil {
+				types = append(types, mediaType)
+			}
+		}
+	}
+	slices.Sort(types)
+	types = slices.Compact(types)
+	sum := sha256.Sum256([]byte(strings.Join(types, ",")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// useOciManifestCache 判断本次请求是否使用 manifest 缓存
+// 注册表请求不携带客户端凭据 (见 GhcrRequest), 缓存中只有公开镜像的 manifest, 命中时无需再向注册表认证
+func useOciManifestCache(c *touka.Context, cfg *config.Config) bool {
+	if artifactCache == nil || !cfg.Cache.OciManifests {
+		return false
+	}
+	return c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead
+}
+
+// acceptsMediaType 判断客户端的 Accept 是否接受给定的媒体类型, 未携带 Accept 时视为接受
+func acceptsMediaType(req *http.Request, mediaType string) bool {
+	accepts := req.Header.Values("Accept")
+	if len(accepts) == 0 {
+		return true
+	}
+	for _, accept := range accepts {
+		for _, candidate := range strings.Split(accept, ",") {
+			candidate, _, _ = mime.ParseMediaType(strings.TrimSpace(candidate))
+			if candidate == "*/*" || candidate == mediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveCachedOciManifest 尝试从缓存响应 manifest 请求, 命中时返回 true
+// 摘要引用的条目永久有效, 标签引用的条目在 manifestTTL 内有效, stale 为 true 时忽略有效期
+func serveCachedOciManifest(c *touka.Context, cfg *config.Config, key string, ref string, stale bool) bool {
+	entry, f, err := artifactCache.Open(key)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(entry.Header.Get("Content-Type"))
+	if !acceptsMediaType(c.Request, mediaType) {
+		return false
+	}
+	expired := manifestRefDigest(ref) == "" && time.Since(entry.StoredAt) > manifestTTL
+	if expired && !stale {
+		return false
+	}
+
+	c.SetHeaders(entry.Header)
+	c.SetHeader("Docker-Distribution-API-Version", "registry/2.0")
+	c.SetHeader("Content-Length", strconv.FormatInt(entry.Size, 10))
+	if expired {
+		c.SetHeader("X-GHProxy-Cache", "STALE")
+		setStaleWarning(c)
+		artifactCache.RecordStale()
+		c.Warnf("Upstream unavailable, serving stale manifest %s (Stored: %s)", key, entry.StoredAt.Format(time.RFC3339))
+	} else {
+		c.SetHeader("X-GHProxy-Cache", "HIT")
+		applyCachePolicy(c, cfg, "manifests", manifestRefDigest(ref) != "", http.StatusOK)
+		c.Debugf("Serving manifest %s from cache (Digest: %s)", key, entry.Header.Get("Docker-Content-Digest"))
+	}
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		artifactCache.RecordHit(0)
+		return true
+	}
+	artifactCache.RecordHit(entry.Size)
+	// manifest 体积很小, 直接写出
+	if _, err := io.Copy(c.Writer, f); err != nil {
+		c.Warnf("Failed to write cached manifest %s: %v", key, err)
+	}
+	return true
+}
+
+// serveStaleOciManifest 在上游不可用时尝试以缓存的 manifest 响应, 成功时返回 true
+func serveStaleOciManifest(c *touka.Context, cfg *config.Config, image *imageInfo) bool {
+	if image == nil || image.ManifestKey == "" {
+		return false
+	}
+	return serveCachedOciManifest(c, cfg, image.ManifestKey, image.ManifestRef, true)
+}
+
+// wrapOciManifestBody 在 manifest 响应可缓存时, 用写入缓存的读取器包装响应体
+// 摘要引用的 manifest 会在提交前校验内容摘要
+func wrapOciManifestBody(logger *reco.Logger, image *imageInfo, resp *http.Response) {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	artifactCache.RecordMiss()
+	header := http.Header{}
+	for _, k := range []string{"Content-Type", "Docker-Content-Digest", "ETag"} {
+		if v := resp.Header.Get(k); v != "" {
+			header.Set(k, v)
+		}
+	}
+
+	w, err := artifactCache.Create(image.ManifestKey, header)
+	if err != nil {
+		logger.Warnf("Failed to create cache writer for %s: %v", image.ManifestKey, err)
+		return
+	}
+	if digest := manifestRefDigest(image.ManifestRef); digest != "" {
+		w.ExpectDigest(digest)
+	}
+	resp.Body = &cacheTeeReader{
+		rc:     resp.Body,
+		w:      w,
+		key:    image.ManifestKey,
+		expect: resp.ContentLength,
+		logger: logger,
+	}
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"ghproxy/config"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/WJQSERVER-STUDIO/httpc"
+	"golang.org/x/net/proxy"
+)
+
+// upstreamMatcherKey 为请求上下文中记录 matcher 的键, 供出站路由使用
+type upstreamMatcherKey struct{}
+
+// withUpstreamMatcher 在上下文中记录请求的 matcher
+func withUpstreamMatcher(ctx context.Context, matcher string) context.Context {
+	return context.WithValue(ctx, upstreamMatcherKey{}, matcher)
+}
+
+// upstreamMatcher 返回上下文中记录的 matcher, 未记录时返回空
+func upstreamMatcher(ctx context.Context) string {
+	matcher, _ := ctx.Value(upstreamMatcherKey{}).(string)
+	return matcher
+}
+
+// outboundRoute 为一条已解析的出站路由规则
+type outboundRoute struct {
+	hosts     []string
+	matchers  map[string]struct{}
+	via       string
+	transport *http.Transport
+}
+
+// match 判断请求是否匹配该规则
+func (r *outboundRoute) match(host, matcher string) bool {
+	if len(r.matchers) > 0 {
+		if _, ok := r.matchers[matcher]; !ok {
+			return false
+		}
+	}
+	if len(r.hosts) == 0 {
+		return true
+	}
+	for _, pattern := range r.hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// outboundRouter 按上游主机与 matcher 为请求选择独立的 Transport
+type outboundRouter struct {
+	routes []*outboundRoute
+}
+
+// newOutboundRouter 解析出站路由规则, 每条规则基于 base 复制出独立的 Transport
+// 同一出口 (direct 或同名代理) 的规则共用一个 Transport; 未配置规则时返回 nil
+func newOutboundRouter(cfg config.OutboundConfig, base *http.Transport) (*outboundRouter, error) {
+	if len(cfg.Routes) == 0 {
+		return nil, nil
+	}
+	transports := make(map[string]*http.Transport)
+	r := &outboundRouter{}
+	for i, rc := range cfg.Routes {
+		via := strings.TrimSpace(rc.Via)
+		if via == "" {
+			return nil, fmt.Errorf("outbound route %d: via is empty", i)
+		}
+		t, ok := transports[via]
+		if !ok {
+			t = base.Clone()
+			if via == "direct" {
+				t.Proxy = nil
+			} else {
+				proxyURL, ok := cfg.Proxies[via]
+				if !ok {
+					return nil, fmt.Errorf("outbound route %d: unknown proxy %q", i, via)
+				}
+				if err := setTransportProxy(t, proxyURL); err != nil {
+					return nil, fmt.Errorf("outbound proxy %q: %w", via, err)
+				}
+			}
+			transports[via] = t
+		}
+		route := &outboundRoute{via: via, transport: t}
+		for _, h := range rc.Hosts {
+			h = strings.ToLower(strings.TrimSpace(h))
+			if _, err := path.Match(h, ""); err != nil {
+				return nil, fmt.Errorf("outbound route %d: invalid host pattern %q: %w", i, h, err)
+			}
+			route.hosts = append(route.hosts, h)
+		}
+		if len(rc.Matchers) > 0 {
+			route.matchers = make(map[string]struct{}, len(rc.Matchers))
+			for _, m := range rc.Matchers {
+				route.matchers[strings.TrimSpace(m)] = struct{}{}
+			}
+		}
+		r.routes = append(r.routes, route)
+	}
+	return r, nil
+}
+
+// setTransportProxy 将 Transport 设置为经 proxyURL 访问, 多个 socks5 地址以逗号分隔时构成代理链
+func setTransportProxy(t *http.Transport, proxyURL string) error {
+	first, _, _ := strings.Cut(proxyURL, ",")
+	u, err := url.Parse(strings.TrimSpace(first))
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if strings.Contains(proxyURL, ",") {
+			return fmt.Errorf("proxy chain only supports socks5")
+		}
+		t.Proxy = http.ProxyURL(u)
+	case "socks5":
+		// newProxyDial 会跳过无法解析的地址, 这里先校验, 避免配置错误时静默直连
+		for _, part := range strings.Split(proxyURL, ",") {
+			pu, err := url.Parse(strings.TrimSpace(part))
+			if err != nil {
+				return err
+			}
+			if pu.Scheme != "socks5" {
+				return fmt.Errorf("proxy chain only supports socks5")
+			}
+		}
+		// 与代理的连接沿用复制自默认 Transport 的解析器与源地址设置
+		dialer, ok := newProxyDial(proxyURL, forwardDialer(t)).(proxy.ContextDialer)
+		if !ok {
+			return fmt.Errorf("socks5 dialer does not support context")
+		}
+		t.Proxy = nil
+		t.DialContext = dialer.DialContext
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// transports 返回各出口使用的 Transport, 每个只出现一次
+func (r *outboundRouter) transports() []*http.Transport {
+	seen := make(map[*http.Transport]struct{}, len(r.routes))
+	var out []*http.Transport
+	for _, route := range r.routes {
+		if _, ok := seen[route.transport]; !ok {
+			seen[route.transport] = struct{}{}
+			out = append(out, route.transport)
+		}
+	}
+	return out
+}
+
+// route 返回请求匹配的规则, 均不匹配时返回 nil
+func (r *outboundRouter) route(req *http.Request) *outboundRoute {
+	host := strings.ToLower(req.URL.Hostname())
+	matcher := upstreamMatcher(req.Context())
+	for _, route := range r.routes {
+		if route.match(host, matcher) {
+			return route
+		}
+	}
+	return nil
+}
+
+// middleware 返回按规则分发请求的 httpc 中间件, 须位于 upstreamMiddleware 之外的最内层
+// 未匹配任何规则的请求交给默认的 Transport
+func (r *outboundRouter) middleware(next http.RoundTripper) http.RoundTripper {
+	return httpc.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if route := r.route(req); route != nil {
+			return roundTripUpstream(route.transport, req)
+		}
+		return next.RoundTrip(req)
+	})
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"ghproxy/config"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestOutboundRouter(t *testing.T) {
+	r, err := newOutboundRouter(config.OutboundConfig{
+		Proxies: map[string]string{
+			"hub":   "socks5://127.0.0.1:1080",
+			"chain": "socks5://127.0.0.1:1080, socks5://127.0.0.1:1081",
+			"http":  "http://127.0.0.1:3128",
+		},
+		Routes: []config.OutboundRouteConfig{
+			{Hosts: []string{"raw.githubusercontent.com"}, Via: "direct"},
+			{Hosts: []string{"registry-1.docker.io", "*.Docker.io"}, Matchers: []string{"docker"}, Via: "hub"},
+			{Matchers: []string{"clone"}, Via: "chain"},
+			{Hosts: []string{"api.github.com"}, Via: "http"},
+		},
+	}, &http.Transport{})
+	if err != nil {
+		t.Fatalf("newOutboundRouter: %v", err)
+	}
+
+	tests := []struct {
+		url     string
+		matcher string
+		want    string
+	}{
+		{"https://raw.githubusercontent.com/u/r/main/f", "raw", "direct"},
+		{"https://registry-1.docker.io/v2/", "docker", "hub"},
+		{"https://production.cloudflare.docker.io/blob", "docker", "hub"},
+		{"https://registry-1.docker.io/v2/", "releases", ""},
+		{"https://github.com/u/r/info/refs", "clone", "chain"},
+		{"https://API.github.com:443/repos", "api", "http"},
+		{"https://github.com/u/r/releases/download/v1/a", "releases", ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequestWithContext(withUpstreamMatcher(context.Background(), tt.matcher), http.MethodGet, tt.url, nil)
+		got := ""
+		if route := r.route(req); route != nil {
+			got = route.via
+		}
+		if got != tt.want {
+			t.Errorf("route(%s, %s) = %q, want %q", tt.url, tt.matcher, got, tt.want)
+		}
+	}
+
+	if r.routes[0].transport.Proxy != nil {
+		t.Error("direct route should not use a proxy")
+	}
+	if r.routes[1].transport == r.routes[2].transport {
+		t.Error("different proxies should use separate transports")
+	}
+
+	for _, cfg := range []config.OutboundConfig{
+		{Routes: []config.OutboundRouteConfig{{Via: "missing"}}},
+		{Routes: []config.OutboundRouteConfig{{}}},
+		{Proxies: map[string]string{"p": "ftp://a"}, Routes: []config.OutboundRouteConfig{{Via: "p"}}},
+		{Proxies: map[string]string{"p": "socks5://a,http://b"}, Routes: []config.OutboundRouteConfig{{Via: "p"}}},
+		{Routes: []config.OutboundRouteConfig{{Hosts: []string{"[a"}, Via: "direct"}}},
+	} {
+		if _, err := newOutboundRouter(cfg, &http.Transport{}); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestOutboundRouteSocksForwardDial(t *testing.T) {
+	var dialed []string
+	base := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return nil, errors.New("refused")
+		},
+	}
+	r, err := newOutboundRouter(config.OutboundConfig{
+		Proxies: map[string]string{"chain": "socks5://127.0.0.1:1080, socks5://127.0.0.1:1081"},
+		Routes:  []config.OutboundRouteConfig{{Via: "chain"}},
+	}, base)
+	if err != nil {
+		t.Fatalf("newOutboundRouter: %v", err)
+	}
+
+	// 与第一个代理的连接经默认 Transport 的 DialContext (解析器与源地址) 建立
+	if _, err := r.routes[0].transport.DialContext(context.Background(), "tcp", "github.com:443"); err == nil {
+		t.Fatal("expected dial error")
+	}
+	if len(dialed) != 1 || dialed[0] != "127.0.0.1:1080" {
+		t.Errorf("dialed %v, want [127.0.0.1:1080]", dialed)
+	}
+}
@@ -0,0 +1,314 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ghproxy/config"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outboundPool 出站代理池, 未配置 pool 时为 nil
+var outboundPool *proxyPool
+
+// defaultEjectCooldown 为未设置 ejectCooldown 时暂停的代理重新启用前的等待时间
+const defaultEjectCooldown = 30 * time.Second
+
+// outboundProxy 为代理池中的一个代理
+type outboundProxy struct {
+	url  *url.URL
+	addr string // 代理的 host:port, 用于识别 Transport 发起的连接
+
+	healthy atomic.Bool
+	conns   atomic.Int64 // 当前经由该代理的连接数
+
+	mu        sync.Mutex
+	fails     int       // 连续失败次数
+	ejectedAt time.Time // 最近一次暂停的时间
+}
+
+// proxyPool 在多个可互相替代的出站代理之间分配连接
+// 选择结果通过 Transport.Proxy 生效, 连接数与连接失败通过包装 Transport.DialContext 统计
+type proxyPool struct {
+	members       []*outboundProxy
+	byAddr        map[string]*outboundProxy
+	leastConn     bool
+	failThreshold int
+	next          atomic.Uint64
+
+	interval time.Duration
+	timeout  time.Duration
+	cooldown time.Duration // 不进行健康检查时, 暂停的代理在该时间后重新启用
+	checkURL string
+	stop     chan struct{}
+}
+
+// newProxyPool 解析出站代理池配置
+func newProxyPool(cfg config.OutboundConfig) (*proxyPool, error) {
+	p := &proxyPool{
+		byAddr:        make(map[string]*outboundProxy),
+		failThreshold: cfg.FailThreshold,
+		checkURL:      cfg.HealthCheckUrl,
+		stop:          make(chan struct{}),
+	}
+	if p.failThreshold <= 0 {
+		p.failThreshold = 1
+	}
+	switch cfg.Strategy {
+	case "round-robin", "":
+	case "least-conn":
+		p.leastConn = true
+	default:
+		return nil, fmt.Errorf("unsupported outbound strategy %q", cfg.Strategy)
+	}
+	var err error
+	if p.interval, err = time.ParseDuration(cfg.HealthCheckInterval); err != nil {
+		return nil, fmt.Errorf("invalid outbound healthCheckInterval %q: %w", cfg.HealthCheckInterval, err)
+	}
+	if p.timeout, err = time.ParseDuration(cfg.HealthCheckTimeout); err != nil {
+		return nil, fmt.Errorf("invalid outbound healthCheckTimeout %q: %w", cfg.HealthCheckTimeout, err)
+	}
+	if p.interval <= 0 {
+		p.cooldown = defaultEjectCooldown
+		if cfg.EjectCooldown != "" {
+			if p.cooldown, err = time.ParseDuration(cfg.EjectCooldown); err != nil || p.cooldown <= 0 {
+				return nil, fmt.Errorf("invalid outbound ejectCooldown %q: must be a positive duration when health checks are disabled", cfg.EjectCooldown)
+			}
+		}
+	}
+
+	for _, raw := range cfg.Pool {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid outbound proxy %q: %w", raw, err)
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported outbound proxy scheme %q", u.Scheme)
+		}
+		m := &outboundProxy{url: u, addr: proxyAddr(u)}
+		if _, dup := p.byAddr[m.addr]; dup {
+			return nil, fmt.Errorf("duplicate outbound proxy %s", m.addr)
+		}
+		m.healthy.Store(true)
+		p.members = append(p.members, m)
+		p.byAddr[m.addr] = m
+	}
+	if len(p.members) == 0 {
+		return nil, fmt.Errorf("outbound pool is empty")
+	}
+	return p, nil
+}
+
+// proxyAddr 返回代理的 host:port, 未指定端口时使用协议的默认端口
+func proxyAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080"}[strings.ToLower(u.Scheme)]
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// pick 按策略选择一个健康的代理, 全部不健康时在所有代理中选择, 避免完全无法出站
+func (p *proxyPool) pick() *outboundProxy {
+	candidates := make([]*outboundProxy, 0, len(p.members))
+	for _, m := range p.members {
+		if !m.healthy.Load() && p.cooldown > 0 {
+			p.restoreAfterCooldown(m)
+		}
+		if m.healthy.Load() {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.members
+	}
+
+	start := int(p.next.Add(1) % uint64(len(candidates)))
+	if !p.leastConn {
+		return candidates[start]
+	}
+	// 从轮询位置开始查找, 连接数相同时轮流使用
+	best := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		m := candidates[(start+i)%len(candidates)]
+		if m.conns.Load() < best.conns.Load() {
+			best = m
+		}
+	}
+	return best
+}
+
+// Proxy 用作 Transport.Proxy, 为每个请求选择代理
+func (p *proxyPool) Proxy(*http.Request) (*url.URL, error) {
+	return p.pick().url, nil
+}
+
+// ProxyURL 返回当前选择的代理地址, 供 git 等外部程序使用
+func (p *proxyPool) ProxyURL() string {
+	return p.pick().url.String()
+}
+
+// wrapDial 包装 Transport 的拨号函数, 统计经由各代理的连接数并记录连接代理失败
+func (p *proxyPool) wrapDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		m, ok := p.byAddr[addr]
+		if !ok {
+			return dial(ctx, network, addr)
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			// 连接超时计为失败, 客户端取消的请求不计
+			if !errors.Is(ctx.Err(), context.Canceled) {
+				p.recordFailure(m, err)
+			}
+			return nil, err
+		}
+		m.conns.Add(1)
+		return &countedConn{Conn: conn, m: m}, nil
+	}
+}
+
+// countedConn 在关闭时减少所属代理的连接数
+type countedConn struct {
+	net.Conn
+	m    *outboundProxy
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.m.conns.Add(-1) })
+	return c.Conn.Close()
+}
+
+// recordFailure 记录一次失败, 连续失败达到阈值时暂停使用该代理
+func (p *proxyPool) recordFailure(m *outboundProxy, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fails++
+	if m.fails >= p.failThreshold && m.healthy.CompareAndSwap(true, false) {
+		m.ejectedAt = time.Now()
+		log.Printf("Outbound proxy %s ejected: %v", m.url.Redacted(), err)
+	}
+}
+
+// restoreAfterCooldown 在不进行健康检查时, 重新启用暂停超过 cooldown 的代理
+// 重新启用后的第一次失败即再次暂停
+func (p *proxyPool) restoreAfterCooldown(m *outboundProxy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Since(m.ejectedAt) < p.cooldown {
+		return
+	}
+	m.fails = p.failThreshold - 1
+	if m.healthy.CompareAndSwap(false, true) {
+		log.Printf("Outbound proxy %s restored after %s cooldown", m.url.Redacted(), p.cooldown)
+	}
+}
+
+// recordSuccess 记录一次成功的健康检查, 恢复已暂停的代理
+func (p *proxyPool) recordSuccess(m *outboundProxy) {
+	m.mu.Lock()
+	m.fails = 0
+	m.mu.Unlock()
+	if m.healthy.CompareAndSwap(false, true) {
+		log.Printf("Outbound proxy %s restored", m.url.Redacted())
+	}
+}
+
+// start 启动后台健康检查
+func (p *proxyPool) start() {
+	if p.interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.checkAll()
+			}
+		}
+	}()
+}
+
+// close 停止健康检查
+func (p *proxyPool) close() {
+	close(p.stop)
+}
+
+func (p *proxyPool) checkAll() {
+	var wg sync.WaitGroup
+	for _, m := range p.members {
+		wg.Add(1)
+		go func(m *outboundProxy) {
+			defer wg.Done()
+			if err := p.check(m); err != nil {
+				p.recordFailure(m, err)
+				return
+			}
+			p.recordSuccess(m)
+		}(m)
+	}
+	wg.Wait()
+}
+
+// check 检查单个代理: 未配置检查地址时只建立 TCP 连接, 否则经由该代理请求检查地址
+func (p *proxyPool) check(m *outboundProxy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	if p.checkURL == "" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", m.addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.checkURL, nil)
+	if err != nil {
+		return err
+	}
+	tr := &http.Transport{Proxy: http.ProxyURL(m.url), DisableKeepAlives: true}
+	defer tr.CloseIdleConnections()
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+// initOutboundPool 根据配置创建出站代理池, 未配置 pool 时不创建
+func initOutboundPool(cfg *config.Config) error {
+	if outboundPool != nil {
+		outboundPool.close()
+		outboundPool = nil
+	}
+	if !cfg.Outbound.Enabled || len(cfg.Outbound.Pool) == 0 {
+		return nil
+	}
+	pool, err := newProxyPool(cfg.Outbound)
+	if err != nil {
+		return err
+	}
+	pool.start()
+	outboundPool = pool
+	log.Printf("Using outbound proxy pool (%d proxies, strategy: %s)", len(pool.members), cfg.Outbound.Strategy)
+	return nil
+}
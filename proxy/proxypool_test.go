@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"errors"
+	"ghproxy/config"
+	"testing"
+	"time"
+)
+
+func TestProxyPool(t *testing.T) {
+	newPool := func(strategy string) *proxyPool {
+		p, err := newProxyPool(config.OutboundConfig{
+			Pool:                []string{"http://a:8080", "socks5://b", "https://c"},
+			Strategy:            strategy,
+			HealthCheckInterval: "30s",
+			HealthCheckTimeout:  "5s",
+			FailThreshold:       2,
+		})
+		if err != nil {
+			t.Fatalf("newProxyPool: %v", err)
+		}
+		return p
+	}
+
+	t.Run("default ports", func(t *testing.T) {
+		p := newPool("round-robin")
+		for _, addr := range []string{"a:8080", "b:1080", "c:443"} {
+			if _, ok := p.byAddr[addr]; !ok {
+				t.Errorf("missing member %s", addr)
+			}
+		}
+	})
+
+	t.Run("round-robin skips ejected", func(t *testing.T) {
+		p := newPool("round-robin")
+		b := p.byAddr["b:1080"]
+		p.recordFailure(b, errors.New("refused"))
+		if !b.healthy.Load() {
+			t.Fatal("ejected before reaching threshold")
+		}
+		p.recordFailure(b, errors.New("refused"))
+		for range 6 {
+			if p.pick() == b {
+				t.Fatal("picked ejected proxy")
+			}
+		}
+		p.recordSuccess(b)
+		seen := false
+		for range 3 {
+			seen = seen || p.pick() == b
+		}
+		if !seen {
+			t.Fatal("restored proxy never picked")
+		}
+	})
+
+	t.Run("least-conn", func(t *testing.T) {
+		p := newPool("least-conn")
+		p.byAddr["a:8080"].conns.Store(3)
+		p.byAddr["c:443"].conns.Store(1)
+		for range 3 {
+			if got := p.pick().addr; got != "b:1080" {
+				t.Fatalf("pick = %s, want b:1080", got)
+			}
+		}
+	})
+
+	t.Run("all ejected", func(t *testing.T) {
+		p := newPool("round-robin")
+		for _, m := range p.members {
+			m.healthy.Store(false)
+		}
+		if p.pick() == nil {
+			t.Fatal("no proxy picked")
+		}
+	})
+
+	t.Run("cooldown without health checks", func(t *testing.T) {
+		p, err := newProxyPool(config.OutboundConfig{
+			Pool:                []string{"http://a:8080", "http://b:8080"},
+			HealthCheckInterval: "0",
+			HealthCheckTimeout:  "5s",
+			FailThreshold:       2,
+			EjectCooldown:       "1h",
+		})
+		if err != nil {
+			t.Fatalf("newProxyPool: %v", err)
+		}
+		a := p.byAddr["a:8080"]
+		p.recordFailure(a, errors.New("refused"))
+		p.recordFailure(a, errors.New("refused"))
+		for range 4 {
+			if p.pick() == a {
+				t.Fatal("picked ejected proxy before cooldown")
+			}
+		}
+
+		// 冷却结束后重新启用, 再次失败一次即暂停
+		a.mu.Lock()
+		a.ejectedAt = a.ejectedAt.Add(-2 * time.Hour)
+		a.mu.Unlock()
+		seen := false
+		for range 2 {
+			seen = seen || p.pick() == a
+		}
+		if !seen {
+			t.Fatal("proxy not restored after cooldown")
+		}
+		p.recordFailure(a, errors.New("refused"))
+		if a.healthy.Load() {
+			t.Fatal("restored proxy not ejected on the next failure")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, cfg := range []config.OutboundConfig{
+			{Pool: []string{"ftp://a"}, HealthCheckInterval: "30s", HealthCheckTimeout: "5s"},
+			{Pool: []string{"http://a"}, Strategy: "random", HealthCheckInterval: "30s", HealthCheckTimeout: "5s"},
+			{Pool: []string{"http://a", "http://a:80"}, HealthCheckInterval: "30s", HealthCheckTimeout: "5s"},
+			{Pool: []string{"http://a"}, HealthCheckInterval: "0", HealthCheckTimeout: "5s", EjectCooldown: "0"},
+		} {
+			if _, err := newProxyPool(cfg); err == nil {
+				t.Errorf("expected error for %+v", cfg)
+			}
+		}
+	})
+}
@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"ghproxy/config"
+	"ghproxy/objcache"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/WJQSERVER-STUDIO/go-utils/limitreader"
+	"github.com/infinite-iroha/touka"
+)
+
+// maxRanges 单个请求允许的最大范围数量, 超过时返回完整内容
+const maxRanges = 32
+
+// errUnsatisfiableRange 表示请求的所有范围都超出了内容长度
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// httpRange 表示内容中从 start 开始的 length 字节
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange 解析 Range 请求头
+// 请求头缺失, 格式错误, 单位不是 bytes 或范围过多时返回 nil, 调用方应返回完整内容;
+// 所有范围都超出 size 时返回 errUnsatisfiableRange
+func parseRange(s string, size int64) ([]httpRange, error) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok {
+		return nil, nil
+	}
+	var (
+		ranges []httpRange
+		total  int64
+	)
+	specs := strings.Split(spec, ",")
+	if len(specs) > maxRanges {
+		return nil, nil
+	}
+	for _, ra := range specs {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		var r httpRange
+		if first == "" {
+			// -N 表示最后 N 个字节
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = httpRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, nil
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = httpRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+		total += r.length
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	// 范围总和超过内容本身 (重叠或重复的范围) 时直接返回完整内容
+	if total > size {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// ifRangeMatches 判断 If-Range 条件是否成立, 不成立时应忽略 Range 返回完整内容
+// 实体标签使用强比较, 日期须与 Last-Modified 完全一致
+func ifRangeMatches(req *http.Request, header http.Header) bool {
+	ir := req.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		etag := header.Get("ETag")
+		return etag != "" && !strings.HasPrefix(ir, "W/") && !strings.HasPrefix(etag, "W/") && ir == etag
+	}
+	since, err := http.ParseTime(ir)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && modified.Equal(since)
+}
+
+// requestedRanges 返回客户端对缓存条目请求的范围, 无需按范围响应时返回 nil
+func requestedRanges(req *http.Request, entry *objcache.Entry) ([]httpRange, error) {
+	rangeHeader := req.Header.Get("Range")
+	if rangeHeader == "" || !ifRangeMatches(req, entry.Header) {
+		return nil, nil
+	}
+	return parseRange(rangeHeader, entry.Size)
+}
+
+// serveUnsatisfiableRange 响应 416, 并告知客户端内容的实际长度
+func serveUnsatisfiableRange(c *touka.Context, size int64) {
+	c.DelHeader("Content-Type")
+	c.SetHeader("Content-Range", fmt.Sprintf("bytes */%d", size))
+	c.SetHeader("Content-Length", "0")
+	c.Status(http.StatusRequestedRangeNotSatisfiable)
+}
+
+// serveCachedRanges 以 206 响应缓存条目的部分内容, 多个范围使用 multipart/byteranges
+// 在写出响应头前打开第一个范围, 失败时返回 false, 调用方可改为请求上游
+func serveCachedRanges(c *touka.Context, cfg *config.Config, key string, matcher string, entry *objcache.Entry, ranges []httpRange, status string) bool {
+	first, err := artifactCache.OpenRange(entry, ranges[0].start, ranges[0].length)
+	if err != nil {
+		c.Warnf("Failed to open cached range of %s: %v", key, err)
+		return false
+	}
+
+	setCachedHeaders(c, cfg, key, matcher, entry, status, http.StatusPartialContent)
+
+	limit := func(rc io.ReadCloser) io.ReadCloser {
+		if cfg.RateLimit.BandwidthLimit.Enabled {
+			return limitreader.NewRateLimitedReader(rc, bandwidthLimit, int(bandwidthBurst), c.Request.Context())
+		}
+		return rc
+	}
+
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		c.Debugf("Serving range %s of %s from cache", ra.contentRange(entry.Size), key)
+		artifactCache.RecordHit(ra.length)
+		body := limit(first)
+		defer body.Close()
+		c.SetHeader("Content-Range", ra.contentRange(entry.Size))
+		c.SetHeader("Content-Length", strconv.FormatInt(ra.length, 10))
+		c.Status(http.StatusPartialContent)
+		c.WriteStream(body)
+		return true
+	}
+
+	contentType := entry.Header.Get("Content-Type")
+	mw := multipart.NewWriter(c.Writer)
+	var sent int64
+	for _, ra := range ranges {
+		sent += ra.length
+	}
+	c.Debugf("Serving %d ranges of %s from cache", len(ranges), key)
+	artifactCache.RecordHit(sent)
+	c.SetHeader("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	c.SetHeader("Content-Length", strconv.FormatInt(multipartRangesSize(ranges, contentType, entry.Size, mw.Boundary()), 10))
+	c.Status(http.StatusPartialContent)
+
+	for i, ra := range ranges {
+		rc := first
+		if i > 0 {
+			rc, err = artifactCache.OpenRange(entry, ra.start, ra.length)
+			if err != nil {
+				// 响应头已发出, 只能中断响应
+				c.Warnf("Failed to open cached range of %s: %v", key, err)
+				return true
+			}
+		}
+		part, err := mw.CreatePart(rangePartHeader(ra, contentType, entry.Size))
+		if err == nil {
+			body := limit(rc)
+			_, err = io.Copy(part, body)
+			body.Close()
+		} else {
+			rc.Close()
+		}
+		if err != nil {
+			c.Debugf("Failed to write cached range of %s: %v", key, err)
+			return true
+		}
+	}
+	mw.Close()
+	return true
+}
+
+// rangePartHeader 返回 multipart/byteranges 中单个范围的头部
+func rangePartHeader(ra httpRange, contentType string, size int64) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	h.Set("Content-Range", ra.contentRange(size))
+	return h
+}
+
+// multipartRangesSize 计算 multipart/byteranges 响应体的长度
+func multipartRangesSize(ranges []httpRange, contentType string, size int64, boundary string) int64 {
+	var cw countingWriter
+	mw := multipart.NewWriter(&cw)
+	mw.SetBoundary(boundary)
+	var n int64
+	for _, ra := range ranges {
+		mw.CreatePart(rangePartHeader(ra, contentType, size))
+		n += ra.length
+	}
+	mw.Close()
+	return n + int64(cw)
+}
+
+// countingWriter 只统计写入的字节数
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// contentRangeSize 从 206 响应的 Content-Range 头中取出内容的完整长度, 长度未知时返回 false
+func contentRangeSize(contentRange string) (int64, bool) {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok || total == "*" {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// parseContentRange 解析 "bytes start-end/size" 形式的 Content-Range 头
+func parseContentRange(contentRange string) (start, end, size int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(contentRange), "bytes ")
+	if !found {
+		return 0, 0, 0, false
+	}
+	rng, total, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+	first, last, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	var err1, err2, err3 error
+	start, err1 = strconv.ParseInt(first, 10, 64)
+	end, err2 = strconv.ParseInt(last, 10, 64)
+	size, err3 = strconv.ParseInt(total, 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || start < 0 || end < start || end >= size {
+		return 0, 0, 0, false
+	}
+	return start, end, size, true
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		name    string
+		header  string
+		size    int64
+		want    []httpRange
+		wantErr error
+	}{
+		{"Single", "bytes=0-99", 1000, []httpRange{{0, 100}}, nil},
+		{"OpenEnded", "bytes=900-", 1000, []httpRange{{900, 100}}, nil},
+		{"Suffix", "bytes=-100", 1000, []httpRange{{900, 100}}, nil},
+		{"SuffixLargerThanSize", "bytes=-2000", 1000, []httpRange{{0, 1000}}, nil},
+		{"EndClamped", "bytes=990-2000", 1000, []httpRange{{990, 10}}, nil},
+		{"Multi", "bytes=0-9, 20-29", 1000, []httpRange{{0, 10}, {20, 10}}, nil},
+		{"SkipUnsatisfiable", "bytes=0-9,2000-", 1000, []httpRange{{0, 10}}, nil},
+		{"Unsatisfiable", "bytes=1000-", 1000, nil, errUnsatisfiableRange},
+		{"Malformed", "bytes=9-0", 1000, nil, nil},
+		{"UnknownUnit", "items=0-9", 1000, nil, nil},
+		{"OverlapExceedsSize", "bytes=0-,0-", 1000, nil, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseRange(tc.header, tc.size)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ranges = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	header := http.Header{
+		"Etag":          {`"abc"`},
+		"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"},
+	}
+	testCases := []struct {
+		ifRange string
+		want    bool
+	}{
+		{"", true},
+		{`"abc"`, true},
+		{`"def"`, false},
+		{`W/"abc"`, false},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", true},
+		{"Mon, 02 Jan 2006 15:04:06 GMT", false},
+	}
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		if tc.ifRange != "" {
+			req.Header.Set("If-Range", tc.ifRange)
+		}
+		if got := ifRangeMatches(req, header); got != tc.want {
+			t.Errorf("ifRangeMatches(%q) = %v, want %v", tc.ifRange, got, tc.want)
+		}
+	}
+}
+
+func TestServeCachedRanges(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected upstream request %s (Range: %q)", r.URL.Path, r.Header.Get("Range"))
+		http.Error(w, "unexpected", http.StatusBadGateway)
+	}))
+	defer upstream.Close()
+
+	r := newProxyTestEngine(t, newCacheTestConfig(t), upstream.URL, "releases")
+	const (
+		target  = "/u/r/releases/download/v1/a.bin"
+		content = "0123456789abcdefghijklmnopqrstuvwxyz"
+	)
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("ETag", `"v1"`)
+	w, err := artifactCache.Create(upstream.URL+target, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(content))
+	if _, err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	size := strconv.Itoa(len(content))
+
+	t.Run("Single", func(t *testing.T) {
+		rec := doGet(r, target, map[string]string{"Range": "bytes=10-19"})
+		if rec.Code != http.StatusPartialContent || rec.Body.String() != content[10:20] {
+			t.Fatalf("range = %d %q", rec.Code, rec.Body.String())
+		}
+		for k, want := range map[string]string{
+			"Content-Range":   "bytes 10-19/" + size,
+			"Content-Length":  "10",
+			"Content-Type":    "application/octet-stream",
+			"Accept-Ranges":   "bytes",
+			"X-GHProxy-Cache": "HIT",
+		} {
+			if got := rec.Header().Get(k); got != want {
+				t.Errorf("%s = %q, want %q", k, got, want)
+			}
+		}
+	})
+
+	t.Run("Multipart", func(t *testing.T) {
+		rec := doGet(r, target, map[string]string{"Range": "bytes=0-3, -4"})
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("ranges = %d %q", rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(rec.Body.Len()) {
+			t.Errorf("Content-Length = %s, body %d bytes", got, rec.Body.Len())
+		}
+		mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("Content-Type = %q", rec.Header().Get("Content-Type"))
+		}
+		mr := multipart.NewReader(rec.Body, params["boundary"])
+		for _, want := range []struct{ contentRange, body string }{
+			{"bytes 0-3/" + size, content[:4]},
+			{"bytes 32-35/" + size, content[32:]},
+		} {
+			part, err := mr.NextPart()
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(part)
+			if part.Header.Get("Content-Range") != want.contentRange || part.Header.Get("Content-Type") != "application/octet-stream" || string(body) != want.body {
+				t.Errorf("part = %v %q, want %s %q", part.Header, body, want.contentRange, want.body)
+			}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			t.Errorf("extra part: %v", err)
+		}
+	})
+
+	t.Run("Unsatisfiable", func(t *testing.T) {
+		rec := doGet(r, target, map[string]string{"Range": "bytes=100-"})
+		if rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Body.Len() != 0 {
+			t.Fatalf("unsatisfiable = %d %q", rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Range"); got != "bytes */"+size {
+			t.Errorf("Content-Range = %q", got)
+		}
+	})
+
+	t.Run("IfRangeMismatch", func(t *testing.T) {
+		rec := doGet(r, target, map[string]string{"Range": "bytes=10-19", "If-Range": `"v0"`})
+		if rec.Code != http.StatusOK || rec.Body.String() != content || rec.Header().Get("X-GHProxy-Cache") != "HIT" {
+			t.Fatalf("If-Range mismatch = %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+		}
+		if rec.Header().Get("Content-Range") != "" || rec.Header().Get("Content-Length") != size {
+			t.Errorf("headers = %v", rec.Header())
+		}
+	})
+}
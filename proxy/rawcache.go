@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// isCommitSHA 判断 ref 是否为完整的提交 SHA (sha1 为 40 位, sha256 为 64 位)
+func isCommitSHA(ref string) bool {
+	if len(ref) != 40 && len(ref) != 64 {
+		return false
+	}
+	for i := 0; i < len(ref); i++ {
+		ch := ref[i]
+		if (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') && (ch < 'A' || ch > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isImmutableRawURL 判断 raw 文件 URL 是否固定到某个提交 SHA, 此类内容永远不会变化
+//
+//	https://raw.githubusercontent.com/:user/:repo/:sha/path
+//	https://github.com/:user/:repo/raw/:sha/path
+//	https://github.com/:user/:repo/blob/:sha/path
+func isImmutableRawURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(strings.TrimPrefix(parsed.Path, "/"), "/")
+	switch parsed.Host {
+	case "raw.githubusercontent.com":
+		return len(parts) >= 4 && isCommitSHA(parts[2])
+	case "github.com":
+		return len(parts) >= 5 && (parts[2] == "raw" || parts[2] == "blob") && isCommitSHA(parts[3])
+	}
+	return false
+}
+
+// isImmutableReleaseURL 判断 releases 资源或源码归档是否固定到标签或提交 SHA
+// 分支归档与 releases/latest 的内容会随上游变化, 需要重新验证
+// 标签下的资源可能被删除后重新上传, 为避免每次命中都请求上游, 仍视为不变, 由管理接口清除
+//
+//	https://github.com/:user/:repo/releases/download/:tag/file
+//	https://github.com/:user/:repo/archive/refs/tags/:tag.tar.gz
+//	https://github.com/:user/:repo/archive/:sha.zip
+func isImmutableReleaseURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	p := parsed.Path
+	if i := strings.Index(p, "/releases/download/"); i >= 0 {
+		tag, _, ok := strings.Cut(p[i+len("/releases/download/"):], "/")
+		return ok && tag != "" && tag != "latest"
+	}
+	if i := strings.Index(p, "/archive/"); i >= 0 {
+		ref := p[i+len("/archive/"):]
+		if strings.HasPrefix(ref, "refs/tags/") {
+			return true
+		}
+		if strings.HasPrefix(ref, "refs/") {
+			return false
+		}
+		for _, ext := range []string{".tar.gz", ".zip"} {
+			if name, ok := strings.CutSuffix(ref, ext); ok {
+				return isCommitSHA(name)
+			}
+		}
+	}
+	return false
+}
+
+// clientNotModified 判断客户端的条件请求头是否与缓存的校验值匹配
+// If-None-Match 存在时优先使用, 此时忽略 If-Modified-Since
+func clientNotModified(req *http.Request, header http.Header) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakETag(candidate) == weakETag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims := req.Header.Get("If-Modified-Since")
+	lastModified := header.Get("Last-Modified")
+	if ims == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// weakETag 去除弱校验前缀, 用于 If-None-Match 的弱比较
+func weakETag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestImmutableURLs(t *testing.T) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	for u, want := range map[string]bool{
+		"https://github.com/u/r/releases/download/v1.0/a.tgz":       true,
+		"https://github.com/u/r/releases/latest/download/a.tgz":     false,
+		"https://github.com/u/r/releases/download/latest/a.tgz":     false,
+		"https://github.com/u/r/archive/refs/tags/v1.0.tar.gz":      true,
+		"https://github.com/u/r/archive/refs/heads/main.tar.gz":     false,
+		"https://github.com/u/r/archive/main.zip":                   false,
+		"https://github.com/u/r/archive/" + sha + ".zip":            true,
+		"https://ghe.example.com/u/r/releases/download/v2/a.tgz":    true,
+		"https://ghe.example.com/u/r/archive/refs/heads/dev.tar.gz": false,
+	} {
+		if got := isImmutableReleaseURL(u); got != want {
+			t.Errorf("isImmutableReleaseURL(%s) = %t, want %t", u, got, want)
+		}
+		if got := needsRevalidation("releases", u); got == want {
+			t.Errorf("needsRevalidation(releases, %s) = %t", u, got)
+		}
+	}
+	for u, want := range map[string]bool{
+		"https://raw.githubusercontent.com/u/r/" + sha + "/a.sh": true,
+		"https://raw.githubusercontent.com/u/r/main/a.sh":        false,
+		"https://github.com/u/r/blob/" + sha + "/a.sh":           true,
+	} {
+		if got := isImmutableRawURL(u); got != want {
+			t.Errorf("isImmutableRawURL(%s) = %t, want %t", u, got, want)
+		}
+	}
+}
+
+func TestRawRevalidation(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		etag    = `"v1"`
+		body    = "one"
+		lastINM string
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		lastINM = r.Header.Get("If-None-Match")
+		w.Header().Set("ETag", etag)
+		if lastINM == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	defer upstream.Close()
+
+	r := newProxyTestEngine(t, newCacheTestConfig(t), upstream.URL, "raw")
+	const target = "/u/r/main/a.txt"
+	key := upstream.URL + target
+
+	rec := doGet(r, target, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "one" || rec.Header().Get("X-GHProxy-Cache") != "MISS" {
+		t.Fatalf("first = %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	stored, ok := artifactCache.Get(key)
+	if !ok {
+		t.Fatal("entry not stored")
+	}
+	storedAt := stored.StoredAt
+	time.Sleep(10 * time.Millisecond)
+
+	// 上游返回 304 时以缓存条目响应, 并更新验证时间
+	rec = doGet(r, target, map[string]string{"If-None-Match": `"client"`})
+	if lastINM != `"v1"` {
+		t.Errorf("conditional request If-None-Match = %q, want %q", lastINM, `"v1"`)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != "one" || rec.Header().Get("X-GHProxy-Cache") != "REVALIDATED" {
+		t.Fatalf("revalidated = %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if entry, _ := artifactCache.Get(key); !entry.StoredAt.After(storedAt) {
+		t.Errorf("StoredAt not refreshed: %s, before %s", entry.StoredAt, storedAt)
+	}
+
+	// 客户端持有的校验值与缓存条目一致时返回 304
+	rec = doGet(r, target, map[string]string{"If-None-Match": `"v1"`})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("X-GHProxy-Cache") != "REVALIDATED" {
+		t.Errorf("client not modified = %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+
+	// 上游内容变化时以新的响应替换缓存条目
+	mu.Lock()
+	etag, body = `"v2"`, "two"
+	mu.Unlock()
+	rec = doGet(r, target, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "two" || rec.Header().Get("X-GHProxy-Cache") != "MISS" {
+		t.Fatalf("changed = %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if lastINM != `"v1"` {
+		t.Errorf("conditional request If-None-Match = %q, want %q", lastINM, `"v1"`)
+	}
+	entry, ok := artifactCache.Get(key)
+	if !ok || entry.Header.Get("ETag") != `"v2"` || entry.Size != int64(len("two")) {
+		t.Fatalf("entry not replaced: %+v", entry)
+	}
+	rec = doGet(r, target, nil)
+	if rec.Body.String() != "two" || rec.Header().Get("X-GHProxy-Cache") != "REVALIDATED" {
+		t.Errorf("after replace = %q %v", rec.Body.String(), rec.Header())
+	}
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fenthope/reco"
+)
+
+// resumeAttempts 单个响应最多续传次数, 为 0 时不续传
+var resumeAttempts int
+
+// errResumeClosed 表示续传期间响应体已被关闭
+var errResumeClosed = errors.New("resume: body closed")
+
+// resumable 判断上游响应中断后能否续传: 完整的 GET 响应, 已知长度且带有强 ETag
+func resumable(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || resp.Request == nil || resp.Request.Method != http.MethodGet {
+		return false
+	}
+	if resp.ContentLength <= 0 || resp.Uncompressed {
+		return false
+	}
+	etag := resp.Header.Get("ETag")
+	return etag != "" && !strings.HasPrefix(etag, "W/")
+}
+
+// wrapResumeBody 在上游连接中断时以 Range 与 If-Range 从已读取的位置重新请求,
+// 并将新的内容接在原响应体之后, 调用方读取到的是完整且连续的内容
+// fetch 用于发出续传请求, 须对响应体做与原响应相同的包装 (如停滞检测)
+func wrapResumeBody(logger *reco.Logger, matcher string, resp *http.Response, fetch func(*http.Request) (*http.Response, error)) {
+	if resumeAttempts <= 0 || !resumable(resp) {
+		return
+	}
+	resp.Body = &resumeReader{
+		rc:      resp.Body,
+		req:     resp.Request,
+		etag:    resp.Header.Get("ETag"),
+		size:    resp.ContentLength,
+		fetch:   fetch,
+		logger:  logger,
+		matcher: matcher,
+	}
+}
+
+// resumeReader 为可续传的上游响应体
+type resumeReader struct {
+	req      *http.Request
+	etag     string
+	size     int64
+	offset   int64 // 已读取的字节数
+	attempts int
+	fetch    func(*http.Request) (*http.Response, error)
+	logger   *reco.Logger
+	matcher  string
+
+	mu     sync.Mutex
+	rc     io.ReadCloser
+	closed bool
+}
+
+func (r *resumeReader) Read(p []byte) (int, error) {
+	for {
+		r.mu.Lock()
+		rc := r.rc
+		r.mu.Unlock()
+
+		n, err := rc.Read(p)
+		r.offset += int64(n)
+		if err == nil || (err == io.EOF && r.offset >= r.size) {
+			return n, err
+		}
+		// 客户端已取消, 不再续传
+		if r.req.Context().Err() != nil {
+			return n, err
+		}
+		if rerr := r.resume(err); rerr != nil {
+			r.logger.Warnf("Failed to resume upstream download: matcher=%s url=%s offset=%d: %v", r.matcher, r.req.URL, r.offset, rerr)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume 从当前位置重新请求剩余内容并替换响应体
+func (r *resumeReader) resume(cause error) error {
+	if r.attempts >= resumeAttempts {
+		return fmt.Errorf("giving up after %d attempts: %w", r.attempts, cause)
+	}
+	r.attempts++
+	r.logger.Infof("Resuming upstream download: matcher=%s url=%s offset=%d/%d attempt=%d cause=%v", r.matcher, r.req.URL, r.offset, r.size, r.attempts, cause)
+
+	req := r.req.Clone(r.req.Context())
+	req.Body = http.NoBody
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.offset, r.size-1))
+	req.Header.Set("If-Range", r.etag)
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	resp, err := r.fetch(req)
+	if err != nil {
+		return err
+	}
+	start, end, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	// If-Range 不匹配时上游返回 200 完整内容, 说明资源已变化, 无法续传
+	if resp.StatusCode != http.StatusPartialContent || !ok || start != r.offset || end != r.size-1 || size != r.size {
+		resp.Body.Close()
+		return fmt.Errorf("unexpected resume response %s (Content-Range: %q)", resp.Status, resp.Header.Get("Content-Range"))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		resp.Body.Close()
+		return errResumeClosed
+	}
+	r.rc.Close()
+	r.rc = resp.Body
+	return nil
+}
+
+func (r *resumeReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return r.rc.Close()
+}
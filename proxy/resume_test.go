@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fenthope/reco"
+)
+
+func TestResumeBody(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if r.Header.Get("Range") == "" {
+			// 发送一部分内容后断开连接
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nETag: %s\r\n\r\n%s", len(content), etag.Load(), content[:3000])
+			buf.Flush()
+			conn.Close()
+			return
+		}
+		if n == 2 && r.Header.Get("Range") == "bytes=3000-9999" {
+			// 第一次续传同样中途断开
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			fmt.Fprintf(buf, "HTTP/1.1 206 Partial Content\r\nContent-Length: 7000\r\nContent-Range: bytes 3000-9999/10000\r\nETag: %s\r\n\r\n%s", etag.Load(), content[3000:5000])
+			buf.Flush()
+			conn.Close()
+			return
+		}
+		w.Header().Set("ETag", etag.Load().(string))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	logger, err := reco.New(reco.Config{Output: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	resumeAttempts = 3
+	defer func() { resumeAttempts = 0 }()
+
+	get := func(t *testing.T) (*http.Response, error) {
+		t.Helper()
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapResumeBody(logger, "releases", resp, http.DefaultClient.Do)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err == nil && !bytes.Equal(body, []byte(content)) {
+			t.Fatalf("body mismatch: got %d bytes", len(body))
+		}
+		return resp, err
+	}
+
+	t.Run("Spliced", func(t *testing.T) {
+		calls.Store(0)
+		if _, err := get(t); err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("upstream calls = %d, want 3", calls.Load())
+		}
+	})
+
+	t.Run("ChangedETag", func(t *testing.T) {
+		calls.Store(10)
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapResumeBody(logger, "releases", resp, func(req *http.Request) (*http.Response, error) {
+			// 资源在两次请求之间发生变化, If-Range 不匹配时上游返回 200
+			etag.Store(`"v2"`)
+			return http.DefaultClient.Do(req)
+		})
+		defer resp.Body.Close()
+		if _, err := io.ReadAll(resp.Body); err == nil {
+			t.Fatal("expected error when the resource changed")
+		}
+		etag.Store(`"v1"`)
+	})
+
+	t.Run("WeakETag", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			ContentLength: 10,
+			Header:        http.Header{"Etag": {`W/"v1"`}},
+			Request:       httptest.NewRequest(http.MethodGet, srv.URL, nil),
+		}
+		if resumable(resp) {
+			t.Error("weak ETag should not be resumable")
+		}
+	})
+}
+
+func TestParseContentRange(t *testing.T) {
+	start, end, size, ok := parseContentRange("bytes 3000-9999/10000")
+	if !ok || start != 3000 || end != 9999 || size != 10000 {
+		t.Errorf("got %d-%d/%d %v", start, end, size, ok)
+	}
+	for _, v := range []string{"", "bytes */10000", "bytes 5-4/10", "bytes 0-10/10", "items 0-1/2"} {
+		if _, _, _, ok := parseContentRange(v); ok {
+			t.Errorf("parseContentRange(%q) should fail", v)
+		}
+	}
+}
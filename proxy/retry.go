@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"ghproxy/config"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WJQSERVER-STUDIO/httpc"
+)
+
+// retryPolicy 定义上游请求的重试策略
+// 重试在 RoundTripper 层完成, 此时尚未向客户端发送任何响应内容
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	budget     time.Duration // <=0 表示不限制
+	statuses   map[int]struct{}
+}
+
+// newRetryPolicy 解析重试配置
+func newRetryPolicy(cfg config.RetryConfig) (*retryPolicy, error) {
+	p := &retryPolicy{
+		maxRetries: cfg.MaxRetries,
+		statuses:   make(map[int]struct{}, len(cfg.Statuses)),
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"baseDelay", cfg.BaseDelay, &p.baseDelay},
+		{"maxDelay", cfg.MaxDelay, &p.maxDelay},
+		{"budget", cfg.Budget, &p.budget},
+	} {
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry %s %q: %w", d.name, d.value, err)
+		}
+		*d.dst = v
+	}
+	for _, status := range cfg.Statuses {
+		p.statuses[status] = struct{}{}
+	}
+	return p, nil
+}
+
+// middleware 返回执行重试的 httpc 中间件
+func (p *retryPolicy) middleware(next http.RoundTripper) http.RoundTripper {
+	return httpc.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !retryableRequest(req) {
+			return next.RoundTrip(req)
+		}
+		start := time.Now()
+		for attempt := 0; ; attempt++ {
+			resp, err := next.RoundTrip(req)
+			if attempt >= p.maxRetries || !p.shouldRetry(req, resp, err) {
+				return resp, err
+			}
+
+			delay := p.backoff(attempt)
+			if resp != nil {
+				if after, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok && after > delay {
+					delay = after
+				}
+			}
+			// 超出时间预算时返回最后一次的结果, 由调用方处理
+			if p.budget > 0 && time.Since(start)+delay > p.budget {
+				return resp, err
+			}
+			if resp != nil {
+				// 读取少量剩余内容以便复用连接
+				io.CopyN(io.Discard, resp.Body, 4096)
+				resp.Body.Close()
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			case <-timer.C:
+			}
+		}
+	})
+}
+
+// retryableRequest 判断请求是否可以安全重试: 幂等方法且没有需要重新发送的请求体
+func retryableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// shouldRetry 判断一次请求的结果是否值得重试
+func (p *retryPolicy) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		if req.Context().Err() != nil {
+			return false
+		}
+		// 证书错误不会因重试而改变
+		var certErr *tls.CertificateVerificationError
+		return !errors.As(err, &certErr)
+	}
+	_, ok := p.statuses[resp.StatusCode]
+	return ok
+}
+
+// backoff 返回第 attempt 次重试前的等待时间, 使用带完全抖动的指数退避
+func (p *retryPolicy) backoff(attempt int) time.Duration {
+	d := p.maxDelay
+	if attempt < 32 {
+		if exp := p.baseDelay << attempt; exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(d) + 1))
+}
+
+// parseRetryAfter 解析 Retry-After 头, 支持秒数与 HTTP 日期两种格式
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"ghproxy/config"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRetryClient(t *testing.T, cfg config.RetryConfig) *http.Client {
+	t.Helper()
+	p, err := newRetryPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: p.middleware(http.DefaultTransport)}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			// 模拟连接被重置
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := config.RetryConfig{MaxRetries: 2, BaseDelay: "1ms", MaxDelay: "10ms", Budget: "1s", Statuses: []int{503}}
+
+	t.Run("RetriesUntilSuccess", func(t *testing.T) {
+		calls.Store(0)
+		resp, err := newTestRetryClient(t, cfg).Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+			t.Errorf("status = %d after %d calls, want 200 after 3", resp.StatusCode, calls.Load())
+		}
+	})
+
+	t.Run("NoRetryForPost", func(t *testing.T) {
+		calls.Store(1)
+		resp, err := newTestRetryClient(t, cfg).Post(srv.URL, "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable || calls.Load() != 2 {
+			t.Errorf("status = %d after %d calls, want 503 after 1", resp.StatusCode, calls.Load()-1)
+		}
+	})
+
+	t.Run("RetryAfterExceedsBudget", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer slow.Close()
+		calls.Store(0)
+		start := time.Now()
+		resp, err := newTestRetryClient(t, cfg).Get(slow.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if calls.Load() != 1 || time.Since(start) > time.Second {
+			t.Errorf("got %d calls in %s, want a single call without waiting", calls.Load(), time.Since(start))
+		}
+	})
+}
@@ -44,17 +44,15 @@ func RoutingHandler(cfg *config.Config) touka.HandlerFunc {
 			return
 		}
 
+		// 为rawpath加入https:// 头
+		rawPath = "https://" + rawPath
+
 		// 处理blob/raw路径
 		if matcher == "blob" {
-			rawPath = rawPath[10:]
-			rawPath = "raw.githubusercontent.com" + rawPath
-			rawPath = strings.Replace(rawPath, "/blob/", "/", 1)
+			rawPath = blobToRaw(rawPath)
 			matcher = "raw"
 		}
 
-		// 为rawpath加入https:// 头
-		rawPath = "https://" + rawPath
-
 		switch matcher {
 		case "releases", "blob", "raw", "gist", "api":
 			ChunkedProxyRequest(ctx, c, rawPath, cfg, matcher)
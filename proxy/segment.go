@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"ghproxy/config"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fenthope/reco"
+)
+
+// segmentSettings 为分段并行下载的参数, 未启用时 connections 为 0
+type segmentSettings struct {
+	threshold   int64
+	connections int
+	chunkSize   int64
+	maxRetries  int
+}
+
+var segments segmentSettings
+
+// initSegments 解析分段下载配置
+func initSegments(cfg config.SegmentedConfig) error {
+	segments = segmentSettings{}
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Connections < 2 || cfg.ChunkSize <= 0 || cfg.Threshold <= 0 {
+		return fmt.Errorf("invalid httpc segmented config: connections must be >= 2, chunkSize and threshold must be > 0")
+	}
+	segments = segmentSettings{
+		threshold:   int64(cfg.Threshold) * 1024 * 1024,
+		connections: cfg.Connections,
+		chunkSize:   int64(cfg.ChunkSize) * 1024 * 1024,
+		maxRetries:  cfg.MaxRetries,
+	}
+	return nil
+}
+
+// useSegments 判断响应是否使用分段下载: releases 中超过阈值且可以按范围续传的完整响应
+func useSegments(matcher string, resp *http.Response) bool {
+	return segments.connections > 0 && matcher == "releases" &&
+		resp.ContentLength > segments.threshold && resumable(resp)
+}
+
+// wrapSegmentedBody 将大文件的下载拆分为多个并发的 Range 请求, 按顺序拼接为原响应体
+// 第一段直接读取原响应, 其余各段由最多 connections 个请求并行下载,
+// 已下载但未被读取的分段不超过 connections 个, 内存占用有上限
+// fetch 用于发出分段请求, 须对响应体做与原响应相同的包装 (如停滞检测)
+func wrapSegmentedBody(logger *reco.Logger, matcher string, resp *http.Response, fetch func(*http.Request) (*http.Response, error)) {
+	if !useSegments(matcher, resp) {
+		return
+	}
+	size := resp.ContentLength
+	ctx, cancel := context.WithCancel(resp.Request.Context())
+	s := &segmentedReader{
+		ctx:      ctx,
+		cancel:   cancel,
+		req:      resp.Request,
+		etag:     resp.Header.Get("ETag"),
+		size:     size,
+		settings: segments,
+		fetch:    fetch,
+		logger:   logger,
+		matcher:  matcher,
+		first:    resp.Body,
+		firstEnd: min(segments.chunkSize, size),
+		window:   make(chan struct{}, segments.connections),
+	}
+	n := int((size + s.settings.chunkSize - 1) / s.settings.chunkSize)
+	s.results = make([]chan segmentResult, n)
+	for i := range s.results {
+		s.results[i] = make(chan segmentResult, 1)
+	}
+	logger.Infof("Segmented upstream download: matcher=%s url=%s size=%d segments=%d connections=%d", matcher, resp.Request.URL, size, n, s.settings.connections)
+	go s.dispatch()
+	resp.Body = s
+}
+
+type segmentResult struct {
+	data []byte
+	err  error
+}
+
+// segmentedReader 按顺序返回各分段的内容
+type segmentedReader struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	req      *http.Request
+	etag     string
+	size     int64
+	settings segmentSettings
+	fetch    func(*http.Request) (*http.Response, error)
+	logger   *reco.Logger
+	matcher  string
+
+	first     io.ReadCloser // 原响应体, 提供第一段
+	firstDone bool          // 原响应体已读取完毕或已中断
+	firstEnd  int64         // 第一段的结束位置 (不含)
+	offset    int64         // 已返回的字节数
+
+	results []chan segmentResult
+	window  chan struct{} // 限制已下载未读取与下载中的分段数
+	index   int           // 当前读取的分段
+	cur     []byte
+
+	closeOnce sync.Once
+}
+
+// dispatch 依次为第二段起的各分段启动下载, 窗口已满时等待读取方释放
+func (s *segmentedReader) dispatch() {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := 1; i < len(s.results); i++ {
+		select {
+		case s.window <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+		start := int64(i) * s.settings.chunkSize
+		end := min(start+s.settings.chunkSize, s.size)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := s.fetchSegment(start, end)
+			s.results[i] <- segmentResult{data, err}
+		}(i)
+	}
+}
+
+// fetchSegment 下载 [start, end) 范围的内容, 失败时重试
+func (s *segmentedReader) fetchSegment(start, end int64) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt <= s.settings.maxRetries; attempt++ {
+		if attempt > 0 {
+			s.logger.Warnf("Retrying upstream segment: matcher=%s url=%s range=%d-%d attempt=%d: %v", s.matcher, s.req.URL, start, end-1, attempt, err)
+			timer := time.NewTimer(time.Duration(attempt) * 200 * time.Millisecond)
+			select {
+			case <-s.ctx.Done():
+				timer.Stop()
+				return nil, s.ctx.Err()
+			case <-timer.C:
+			}
+		}
+		var data []byte
+		data, err = s.fetchRange(start, end)
+		if err == nil {
+			return data, nil
+		}
+		if s.ctx.Err() != nil {
+			return nil, s.ctx.Err()
+		}
+	}
+	return nil, fmt.Errorf("segment %d-%d: %w", start, end-1, err)
+}
+
+func (s *segmentedReader) fetchRange(start, end int64) ([]byte, error) {
+	req := s.req.Clone(s.ctx)
+	req.Body = http.NoBody
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	req.Header.Set("If-Range", s.etag)
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	resp, err := s.fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	first, last, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if resp.StatusCode != http.StatusPartialContent || !ok || first != start || last != end-1 || size != s.size {
+		return nil, fmt.Errorf("unexpected segment response %s (Content-Range: %q)", resp.Status, resp.Header.Get("Content-Range"))
+	}
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *segmentedReader) Read(p []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+
+	// 第一段读取原响应, 中断时以 Range 请求补齐剩余部分
+	if s.index == 0 && !s.firstDone {
+		remain := s.firstEnd - s.offset
+		n, err := s.first.Read(p[:min(int64(len(p)), remain)])
+		s.offset += int64(n)
+		if s.offset >= s.firstEnd {
+			s.first.Close()
+			s.firstDone = true
+			s.index = 1
+			return n, nil
+		}
+		if err != nil {
+			s.first.Close()
+			s.firstDone = true
+			if s.ctx.Err() != nil {
+				return n, err
+			}
+			data, ferr := s.fetchSegment(s.offset, s.firstEnd)
+			if ferr != nil {
+				s.cancel()
+				return n, ferr
+			}
+			s.cur = data
+		}
+		return n, nil
+	}
+
+	if len(s.cur) == 0 {
+		select {
+		case res := <-s.results[s.index]:
+			if res.err != nil {
+				s.cancel()
+				return 0, res.err
+			}
+			s.cur = res.data
+		case <-s.ctx.Done():
+			return 0, s.ctx.Err()
+		}
+	}
+	n := copy(p, s.cur)
+	s.cur = s.cur[n:]
+	s.offset += int64(n)
+	if len(s.cur) == 0 {
+		if s.index == 0 {
+			// 第一段的补齐部分读取完毕
+			s.index = 1
+		} else {
+			s.index++
+			<-s.window
+		}
+	}
+	return n, nil
+}
+
+func (s *segmentedReader) Close() error {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		s.first.Close()
+	})
+	return nil
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fenthope/reco"
+)
+
+func TestSegmentedBody(t *testing.T) {
+	content := strings.Repeat("0123456789abcdef", 4096) // 64 KiB
+	var ranges, inflight, maxInflight, failOnce atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rg := r.Header.Get("Range"); rg != "" {
+			ranges.Add(1)
+			n := inflight.Add(1)
+			defer inflight.Add(-1)
+			for {
+				m := maxInflight.Load()
+				if n <= m || maxInflight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			// 某一分段第一次请求失败, 应当被重试
+			if rg == "bytes=32768-40959" && failOnce.CompareAndSwap(0, 1) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	logger, err := reco.New(reco.Config{Output: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	segments = segmentSettings{threshold: 16 * 1024, connections: 3, chunkSize: 8 * 1024, maxRetries: 2}
+	defer func() { segments = segmentSettings{} }()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !useSegments("releases", resp) {
+		t.Fatal("expected segmented download")
+	}
+	if useSegments("raw", resp) {
+		t.Error("only releases should use segmented download")
+	}
+	wrapSegmentedBody(logger, "releases", resp, http.DefaultClient.Do)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(body, []byte(content)) {
+		t.Fatalf("body mismatch: got %d bytes", len(body))
+	}
+	// 共 8 段, 第一段来自原响应, 另有一次重试
+	if ranges.Load() != 8 {
+		t.Errorf("range requests = %d, want 8", ranges.Load())
+	}
+	if maxInflight.Load() < 2 || maxInflight.Load() > 3 {
+		t.Errorf("max concurrent range requests = %d, want 2..3", maxInflight.Load())
+	}
+}
+
+func TestSegmentedBodyFailure(t *testing.T) {
+	content := strings.Repeat("x", 32*1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			// 资源已变化, If-Range 不匹配时返回完整内容
+			w.Header().Set("ETag", `"v2"`)
+			io.WriteString(w, content)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	logger, err := reco.New(reco.Config{Output: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	segments = segmentSettings{threshold: 1024, connections: 2, chunkSize: 8 * 1024, maxRetries: 1}
+	defer func() { segments = segmentSettings{} }()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapSegmentedBody(logger, "releases", resp, http.DefaultClient.Do)
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatal("expected error when segments cannot be fetched")
+	}
+}
@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"ghproxy/config"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outboundSources 上游连接的源地址与协议族设置, 未启用时为 nil
+var outboundSources *sourceSet
+
+// sourceAddrKey 为拨号上下文中记录源地址的键
+type sourceAddrKey struct{}
+
+// sourceClientKey 为请求上下文中记录客户端 IP 的键, 供按客户端哈希选择源地址
+type sourceClientKey struct{}
+
+// withSourceClient 在上下文中记录发起请求的客户端 IP
+func withSourceClient(ctx context.Context, clientIP string) context.Context {
+	return context.WithValue(ctx, sourceClientKey{}, clientIP)
+}
+
+// sourceSet 为上游连接选择本机源地址
+// 每个源地址使用独立的 Transport 副本, 连接复用 (包括 HTTP/2 多路复用) 不会跨越源地址
+type sourceSet struct {
+	addrs      []netip.Addr
+	preferred  []int  // 按 ipFamily 优先选择的源地址下标, 为空时在全部源地址中选择
+	family     string // "", "prefer-ipv4", "prefer-ipv6", "ipv4", "ipv6"
+	hashClient bool
+	next       atomic.Uint64
+
+	mu         sync.Mutex
+	transports map[*http.Transport][]*http.Transport
+}
+
+// newSourceSet 解析源地址配置, 网卡名展开为网卡上除回环与链路本地地址外的全部地址
+func newSourceSet(cfg config.SourceConfig) (*sourceSet, error) {
+	s := &sourceSet{family: cfg.IPFamily, transports: make(map[*http.Transport][]*http.Transport)}
+	switch cfg.Strategy {
+	case "round-robin", "":
+	case "client-hash":
+		s.hashClient = true
+	default:
+		return nil, fmt.Errorf("unsupported httpc source strategy %q", cfg.Strategy)
+	}
+	switch cfg.IPFamily {
+	case "", "prefer-ipv4", "prefer-ipv6", "ipv4", "ipv6":
+	default:
+		return nil, fmt.Errorf("unsupported httpc source ipFamily %q", cfg.IPFamily)
+	}
+
+	for _, entry := range cfg.Addrs {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		addrs, err := sourceAddrs(entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if s.forced() && addr.Is4() != (s.family == "ipv4") {
+				return nil, fmt.Errorf("httpc source %s does not match ipFamily %q", addr, s.family)
+			}
+			// 确认地址可以绑定, 避免运行时所有连接失败
+			ln, err := net.ListenPacket("udp", net.JoinHostPort(addr.String(), "0"))
+			if err != nil {
+				return nil, fmt.Errorf("httpc source %s is not a local address: %w", addr, err)
+			}
+			ln.Close()
+			s.addrs = append(s.addrs, addr)
+		}
+	}
+	if len(s.addrs) == 0 && s.family == "" {
+		return nil, fmt.Errorf("httpc source is enabled but neither addrs nor ipFamily is set")
+	}
+	if want4, prefer := s.family == "prefer-ipv4", strings.HasPrefix(s.family, "prefer-"); prefer {
+		for i, addr := range s.addrs {
+			if addr.Is4() == want4 {
+				s.preferred = append(s.preferred, i)
+			}
+		}
+	}
+	return s, nil
+}
+
+// sourceAddrs 解析单个源地址配置, 可以是 IP 地址或网卡名
+func sourceAddrs(entry string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	iface, err := net.InterfaceByName(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid httpc source %q: %w", entry, err)
+	}
+	ifaddrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("httpc source %q: %w", entry, err)
+	}
+	var addrs []netip.Addr
+	for _, a := range ifaddrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		if addr := prefix.Addr().Unmap(); addr.IsGlobalUnicast() {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("httpc source interface %q has no usable address", entry)
+	}
+	return addrs, nil
+}
+
+// forced 判断是否只允许一种协议族
+func (s *sourceSet) forced() bool {
+	return s.family == "ipv4" || s.family == "ipv6"
+}
+
+// pick 为请求选择源地址的下标, 按客户端哈希时同一客户端固定使用同一源地址
+func (s *sourceSet) pick(ctx context.Context) int {
+	candidates := s.preferred
+	n := len(s.addrs)
+	if len(candidates) > 0 {
+		n = len(candidates)
+	}
+	var i int
+	if clientIP, _ := ctx.Value(sourceClientKey{}).(string); s.hashClient && clientIP != "" {
+		h := fnv.New32a()
+		h.Write([]byte(clientIP))
+		i = int(h.Sum32() % uint32(n))
+	} else {
+		i = int(s.next.Add(1) % uint64(n))
+	}
+	if len(candidates) > 0 {
+		return candidates[i]
+	}
+	return i
+}
+
+// alternate 返回与 addr 协议族不同的一个源地址, 用于目标主机不支持该协议族时回退
+func (s *sourceSet) alternate(addr netip.Addr) netip.Addr {
+	for _, a := range s.addrs {
+		if a.Is4() != addr.Is4() {
+			return a
+		}
+	}
+	return netip.Addr{}
+}
+
+// dial 用作 Transport.DialContext, 以上下文中记录的源地址建立连接, 并按 ipFamily 选择协议族
+// 未强制协议族时, 连接失败后换用另一协议族重试
+func (s *sourceSet) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	local, _ := ctx.Value(sourceAddrKey{}).(netip.Addr)
+	if local.IsValid() {
+		conn, err := dialFrom(ctx, network, addr, local)
+		if err != nil && ctx.Err() == nil && !s.forced() {
+			if alt := s.alternate(local); alt.IsValid() {
+				if conn, aerr := dialFrom(ctx, network, addr, alt); aerr == nil {
+					return conn, nil
+				}
+			}
+		}
+		return conn, err
+	}
+
+	if network != "tcp" {
+		return dialFrom(ctx, network, addr, local)
+	}
+	switch s.family {
+	case "ipv4":
+		return dialFrom(ctx, "tcp4", addr, local)
+	case "ipv6":
+		return dialFrom(ctx, "tcp6", addr, local)
+	case "prefer-ipv4", "prefer-ipv6":
+		first, second := "tcp4", "tcp6"
+		if s.family == "prefer-ipv6" {
+			first, second = second, first
+		}
+		conn, err := dialFrom(ctx, first, addr, local)
+		if err != nil && ctx.Err() == nil {
+			if conn, serr := dialFrom(ctx, second, addr, local); serr == nil {
+				return conn, nil
+			}
+		}
+		return conn, err
+	}
+	return dialFrom(ctx, network, addr, local)
+}
+
+// dialFrom 以 local 为源地址建立连接, local 无效时由系统选择, 配置了上游解析器时经解析器连接
+func dialFrom(ctx context.Context, network, addr string, local netip.Addr) (net.Conn, error) {
+	var laddr *net.TCPAddr
+	if local.IsValid() {
+		laddr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(local, 0))
+	}
+	if upstreamResolver != nil {
+		return upstreamResolver.DialContextFrom(ctx, network, addr, laddr)
+	}
+	d := net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if laddr != nil {
+		d.LocalAddr = laddr
+		if network == "tcp" {
+			network = "tcp6"
+			if local.Is4() {
+				network = "tcp4"
+			}
+		}
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// transportsFor 返回 t 为每个源地址复制的 Transport, 首次使用时创建
+func (s *sourceSet) transportsFor(t *http.Transport) []*http.Transport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ts, ok := s.transports[t]; ok {
+		return ts
+	}
+	dial := t.DialContext
+	if dial == nil {
+		dial = s.dial
+	}
+	ts := make([]*http.Transport, len(s.addrs))
+	for i, addr := range s.addrs {
+		c := t.Clone()
+		c.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dial(context.WithValue(ctx, sourceAddrKey{}, addr), network, address)
+		}
+		if c.DialTLSContext != nil {
+			// 与 HTTPS 代理的 TLS 连接同样经所选源地址建立
+			c.DialTLSContext = dialUpstreamTLS(c)
+		}
+		ts[i] = c
+	}
+	s.transports[t] = ts
+	return ts
+}
+
+// roundTrip 经所选源地址对应的 Transport 发出请求, 未配置源地址时直接使用 t
+func (s *sourceSet) roundTrip(t *http.Transport, req *http.Request) (*http.Response, error) {
+	if s == nil || len(s.addrs) == 0 {
+		return t.RoundTrip(req)
+	}
+	return s.transportsFor(t)[s.pick(req.Context())].RoundTrip(req)
+}
+
+// setTransportSource 使 Transport 以所选源地址与协议族建立连接
+// 须在 setTransportResolver 之后, 设置出站代理之前调用
+func setTransportSource(transport *http.Transport) {
+	if outboundSources != nil {
+		transport.DialContext = outboundSources.dial
+	}
+}
+
+// initSources 根据 [httpc.source] 配置创建源地址设置
+func initSources(cfg *config.Config) error {
+	outboundSources = nil
+	if !cfg.Httpc.Source.Enabled {
+		return nil
+	}
+	s, err := newSourceSet(cfg.Httpc.Source)
+	if err != nil {
+		return err
+	}
+	outboundSources = s
+	log.Printf("Binding upstream connections to %d source addresses (strategy: %s, ipFamily: %q)", len(s.addrs), cfg.Httpc.Source.Strategy, s.family)
+	return nil
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"ghproxy/config"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSourceSet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		io.WriteString(w, host)
+	}))
+	defer srv.Close()
+
+	get := func(t *testing.T, s *sourceSet, base *http.Transport, clientIP string) string {
+		t.Helper()
+		ctx := context.Background()
+		if clientIP != "" {
+			ctx = withSourceClient(ctx, clientIP)
+		}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		resp, err := s.roundTrip(base, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	t.Run("RoundRobin", func(t *testing.T) {
+		s, err := newSourceSet(config.SourceConfig{Addrs: []string{"127.0.0.2", "127.0.0.3"}})
+		if err != nil {
+			t.Skipf("cannot bind loopback aliases: %v", err)
+		}
+		base := &http.Transport{DialContext: s.dial}
+		// 连接复用时仍按源地址轮换
+		seen := map[string]int{}
+		for range 4 {
+			seen[get(t, s, base, "")]++
+		}
+		if seen["127.0.0.2"] != 2 || seen["127.0.0.3"] != 2 {
+			t.Errorf("source addresses = %v", seen)
+		}
+	})
+
+	t.Run("ClientHash", func(t *testing.T) {
+		s, err := newSourceSet(config.SourceConfig{Addrs: []string{"127.0.0.2", "127.0.0.3", "127.0.0.4"}, Strategy: "client-hash"})
+		if err != nil {
+			t.Skipf("cannot bind loopback aliases: %v", err)
+		}
+		base := &http.Transport{DialContext: s.dial}
+		for _, client := range []string{"198.51.100.1", "198.51.100.2", "2001:db8::1"} {
+			first := get(t, s, base, client)
+			for range 3 {
+				if got := get(t, s, base, client); got != first {
+					t.Errorf("client %s used %s then %s", client, first, got)
+				}
+			}
+		}
+	})
+
+	t.Run("IPFamily", func(t *testing.T) {
+		s, err := newSourceSet(config.SourceConfig{IPFamily: "ipv4"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := get(t, s, &http.Transport{DialContext: s.dial}, ""); got != "127.0.0.1" {
+			t.Errorf("remote = %s", got)
+		}
+		s.family = "ipv6"
+		if _, err := s.dial(context.Background(), "tcp", srv.Listener.Addr().String()); err == nil {
+			t.Error("ipv6 only dial to an ipv4 address should fail")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, cfg := range []config.SourceConfig{
+			{},
+			{Addrs: []string{"127.0.0.1"}, IPFamily: "ipv6"},
+			{Addrs: []string{"192.0.2.123"}},
+			{Addrs: []string{"no-such-iface0"}},
+			{Addrs: []string{"127.0.0.1"}, Strategy: "random"},
+		} {
+			if _, err := newSourceSet(cfg); err == nil {
+				t.Errorf("newSourceSet(%+v) should fail", cfg)
+			}
+		}
+	})
+}
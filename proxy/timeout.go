@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ghproxy/config"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fenthope/reco"
+)
+
+// upstreamTimeouts 为上游请求各阶段的超时, 0 表示不设置
+type upstreamTimeouts struct {
+	dial           time.Duration
+	tlsHandshake   time.Duration
+	responseHeader time.Duration
+	bodyStall      time.Duration
+}
+
+var (
+	clientTimeouts upstreamTimeouts // 主客户端
+	gitTimeouts    upstreamTimeouts // git 客户端
+)
+
+// errBodyStalled 表示上游响应体长时间没有数据, 传输已被中止
+var errBodyStalled = errors.New("upstream body stalled")
+
+// parseTimeouts 解析超时配置, 空值视为不设置
+func parseTimeouts(section string, cfg config.TimeoutsConfig) (upstreamTimeouts, error) {
+	var t upstreamTimeouts
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"dial", cfg.Dial, &t.dial},
+		{"tlsHandshake", cfg.TLSHandshake, &t.tlsHandshake},
+		{"responseHeader", cfg.ResponseHeader, &t.responseHeader},
+		{"bodyStall", cfg.BodyStall, &t.bodyStall},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return t, fmt.Errorf("invalid httpc %s %s %q: %w", section, d.name, d.value, err)
+		}
+		*d.dst = v
+	}
+	return t, nil
+}
+
+// applyTransportTimeouts 为 Transport 设置连接各阶段的超时, 须在设置出站代理之后调用
+// 连接超时包装最终的拨号函数, 对直连, 代理池与 SOCKS5 代理均生效
+func applyTransportTimeouts(transport *http.Transport, t upstreamTimeouts) {
+	if t.dial > 0 {
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext
+		}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, t.dial)
+			defer cancel()
+			return dial(ctx, network, addr)
+		}
+	}
+	if t.tlsHandshake > 0 {
+		transport.TLSHandshakeTimeout = t.tlsHandshake
+	}
+	transport.ResponseHeaderTimeout = t.responseHeader
+}
+
+// wrapStallBody 在上游响应体长时间没有数据时中止传输
+// 只在等待上游数据时计时, 客户端读取缓慢不会触发
+func wrapStallBody(logger *reco.Logger, timeout time.Duration, matcher string, resp *http.Response) {
+	if timeout <= 0 || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+	s := &stallReader{rc: resp.Body}
+	s.timer = time.AfterFunc(timeout, func() {
+		s.stalled.Store(true)
+		logger.Warnf("Aborting stalled upstream body after %s: matcher=%s url=%s", timeout, matcher, resp.Request.URL)
+		s.rc.Close()
+	})
+	s.timer.Stop()
+	s.timeout = timeout
+	resp.Body = s
+}
+
+// stallReader 为每次读取上游数据计时, 超时后关闭上游响应体使读取返回
+type stallReader struct {
+	rc      io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+	stalled atomic.Bool
+	once    sync.Once
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	s.timer.Reset(s.timeout)
+	n, err := s.rc.Read(p)
+	s.timer.Stop()
+	if err != nil && s.stalled.Load() {
+		return n, errBodyStalled
+	}
+	return n, err
+}
+
+func (s *stallReader) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.timer.Stop()
+		err = s.rc.Close()
+	})
+	return err
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenthope/reco"
+)
+
+func TestStallBody(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/stall" {
+			<-release
+			return
+		}
+		io.WriteString(w, " done")
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	var logs bytes.Buffer
+	logger, err := reco.New(reco.Config{Level: reco.LevelDebug, Output: &logs})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Aborted", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/stall")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapStallBody(logger, 50*time.Millisecond, "releases", resp)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if !errors.Is(err, errBodyStalled) {
+			t.Fatalf("err = %v, want errBodyStalled", err)
+		}
+		if string(body) != "partial" {
+			t.Errorf("body = %q", body)
+		}
+		logger.Close()
+		if !strings.Contains(logs.String(), "matcher=releases url="+srv.URL+"/stall") {
+			t.Errorf("log = %q", logs.String())
+		}
+	})
+
+	t.Run("SlowClient", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/ok")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapStallBody(logger, 50*time.Millisecond, "raw", resp)
+		defer resp.Body.Close()
+		// 客户端读取缓慢不应中止传输
+		time.Sleep(120 * time.Millisecond)
+		body, err := io.ReadAll(resp.Body)
+		if err != nil || string(body) != "partial done" {
+			t.Fatalf("body = %q, err = %v", body, err)
+		}
+	})
+}
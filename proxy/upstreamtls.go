@@ -0,0 +1,366 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"ghproxy/config"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WJQSERVER-STUDIO/httpc"
+)
+
+// hostTLSConfigs 按上游主机 (不含端口, 小写) 指定的 TLS 配置, 由 instances 的 caFile 生成
+var hostTLSConfigs map[string]*tls.Config
+
+// tlsRule 为一组上游主机指定的 TLS 配置, 来自 [[httpc.tls]]
+type tlsRule struct {
+	hosts   []string // 主机名或 path.Match 通配符, 小写
+	caFiles []string
+	config  *tls.Config
+	// instances 为规则匹配的实例主机合并了实例 caFile 的配置, 由 initUpstreamTLS 生成
+	instances map[string]*tls.Config
+}
+
+// match 判断 host 是否匹配该规则
+func (r *tlsRule) match(host string) bool {
+	for _, pattern := range r.hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsRules 按配置顺序匹配, 与 hostTLSConfigs 合并
+var tlsRules []*tlsRule
+
+// upstreamTLSConfig 返回与 host 建立 TLS 连接时使用的配置, 未指定时返回 nil
+func upstreamTLSConfig(host string) *tls.Config {
+	host = strings.ToLower(host)
+	for _, r := range tlsRules {
+		if r.match(host) {
+			if cfg, ok := r.instances[host]; ok {
+				return cfg
+			}
+			return r.config
+		}
+	}
+	return hostTLSConfigs[host]
+}
+
+// tlsVariantKey 标识基于某个 Transport 为某个 TLS 配置复制的 Transport
+type tlsVariantKey struct {
+	base *http.Transport
+	tls  *tls.Config
+}
+
+var (
+	tlsVariantsMu sync.Mutex
+	tlsVariants   = make(map[tlsVariantKey]*http.Transport)
+)
+
+// transportForHost 返回发往 host 的请求应使用的 Transport
+// 主机配置了 TLS, 或 Transport 可能经 HTTPS 代理出站时返回 t 的副本, 同一 Transport 与配置只复制一次, 以便复用连接
+func transportForHost(t *http.Transport, host string) *http.Transport {
+	cfg := upstreamTLSConfig(host)
+	viaProxy := t.Proxy != nil && len(tlsRules) > 0
+	if cfg == nil && !viaProxy {
+		return t
+	}
+	key := tlsVariantKey{base: t, tls: cfg}
+	tlsVariantsMu.Lock()
+	defer tlsVariantsMu.Unlock()
+	if v, ok := tlsVariants[key]; ok {
+		return v
+	}
+	v := t.Clone()
+	if cfg != nil {
+		v.TLSClientConfig = cfg.Clone()
+	}
+	if viaProxy {
+		v.DialTLSContext = dialUpstreamTLS(v)
+	}
+	tlsVariants[key] = v
+	return v
+}
+
+// dialUpstreamTLS 返回 t 的 DialTLSContext, 按所连接的主机选择 TLS 配置
+// Transport 只对第一段 TLS 连接使用 DialTLSContext: 经 HTTPS 代理时为与代理的连接, 按代理主机匹配规则,
+// 直连时为与上游的连接; 经代理隧道与上游的 TLS 连接仍使用 TLSClientConfig
+func dialUpstreamTLS(t *http.Transport) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		dial := t.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// 没有规则匹配时与 Transport 的默认行为一致, 使用 TLSClientConfig
+		cfg := upstreamTLSConfig(host)
+		if cfg == nil {
+			cfg = t.TLSClientConfig
+		}
+		if cfg == nil {
+			cfg = &tls.Config{}
+		} else {
+			cfg = cfg.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = host
+		}
+		if len(cfg.NextProtos) == 0 {
+			cfg.NextProtos = transportNextProtos(t)
+		}
+		if t.TLSHandshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, t.TLSHandshakeTimeout)
+			defer cancel()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// transportNextProtos 返回与 Transport 自身建立的 TLS 连接一致的 ALPN 设置
+// 启用 HTTP/2 时 Transport 在首次请求前写入 TLSClientConfig; 否则按其配置的协议推断, 未启用 HTTP/2 时只协商 HTTP/1.1
+func transportNextProtos(t *http.Transport) []string {
+	if t.TLSClientConfig != nil && len(t.TLSClientConfig.NextProtos) > 0 {
+		return t.TLSClientConfig.NextProtos
+	}
+	var h2 bool
+	switch {
+	case t.TLSNextProto != nil:
+		_, h2 = t.TLSNextProto["h2"]
+	case t.Protocols != nil:
+		h2 = t.Protocols.HTTP2()
+	default:
+		h2 = t.ForceAttemptHTTP2
+	}
+	if h2 {
+		return []string{"h2", "http/1.1"}
+	}
+	return []string{"http/1.1"}
+}
+
+// roundTripUpstream 经 t 发出请求, 按上游主机选择 TLS 配置, 按源地址设置选择连接
+func roundTripUpstream(t *http.Transport, req *http.Request) (*http.Response, error) {
+	return outboundSources.roundTrip(transportForHost(t, req.URL.Hostname()), req)
+}
+
+// upstreamMiddleware 返回按上游主机与源地址选择 Transport 的 httpc 中间件, 须作为最内层中间件
+func upstreamMiddleware(next http.RoundTripper) http.RoundTripper {
+	t, ok := next.(*http.Transport)
+	if !ok {
+		return next
+	}
+	return httpc.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return roundTripUpstream(t, req)
+	})
+}
+
+// needUpstreamMiddleware 判断是否需要安装 upstreamMiddleware
+func needUpstreamMiddleware() bool {
+	return outboundSources != nil || len(hostTLSConfigs) > 0 || len(tlsRules) > 0
+}
+
+// resetTLSVariants 丢弃已复制的 Transport, 在重新初始化客户端时调用
+func resetTLSVariants() {
+	tlsVariantsMu.Lock()
+	defer tlsVariantsMu.Unlock()
+	for _, v := range tlsVariants {
+		v.CloseIdleConnections()
+	}
+	tlsVariants = make(map[tlsVariantKey]*http.Transport)
+}
+
+// appendCAFile 将 PEM 格式的 CA 证书文件加入 pool
+func appendCAFile(pool *x509.CertPool, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in %s", file)
+	}
+	return nil
+}
+
+// loadCAFile 读取 PEM 格式的 CA 证书文件
+func loadCAFile(file string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if err := appendCAFile(pool, file); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseSPKIPin 解析 SubjectPublicKeyInfo 的 SHA-256 摘要, 可带 "sha256/" 前缀
+func parseSPKIPin(pin string) ([]byte, error) {
+	pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+	sum, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid spki pin %q: want base64 encoded sha256", pin)
+	}
+	return sum, nil
+}
+
+// verifyPins 返回检查证书链中是否有公钥与 pins 匹配的 VerifyConnection 函数
+// 经 HTTPS 代理时, 与代理的连接也使用该配置, 跳过不属于规则的主机 (IP 地址不发送 SNI, ServerName 为空)
+func (r *tlsRule) verifyPins(pins [][]byte) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if cs.ServerName != "" && !r.match(strings.ToLower(cs.ServerName)) {
+			return nil
+		}
+		chains := cs.VerifiedChains
+		if len(chains) == 0 {
+			chains = [][]*x509.Certificate{cs.PeerCertificates}
+		}
+		for _, chain := range chains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if bytes.Equal(sum[:], pin) {
+						return nil
+					}
+				}
+			}
+		}
+		return fmt.Errorf("no certificate of %s matches the configured spki pins", cs.ServerName)
+	}
+}
+
+// newTLSRule 解析一条 [[httpc.tls]] 配置
+func newTLSRule(i int, cfg config.UpstreamTLSConfig) (*tlsRule, error) {
+	r := &tlsRule{config: &tls.Config{}}
+	for _, h := range cfg.Hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		if _, err := path.Match(h, ""); err != nil {
+			return nil, fmt.Errorf("httpc tls %d: invalid host pattern %q: %w", i, h, err)
+		}
+		r.hosts = append(r.hosts, h)
+	}
+	if len(r.hosts) == 0 {
+		return nil, fmt.Errorf("httpc tls %d: hosts is empty", i)
+	}
+
+	r.caFiles = cfg.CAFiles
+	if len(cfg.CAFiles) > 0 {
+		// 附加的 CA 证书与系统根证书同时生效
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range cfg.CAFiles {
+			if err := appendCAFile(pool, file); err != nil {
+				return nil, fmt.Errorf("httpc tls %d: %w", i, err)
+			}
+		}
+		r.config.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("httpc tls %d: certFile and keyFile must be set together", i)
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("httpc tls %d: %w", i, err)
+		}
+		r.config.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("httpc tls %d: unsupported minVersion %q", i, cfg.MinVersion)
+		}
+		r.config.MinVersion = v
+	}
+	if len(cfg.SPKIPins) > 0 {
+		pins := make([][]byte, 0, len(cfg.SPKIPins))
+		for _, p := range cfg.SPKIPins {
+			pin, err := parseSPKIPin(p)
+			if err != nil {
+				return nil, fmt.Errorf("httpc tls %d: %w", i, err)
+			}
+			pins = append(pins, pin)
+		}
+		r.config.VerifyConnection = r.verifyPins(pins)
+	}
+	return r, nil
+}
+
+// mergeInstances 为规则匹配的实例主机生成合并了实例 caFile 的配置
+// 实例的 CA 证书代替系统根证书, 规则的 caFiles 附加于其上, 其余设置来自规则
+func (r *tlsRule) mergeInstances(i int) error {
+	r.instances = nil
+	for host, ic := range hostTLSConfigs {
+		if !r.match(host) || ic.RootCAs == nil {
+			continue
+		}
+		pool := ic.RootCAs.Clone()
+		for _, file := range r.caFiles {
+			if err := appendCAFile(pool, file); err != nil {
+				return fmt.Errorf("httpc tls %d: %w", i, err)
+			}
+		}
+		merged := r.config.Clone()
+		merged.RootCAs = pool
+		if r.instances == nil {
+			r.instances = make(map[string]*tls.Config)
+		}
+		r.instances[host] = merged
+	}
+	return nil
+}
+
+// initUpstreamTLS 根据 [[httpc.tls]] 配置设置各上游主机的 TLS 配置
+// 规则与实例的 caFile 合并, 须在 initInstances 之后调用
+func initUpstreamTLS(cfg *config.Config) error {
+	tlsRules = nil
+	resetTLSVariants()
+	for i, rc := range cfg.Httpc.TLS {
+		r, err := newTLSRule(i, rc)
+		if err == nil {
+			err = r.mergeInstances(i)
+		}
+		if err != nil {
+			tlsRules = nil
+			return err
+		}
+		tlsRules = append(tlsRules, r)
+		log.Printf("Upstream TLS for %s: caFiles=%d clientCert=%t minVersion=%q pins=%d", strings.Join(r.hosts, ","), len(rc.CAFiles), rc.CertFile != "", rc.MinVersion, len(rc.SPKIPins))
+	}
+	return nil
+}
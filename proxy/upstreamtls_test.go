@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"ghproxy/config"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpstreamTLS(t *testing.T) {
+	dir := t.TempDir()
+	writePEM := func(name, typ string, der []byte) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	// 自签名的客户端证书
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ghproxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "ghproxy"}}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certFile := writePEM("client.pem", "CERTIFICATE", der)
+	keyFile := writePEM("client.key", "EC PRIVATE KEY", keyDER)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := writePEM("ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+	spki := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	pin := "sha256/" + base64.StdEncoding.EncodeToString(spki[:])
+	otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	get := func(rule config.UpstreamTLSConfig) (string, error) {
+		t.Helper()
+		cfg := config.DefaultConfig()
+		cfg.Httpc.TLS = []config.UpstreamTLSConfig{rule}
+		if err := initUpstreamTLS(cfg); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := roundTripUpstream(&http.Transport{}, httptest.NewRequest(http.MethodGet, srv.URL, nil).WithContext(t.Context()))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+	defer initUpstreamTLS(config.DefaultConfig())
+
+	if got, err := get(config.UpstreamTLSConfig{Hosts: []string{"127.0.0.*"}, CAFiles: []string{caFile}, CertFile: certFile, KeyFile: keyFile, SPKIPins: []string{otherPin, pin}}); err != nil || got != "ghproxy" {
+		t.Errorf("mTLS request = %q, %v", got, err)
+	}
+	for name, rule := range map[string]config.UpstreamTLSConfig{
+		"no client cert": {Hosts: []string{"127.0.0.1"}, CAFiles: []string{caFile}},
+		"untrusted":      {Hosts: []string{"127.0.0.1"}, CertFile: certFile, KeyFile: keyFile},
+		"pin mismatch":   {Hosts: []string{"127.0.0.1"}, CAFiles: []string{caFile}, CertFile: certFile, KeyFile: keyFile, SPKIPins: []string{otherPin}},
+		"min version":    {Hosts: []string{"127.0.0.1"}, CAFiles: []string{caFile}, CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"},
+	} {
+		if _, err := get(rule); err == nil {
+			t.Errorf("%s: request should fail", name)
+		}
+	}
+
+	// 规则与实例的 caFile 合并: 实例提供 CA 证书, 规则提供客户端证书
+	icfg := config.DefaultConfig()
+	icfg.Instances = []config.InstanceConfig{{Name: "corp", Web: srv.URL, CAFile: caFile}}
+	if err := initInstances(icfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := get(config.UpstreamTLSConfig{Hosts: []string{"127.0.0.1"}, CertFile: certFile, KeyFile: keyFile}); err != nil || got != "ghproxy" {
+		t.Errorf("rule merged with instance caFile = %q, %v", got, err)
+	}
+	if err := initInstances(config.DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	// HTTPS 代理: 按代理主机匹配的规则作用于与代理的连接, 隧道内与上游的连接使用上游的规则
+	proxyKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	proxyDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "localhost"}}, &proxyKey.PublicKey, proxyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyCAFile := writePEM("proxy-ca.pem", "CERTIFICATE", proxyDER)
+	proxySrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+	}))
+	proxySrv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{proxyDER}, PrivateKey: proxyKey}}}
+	proxySrv.StartTLS()
+	defer proxySrv.Close()
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	proxyURL.Host = net.JoinHostPort("localhost", proxyURL.Port())
+
+	viaProxy := func(rules ...config.UpstreamTLSConfig) (string, error) {
+		t.Helper()
+		cfg := config.DefaultConfig()
+		cfg.Httpc.TLS = rules
+		if err := initUpstreamTLS(cfg); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := roundTripUpstream(&http.Transport{Proxy: http.ProxyURL(proxyURL)}, httptest.NewRequest(http.MethodGet, srv.URL, nil).WithContext(t.Context()))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+	upstreamRule := config.UpstreamTLSConfig{Hosts: []string{"127.0.0.1"}, CAFiles: []string{caFile}, CertFile: certFile, KeyFile: keyFile}
+	if _, err := viaProxy(upstreamRule); err == nil {
+		t.Error("request through an untrusted HTTPS proxy should fail")
+	}
+	if got, err := viaProxy(upstreamRule, config.UpstreamTLSConfig{Hosts: []string{"localhost"}, CAFiles: []string{proxyCAFile}}); err != nil || got != "ghproxy" {
+		t.Errorf("request through HTTPS proxy = %q, %v", got, err)
+	}
+
+	for _, rule := range []config.UpstreamTLSConfig{
+		{},
+		{Hosts: []string{"["}},
+		{Hosts: []string{"a"}, CAFiles: []string{certFile + ".missing"}},
+		{Hosts: []string{"a"}, CertFile: certFile},
+		{Hosts: []string{"a"}, MinVersion: "1.4"},
+		{Hosts: []string{"a"}, SPKIPins: []string{"sha256/abc"}},
+	} {
+		cfg := config.DefaultConfig()
+		cfg.Httpc.TLS = []config.UpstreamTLSConfig{rule}
+		if err := initUpstreamTLS(cfg); err == nil {
+			t.Errorf("rule %+v should be rejected", rule)
+		}
+	}
+}
+
+func TestDialUpstreamTLSNextProtos(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.Httpc.TLS = []config.UpstreamTLSConfig{{Hosts: []string{"127.0.0.1"}, CAFiles: []string{caFile}}}
+	if err := initUpstreamTLS(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer initUpstreamTLS(config.DefaultConfig())
+
+	h2 := new(http.Protocols)
+	h2.SetHTTP1(true)
+	h2.SetHTTP2(true)
+	// 基础 Transport 未设置 TLSClientConfig 时, 按其启用的协议协商
+	for _, tc := range []struct {
+		name string
+		t    *http.Transport
+		h2   bool
+	}{
+		{"Protocols", &http.Transport{Protocols: h2}, true},
+		{"ForceAttemptHTTP2", &http.Transport{ForceAttemptHTTP2: true}, true},
+		{"HTTP1Only", &http.Transport{TLSNextProto: map[string]func(string, *tls.Conn) http.RoundTripper{}}, false},
+	} {
+		conn, err := dialUpstreamTLS(tc.t)(t.Context(), "tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := conn.(*tls.Conn).ConnectionState().NegotiatedProtocol; (got == "h2") != tc.h2 {
+			t.Errorf("%s: negotiated %q", tc.name, got)
+		}
+		conn.Close()
+	}
+}
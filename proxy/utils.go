@@ -60,3 +60,17 @@ func authCheck(c *touka.Context, cfg *config.Config, matcher string, rawPath str
 
 	return false
 }
+
+// setCorsHeader 按配置设置 Access-Control-Allow-Origin 响应头
+func setCorsHeader(c *touka.Context, cfg *config.Config) {
+	switch cfg.Server.Cors {
+	case "*":
+		c.Header("Access-Control-Allow-Origin", "*")
+	case "":
+		c.Header("Access-Control-Allow-Origin", "*")
+	case "nil":
+		c.Header("Access-Control-Allow-Origin", "")
+	default:
+		c.Header("Access-Control-Allow-Origin", cfg.Server.Cors)
+	}
+}
@@ -0,0 +1,114 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohLookuper 通过 DNS over HTTPS (RFC 8484) 查询
+// DoH 服务器直接连接, 不经过出站代理; 其地址建议使用 IP, 否则需要系统 DNS 能够解析
+type dohLookuper struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHLookuper(url string, timeout time.Duration) *dohLookuper {
+	return &dohLookuper{
+		url: url,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{ForceAttemptHTTP2: true, IdleConnTimeout: 90 * time.Second},
+		},
+	}
+}
+
+// lookup 同时查询 A 与 AAAA 记录, 任一查询有结果即可
+func (l *dohLookuper) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	type result struct {
+		addrs []netip.Addr
+		err   error
+	}
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	results := make(chan result, len(types))
+	for _, qtype := range types {
+		go func(qtype dnsmessage.Type) {
+			addrs, err := l.query(ctx, host, qtype)
+			results <- result{addrs, err}
+		}(qtype)
+	}
+
+	var addrs []netip.Addr
+	var errs []error
+	for range types {
+		res := <-results
+		addrs = append(addrs, res.addrs...)
+		if res.err != nil {
+			errs = append(errs, res.err)
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (l *dohLookuper) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]netip.Addr, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, err
+	}
+	// RFC 8484 建议 ID 为 0 以便 HTTP 缓存
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("resolver: doh %s returned %s", l.url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	var reply dnsmessage.Message
+	if err := reply.Unpack(body); err != nil {
+		return nil, fmt.Errorf("resolver: invalid doh response: %w", err)
+	}
+	if reply.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("resolver: doh lookup %s: %s", host, reply.RCode)
+	}
+	var addrs []netip.Addr
+	for _, answer := range reply.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, netip.AddrFrom4(body.A))
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, netip.AddrFrom16(body.AAAA).Unmap())
+		}
+	}
+	return addrs, nil
+}
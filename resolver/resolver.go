@@ -0,0 +1,318 @@
+// Package resolver 为上游连接提供自定义的域名解析
+//
+// 解析顺序:
+//   - hosts 中的静态记录, 支持 "*.example.com" 形式的通配符
+//   - 依次尝试配置的 DNS 服务器 (udp/tcp/DoH), 未配置时使用系统 DNS
+//
+// 一个主机有多个地址时, 拨号按健康状态排序依次尝试, 连接失败的地址在冷却时间内排在最后
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings 定义解析器的参数
+type Settings struct {
+	Servers      []string            // DNS 服务器, 如 udp://1.1.1.1:53, tcp://8.8.8.8:53, https://1.1.1.1/dns-query
+	Hosts        map[string][]string // 静态记录, 主机名到 IP 列表
+	Timeout      time.Duration       // 单次查询的超时时间
+	CacheTTL     time.Duration       // 查询结果的缓存时间, <=0 表示不缓存
+	DialTimeout  time.Duration       // 连接单个地址的超时时间
+	FailCooldown time.Duration       // 连接失败的地址排在最后的时间
+}
+
+// lookuper 向一个 DNS 服务器查询主机的地址
+type lookuper interface {
+	lookup(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// Resolver 为自定义解析器, 零值不可用, 须使用 New 创建
+type Resolver struct {
+	settings  Settings
+	hosts     map[string][]netip.Addr
+	wildcards map[string][]netip.Addr // 去掉 "*." 后的后缀
+	servers   []lookuper
+	dialer    net.Dialer
+	next      atomic.Uint64
+
+	mu     sync.Mutex
+	cache  map[string]cacheEntry
+	failed map[netip.Addr]time.Time // 地址到冷却结束时间
+}
+
+type cacheEntry struct {
+	addrs   []netip.Addr
+	expires time.Time
+}
+
+// New 创建解析器
+func New(settings Settings) (*Resolver, error) {
+	if settings.Timeout <= 0 {
+		settings.Timeout = 5 * time.Second
+	}
+	r := &Resolver{
+		settings:  settings,
+		hosts:     make(map[string][]netip.Addr),
+		wildcards: make(map[string][]netip.Addr),
+		dialer:    net.Dialer{Timeout: settings.DialTimeout, KeepAlive: 30 * time.Second},
+		cache:     make(map[string]cacheEntry),
+		failed:    make(map[netip.Addr]time.Time),
+	}
+	for host, ips := range settings.Hosts {
+		host = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(host), "."))
+		addrs := make([]netip.Addr, 0, len(ips))
+		for _, ip := range ips {
+			addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+			if err != nil {
+				return nil, fmt.Errorf("resolver: invalid address %q for %s: %w", ip, host, err)
+			}
+			addrs = append(addrs, addr.Unmap())
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("resolver: no address for %s", host)
+		}
+		if suffix, ok := strings.CutPrefix(host, "*."); ok {
+			r.wildcards[suffix] = addrs
+		} else {
+			r.hosts[host] = addrs
+		}
+	}
+	for _, server := range settings.Servers {
+		l, err := newLookuper(server, settings.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		r.servers = append(r.servers, l)
+	}
+	return r, nil
+}
+
+// newLookuper 根据服务器地址的协议创建查询器
+func newLookuper(server string, timeout time.Duration) (lookuper, error) {
+	u, err := url.Parse(strings.TrimSpace(server))
+	if err != nil {
+		return nil, fmt.Errorf("resolver: invalid server %q: %w", server, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "53")
+		}
+		return newNetLookuper(u.Scheme, addr, timeout), nil
+	case "https":
+		return newDoHLookuper(u.String(), timeout), nil
+	}
+	return nil, fmt.Errorf("resolver: unsupported server scheme %q", u.Scheme)
+}
+
+// static 返回主机的静态记录
+func (r *Resolver) static(host string) ([]netip.Addr, bool) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, true
+	}
+	for suffix := host; ; {
+		_, rest, ok := strings.Cut(suffix, ".")
+		if !ok {
+			return nil, false
+		}
+		if addrs, ok := r.wildcards[rest]; ok {
+			return addrs, true
+		}
+		suffix = rest
+	}
+}
+
+// LookupNetIP 返回主机的地址
+// 查询失败时若有过期的缓存则继续使用, 避免 DNS 服务器短暂不可用时中断访问
+func (r *Resolver) LookupNetIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if addrs, ok := r.static(host); ok {
+		return addrs, nil
+	}
+
+	r.mu.Lock()
+	cached, hit := r.cache[host]
+	r.mu.Unlock()
+	if hit && time.Now().Before(cached.expires) {
+		return cached.addrs, nil
+	}
+
+	addrs, err := r.lookup(ctx, host)
+	if err != nil {
+		if hit {
+			return cached.addrs, nil
+		}
+		return nil, err
+	}
+	if r.settings.CacheTTL > 0 {
+		r.mu.Lock()
+		r.cache[host] = cacheEntry{addrs: addrs, expires: time.Now().Add(r.settings.CacheTTL)}
+		r.mu.Unlock()
+	}
+	return addrs, nil
+}
+
+func (r *Resolver) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	if len(r.servers) == 0 {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		for i := range addrs {
+			addrs[i] = addrs[i].Unmap()
+		}
+		return addrs, nil
+	}
+	var errs []error
+	for _, server := range r.servers {
+		addrs, err := server.lookup(ctx, host)
+		if err == nil && len(addrs) > 0 {
+			return addrs, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("no address for %s", host)
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("resolver: lookup %s: %w", host, errors.Join(errs...))
+}
+
+// order 返回拨号的顺序: 健康的地址轮流排在前面, 冷却中的地址按冷却结束时间排在最后
+func (r *Resolver) order(addrs []netip.Addr) []netip.Addr {
+	if len(addrs) <= 1 {
+		return addrs
+	}
+	now := time.Now()
+	start := int(r.next.Add(1) % uint64(len(addrs)))
+	healthy := make([]netip.Addr, 0, len(addrs))
+	var cooling []netip.Addr
+
+	r.mu.Lock()
+	for i := range addrs {
+		addr := addrs[(start+i)%len(addrs)]
+		if until, ok := r.failed[addr]; ok && now.Before(until) {
+			// 插入排序, 冷却先结束的排在前面
+			j := len(cooling)
+			cooling = append(cooling, addr)
+			for j > 0 && r.failed[cooling[j-1]].After(until) {
+				cooling[j] = cooling[j-1]
+				j--
+			}
+			cooling[j] = addr
+			continue
+		}
+		healthy = append(healthy, addr)
+	}
+	r.mu.Unlock()
+	return append(healthy, cooling...)
+}
+
+func (r *Resolver) markFailed(addr netip.Addr) {
+	if r.settings.FailCooldown <= 0 {
+		return
+	}
+	r.mu.Lock()
+	r.failed[addr] = time.Now().Add(r.settings.FailCooldown)
+	r.mu.Unlock()
+}
+
+func (r *Resolver) markHealthy(addr netip.Addr) {
+	r.mu.Lock()
+	delete(r.failed, addr)
+	r.mu.Unlock()
+}
+
+// DialContext 解析 addr 中的主机名后依次连接其地址, 可用作 http.Transport.DialContext
+// TLS 握手由调用方以原主机名进行, SNI 与证书校验不受影响
+func (r *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return r.DialContextFrom(ctx, network, addr, nil)
+}
+
+// DialContextFrom 与 DialContext 相同, 但以 laddr 作为连接的本地地址, laddr 为 nil 时由系统选择
+// 与 laddr 协议族不同的地址会被跳过
+func (r *Resolver) DialContextFrom(ctx context.Context, network, addr string, laddr *net.TCPAddr) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	d := r.dialer
+	if laddr != nil {
+		d.LocalAddr = laddr
+		if network == "tcp" {
+			network = "tcp6"
+			if laddr.IP.To4() != nil {
+				network = "tcp4"
+			}
+		}
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	addrs, err := r.LookupNetIP(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	var errs []error
+	for _, ip := range r.order(addrs) {
+		if (network == "tcp4" && !ip.Is4()) || (network == "tcp6" && !ip.Is6()) {
+			continue
+		}
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			r.markHealthy(ip)
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		r.markFailed(ip)
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("resolver: no %s address for %s", network, host)}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// netLookuper 通过 udp/tcp 查询指定的 DNS 服务器
+type netLookuper struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+}
+
+func newNetLookuper(network, addr string, timeout time.Duration) *netLookuper {
+	var d net.Dialer
+	return &netLookuper{
+		resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+		timeout: timeout,
+	}
+}
+
+func (l *netLookuper) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	addrs, err := l.resolver.LookupNetIP(ctx, "ip", host)
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, err
+}
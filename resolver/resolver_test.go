@@ -0,0 +1,146 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestResolver_Static(t *testing.T) {
+	r, err := New(Settings{Hosts: map[string][]string{
+		"GitHub.com":              {"140.82.112.3", "140.82.113.3"},
+		"*.githubusercontent.com": {"185.199.108.133"},
+	}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for host, want := range map[string]string{
+		"github.com":                "140.82.112.3",
+		"github.com.":               "140.82.112.3",
+		"raw.githubusercontent.com": "185.199.108.133",
+		"a.b.githubusercontent.com": "185.199.108.133",
+	} {
+		addrs, err := r.LookupNetIP(context.Background(), host)
+		if err != nil || len(addrs) == 0 || addrs[0].String() != want {
+			t.Errorf("LookupNetIP(%s) = %v, %v; want %s", host, addrs, err, want)
+		}
+	}
+	if _, ok := r.static("githubusercontent.com"); ok {
+		t.Error("wildcard should not match the bare domain")
+	}
+
+	if _, err := New(Settings{Hosts: map[string][]string{"a": {"not-an-ip"}}}); err == nil {
+		t.Error("expected error for invalid address")
+	}
+	if _, err := New(Settings{Servers: []string{"ftp://1.1.1.1"}}); err == nil {
+		t.Error("expected error for unsupported server")
+	}
+}
+
+func TestResolver_Order(t *testing.T) {
+	r, err := New(Settings{FailCooldown: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.3")
+	r.markFailed(b)
+	r.markFailed(a)
+	for range 3 {
+		got := r.order([]netip.Addr{a, b, c})
+		if got[0] != c || got[1] != b || got[2] != a {
+			t.Fatalf("order = %v, want [c b a]", got)
+		}
+	}
+	r.markHealthy(a)
+	if got := r.order([]netip.Addr{a, b, c}); got[2] != b {
+		t.Fatalf("order = %v, want b last", got)
+	}
+}
+
+// TestResolver_DialFailover 第一个地址拒绝连接时使用下一个地址, 且 TLS 仍以原主机名校验
+func TestResolver_DialFailover(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	// 127.0.0.2 上没有监听, 连接会被拒绝
+	r, err := New(Settings{
+		Hosts:        map[string][]string{"example.com": {"127.0.0.2", "127.0.0.1"}},
+		FailCooldown: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := srv.Client().Transport.(*http.Transport).Clone()
+	tr.DialContext = r.DialContext
+	// httptest 的证书对 example.com 有效
+	tr.TLSClientConfig = &tls.Config{RootCAs: srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	client := &http.Client{Transport: tr}
+
+	for range 2 {
+		resp, err := client.Get("https://example.com:" + port + "/")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "example.com:"+port {
+			t.Fatalf("host = %q", body)
+		}
+		tr.CloseIdleConnections()
+	}
+	if got := r.order([]netip.Addr{netip.MustParseAddr("127.0.0.2"), netip.MustParseAddr("127.0.0.1")}); got[0].String() != "127.0.0.1" {
+		t.Fatalf("failed address not moved last: %v", got)
+	}
+}
+
+func TestResolver_DoH(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad content type", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var q dnsmessage.Message
+		if err := q.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reply := dnsmessage.Message{
+			Header:    dnsmessage.Header{Response: true, RecursionAvailable: true},
+			Questions: q.Questions,
+		}
+		question := q.Questions[0]
+		hdr := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 60}
+		switch question.Type {
+		case dnsmessage.TypeA:
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{140, 82, 112, 3}}})
+		case dnsmessage.TypeAAAA:
+			// 没有 AAAA 记录
+		}
+		packed, _ := reply.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	defer srv.Close()
+
+	l := newDoHLookuper(srv.URL+"/dns-query", time.Second)
+	addrs, err := l.lookup(context.Background(), "github.com")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if len(addrs) != 1 || addrs[0].String() != "140.82.112.3" {
+		t.Fatalf("addrs = %v", addrs)
+	}
+}